@@ -18,19 +18,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
-	"path/filepath"
-	"runtime"
-	"runtime/pprof"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/thediveo/enumflag"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.uber.org/zap/zapcore"
 
 	_ "github.com/apache/apisix-go-plugin-runner/cmd/go-runner/plugins"
 	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+	"github.com/apache/apisix-go-plugin-runner/pkg/plugin"
 	"github.com/apache/apisix-go-plugin-runner/pkg/runner"
 )
 
@@ -73,73 +79,227 @@ var RunModeIds = map[RunMode][]string{
 	Prof: {"prof"},
 }
 
-func openFileToWrite(name string) (*os.File, error) {
-	dir := filepath.Dir(name)
-	if dir != "." {
-		err := os.MkdirAll(dir, 0755)
-		if err != nil {
-			return nil, err
-		}
-	}
-	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		return nil, err
+type TraceExporter enumflag.Flag
+
+const (
+	NoTrace     TraceExporter = iota
+	StdoutTrace               // write the spans to stdout
+	OTLPTrace                 // send the spans via OTLP/HTTP, configured by OTEL_EXPORTER_OTLP_* env
+)
+
+var TraceExporterIds = map[TraceExporter][]string{
+	NoTrace:     {"none"},
+	StdoutTrace: {"stdout"},
+	OTLPTrace:   {"otlp"},
+}
+
+func newTraceExporter(ty TraceExporter) (sdktrace.SpanExporter, error) {
+	switch ty {
+	case StdoutTrace:
+		return stdouttrace.New()
+	case OTLPTrace:
+		return otlptracehttp.New(context.Background())
+	default:
+		return nil, nil
 	}
+}
 
-	return f, nil
+// applyMode sets the log and profile settings of the mode
+func applyMode(cfg *runner.RunnerConfig, mode RunMode) {
+	cfg.LogLevel = zapcore.InfoLevel
+	cfg.LogOutputPath = ""
+	cfg.ProfilePath = ""
+	switch mode {
+	case Prod:
+		cfg.LogLevel = zapcore.WarnLevel
+		cfg.LogOutputPath = LogFilePath
+	case Prof:
+		cfg.LogLevel = zapcore.WarnLevel
+		cfg.ProfilePath = ProfileFilePath
+	}
 }
 
 func newRunCommand() *cobra.Command {
 	var mode RunMode
+	var drainTimeout time.Duration
+	var strictConf bool
+	var strictConfPlugins []string
+	var metricsAddr string
+	var traceExporter TraceExporter
+	var bodyBudget int
+	var concurrency int
+	var readTimeout, writeTimeout time.Duration
+	var maxFrameSize, maxConnMemory int
+	var captureFile string
+	var captureMaxSize, captureMaxFiles int
+	var redact redactFlags
+	var configFile string
 	cmd := &cobra.Command{
 		Use:   "run",
 		Short: "run",
 		Run: func(cmd *cobra.Command, _ []string) {
-			cfg := runner.RunnerConfig{}
-			if mode == Prod {
-				cfg.LogLevel = zapcore.WarnLevel
-				f, err := openFileToWrite(LogFilePath)
-				if err != nil {
-					log.Fatalf("failed to open log: %s", err)
-				}
-				cfg.LogOutput = f
-			} else if mode == Prof {
-				cfg.LogLevel = zapcore.WarnLevel
+			// the flags take precedence over the config file, and their defaults only
+			// apply when the file doesn't set the value
+			flags := cmd.Flags()
 
-				cpuProfileFile := ProfileFilePath + "cpu"
-				f, err := os.Create(cpuProfileFile)
-				if err != nil {
-					log.Fatalf("could not create CPU profile: %s", err)
+			var cfg runner.RunnerConfig
+			if !flags.Changed("mode") {
+				applyMode(&cfg, mode)
+			}
+			if configFile != "" {
+				if err := cfg.Load(configFile); err != nil {
+					log.Fatalf("failed to load config: %s", err)
 				}
-				defer f.Close()
-				if err := pprof.StartCPUProfile(f); err != nil {
-					log.Fatalf("could not start CPU profile: %s", err)
+			}
+			if flags.Changed("mode") {
+				applyMode(&cfg, mode)
+			}
+
+			override := func(name string, zero bool) bool {
+				return flags.Changed(name) || zero
+			}
+			if override("drain-timeout", cfg.DrainTimeout == 0) {
+				cfg.DrainTimeout = drainTimeout
+			}
+			if override("strict-conf", !cfg.StrictConf) {
+				cfg.StrictConf = strictConf
+			}
+			if override("metrics-address", cfg.MetricsAddress == "") {
+				cfg.MetricsAddress = metricsAddr
+			}
+			if override("body-budget", cfg.BodyBudget == 0) {
+				cfg.BodyBudget = bodyBudget
+			}
+			if override("concurrency", cfg.Concurrency == 0) {
+				cfg.Concurrency = concurrency
+			}
+			if override("read-timeout", cfg.ReadTimeout == 0) {
+				cfg.ReadTimeout = readTimeout
+			}
+			if override("write-timeout", cfg.WriteTimeout == 0) {
+				cfg.WriteTimeout = writeTimeout
+			}
+			if override("max-frame-size", cfg.MaxFrameSize == 0) {
+				cfg.MaxFrameSize = maxFrameSize
+			}
+			if override("max-conn-memory", cfg.MaxConnMemory == 0) {
+				cfg.MaxConnMemory = maxConnMemory
+			}
+			if override("capture-file", cfg.CaptureFile == "") {
+				cfg.CaptureFile = captureFile
+			}
+			if override("capture-max-size", cfg.CaptureMaxSize == 0) {
+				cfg.CaptureMaxSize = captureMaxSize
+			}
+			if override("capture-max-files", cfg.CaptureMaxFiles == 0) {
+				cfg.CaptureMaxFiles = captureMaxFiles
+			}
+			if cfg.CaptureFile != "" {
+				cfg.CaptureRedactHeader, cfg.CaptureRedactBody = redact.hooks()
+			}
+			if flags.Changed("strict-conf-plugins") {
+				cfg.PluginStrictConf = map[string]bool{}
+				for _, name := range strictConfPlugins {
+					cfg.PluginStrictConf[name] = true
 				}
-				defer pprof.StopCPUProfile()
-
-				defer func() {
-					memProfileFile := ProfileFilePath + "mem"
-					f, err := os.Create(memProfileFile)
-					if err != nil {
-						log.Fatalf("could not create memory profile: %s", err)
-					}
-					defer f.Close()
-
-					runtime.GC()
-					if err := pprof.WriteHeapProfile(f); err != nil {
-						log.Fatalf("could not write memory profile: %s", err)
-					}
-				}()
 			}
+			exp, err := newTraceExporter(traceExporter)
+			if err != nil {
+				log.Fatalf("failed to create trace exporter: %s", err)
+			}
+			if exp != nil {
+				cfg.TraceExporter = exp
+			}
+
 			runner.Run(cfg)
 		},
 	}
 
+	cmd.PersistentFlags().StringVarP(&configFile, "config", "c", "",
+		"the runner's config file in YAML or JSON; the flags and environment variables take precedence over it")
 	cmd.PersistentFlags().VarP(
 		enumflag.New(&mode, "mode", RunModeIds, enumflag.EnumCaseInsensitive),
 		"mode", "m",
-		"the runner's run mode; can be 'prod' or 'dev', default to 'dev'")
+		"the runner's run mode; can be 'prod', 'prof' or 'dev', default to 'dev'. "+
+			"When it is not given, the log and profile settings of the config file take precedence over the mode")
+	cmd.PersistentFlags().DurationVar(&drainTimeout, "drain-timeout", 5*time.Second,
+		"the max time to wait for the in-flight RPCs when the runner is exiting")
+	cmd.PersistentFlags().BoolVar(&strictConf, "strict-conf", false,
+		"refuse the whole conf when any plugin's conf is invalid, instead of skipping the plugin")
+	cmd.PersistentFlags().StringSliceVar(&strictConfPlugins, "strict-conf-plugins", nil,
+		"the plugins which the strict conf mode is applied to, regardless of --strict-conf")
+	cmd.PersistentFlags().StringVar(&metricsAddr, "metrics-address", "",
+		"the address to expose the Prometheus metrics at /metrics, like ':9091'")
+	cmd.PersistentFlags().Var(
+		enumflag.New(&traceExporter, "exporter", TraceExporterIds, enumflag.EnumCaseInsensitive),
+		"trace-exporter",
+		"the OpenTelemetry trace exporter; can be 'none', 'stdout' or 'otlp', default to 'none'")
+	cmd.PersistentFlags().IntVar(&bodyBudget, "body-budget", 0,
+		"the max size in bytes of the body which a request can read from or write to APISIX, 0 means no limit")
+	cmd.PersistentFlags().IntVar(&concurrency, "concurrency", 0,
+		"the max number of HTTP calls handled concurrently per connection, 0 or 1 means one by one")
+	cmd.PersistentFlags().DurationVar(&readTimeout, "read-timeout", 0,
+		"the max time to read a frame expected from APISIX, 0 means no limit")
+	cmd.PersistentFlags().DurationVar(&writeTimeout, "write-timeout", 0,
+		"the max time to write a frame to APISIX, 0 means no limit")
+	cmd.PersistentFlags().IntVar(&maxFrameSize, "max-frame-size", 0,
+		"the max size in bytes of a frame from APISIX, 0 means no limit")
+	cmd.PersistentFlags().IntVar(&maxConnMemory, "max-conn-memory", 0,
+		"the max size in bytes of the frames held by a connection at the same time, 0 means no limit")
+	cmd.PersistentFlags().StringVar(&captureFile, "capture-file", "",
+		"record the frames from and to APISIX into the file, which can be replayed via `replay`")
+	cmd.PersistentFlags().IntVar(&captureMaxSize, "capture-max-size", 100*1024*1024,
+		"the max size in bytes of a capture file before it is rotated")
+	cmd.PersistentFlags().IntVar(&captureMaxFiles, "capture-max-files", 5,
+		"the number of the capture files kept, including the one being written")
+	redact.register(cmd)
+
+	return cmd
+}
+
+func newSchemaCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "schema [plugin]",
+		Short: "dump the JSON Schema of the plugin's conf, or all plugins' if no plugin is given",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			schemas := plugin.Schemas()
+
+			var out []byte
+			if len(args) == 1 {
+				name := args[0]
+				schema, ok := schemas[name]
+				if !ok {
+					return fmt.Errorf("plugin %s is not found or has no schema", name)
+				}
+				var b bytes.Buffer
+				if err := json.Indent(&b, schema, "", "  "); err != nil {
+					return err
+				}
+				out = b.Bytes()
+			} else {
+				all := make(map[string]json.RawMessage, len(schemas))
+				for name, schema := range schemas {
+					all[name] = schema
+				}
+				var err error
+				out, err = json.MarshalIndent(all, "", "  ")
+				if err != nil {
+					return err
+				}
+			}
+
+			if output != "" {
+				return ioutil.WriteFile(output, append(out, '\n'), 0644)
+			}
+			fmt.Fprintln(InfoOut, string(out))
+			return nil
+		},
+	}
 
+	// the plugins log to stdout when they are registered, so we provide a way to get the clean output
+	cmd.PersistentFlags().StringVarP(&output, "output", "o", "", "write the schema to the file instead of stdout")
 	return cmd
 }
 
@@ -152,6 +312,9 @@ func NewCommand() *cobra.Command {
 
 	cmd.AddCommand(newRunCommand())
 	cmd.AddCommand(newVersionCommand())
+	cmd.AddCommand(newSchemaCommand())
+	cmd.AddCommand(newSimulateCommand())
+	cmd.AddCommand(newReplayCommand())
 	return cmd
 }
 
@@ -159,6 +322,6 @@ func main() {
 	root := NewCommand()
 	if err := root.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
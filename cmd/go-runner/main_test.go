@@ -36,3 +36,14 @@ func TestVersion(t *testing.T) {
 
 	assert.True(t, strings.Contains(b.String(), "Building OS/Arch"))
 }
+
+func TestSchema(t *testing.T) {
+	args := []string{"schema", "fault-injection"}
+	os.Args = append([]string{"cmd"}, args...)
+
+	var b bytes.Buffer
+	InfoOut = &b
+	main()
+
+	assert.True(t, strings.Contains(b.String(), `"http_status": {`))
+}
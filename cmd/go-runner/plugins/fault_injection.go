@@ -56,6 +56,19 @@ func (p *FaultInjection) Name() string {
 	return plugin_name
 }
 
+// Schema is checked before ParseConf. It is also dumped by `go-runner schema fault-injection`.
+func (p *FaultInjection) Schema() []byte {
+	return []byte(`{
+		"type": "object",
+		"properties": {
+			"body": {"type": "string"},
+			"http_status": {"type": "integer", "minimum": 200},
+			"percentage": {"type": "integer", "minimum": 0, "maximum": 100}
+		},
+		"required": ["http_status"]
+	}`)
+}
+
 func (p *FaultInjection) ParseConf(in []byte) (interface{}, error) {
 	conf := FaultInjectionConf{Percentage: -1}
 	err := json.Unmarshal(in, &conf)
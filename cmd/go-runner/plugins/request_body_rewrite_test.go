@@ -19,6 +19,8 @@ package plugins
 
 import (
 	"context"
+	"io"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
@@ -130,3 +132,75 @@ func (*mockHTTPRequest) SrcIP() net.IP {
 func (*mockHTTPRequest) Var(string) ([]byte, error) {
 	panic("unimplemented")
 }
+
+func (*mockHTTPRequest) Host() (string, error) {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) SetHost(string) {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) Scheme() (string, error) {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) RawURI() (string, error) {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) SetRawURI(string) error {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) Cookies() []*http.Cookie {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) Cookie(string) (*http.Cookie, error) {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) AddCookie(*http.Cookie) {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) DelCookie(string) {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) Form() (url.Values, error) {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) MultipartReader() (*multipart.Reader, error) {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) DecodeJSON(interface{}) error {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) SetForm(url.Values) {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) SetMultipartForm(*multipart.Form) error {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) SetJSON(interface{}) error {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) BodyReader() (io.Reader, error) {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) BodyWriter() io.Writer {
+	panic("unimplemented")
+}
+
+func (*mockHTTPRequest) Ctx() pkgHTTP.Store {
+	panic("unimplemented")
+}
@@ -0,0 +1,60 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugins
+
+import (
+	"net/http"
+
+	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+	"github.com/apache/apisix-go-plugin-runner/pkg/plugin"
+)
+
+func init() {
+	err := plugin.RegisterTyped[TypedSayConf](&TypedSay{})
+	if err != nil {
+		log.Fatalf("failed to register plugin typed-say: %s", err)
+	}
+}
+
+// TypedSay is a demo to show how to write a typed plugin. It works like Say,
+// but the conf is decoded from JSON and validated by the framework.
+type TypedSay struct {
+	plugin.DefaultTypedPlugin[TypedSayConf]
+}
+
+type TypedSayConf struct {
+	Body string `json:"body" validate:"required"`
+	// Status is optional, so it is a pointer and only checked when it is set
+	Status *int `json:"status" validate:"min=200,max=599"`
+}
+
+func (p *TypedSay) Name() string {
+	return "typed-say"
+}
+
+func (p *TypedSay) RequestFilter(conf TypedSayConf, w http.ResponseWriter, r pkgHTTP.Request) {
+	w.Header().Add("X-Resp-A6-Runner", "Go")
+	if conf.Status != nil {
+		w.WriteHeader(*conf.Status)
+	}
+	_, err := w.Write([]byte(conf.Body))
+	if err != nil {
+		log.Errorf("failed to write: %s", err)
+	}
+}
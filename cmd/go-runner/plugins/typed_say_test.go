@@ -0,0 +1,52 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugins
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/pkg/plugin"
+)
+
+func TestTypedSay(t *testing.T) {
+	in := []byte(`{"body":"hello","status":201}`)
+	conf, err := plugin.DecodeConf[TypedSayConf](in)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", conf.Body)
+
+	w := httptest.NewRecorder()
+	say := &TypedSay{}
+	say.RequestFilter(conf, w, nil)
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "Go", resp.Header.Get("X-Resp-A6-Runner"))
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestTypedSay_BadConf(t *testing.T) {
+	_, err := plugin.DecodeConf[TypedSayConf]([]byte(`{}`))
+	assert.Equal(t, "body: is required", err.Error())
+
+	_, err = plugin.DecodeConf[TypedSayConf]([]byte(`{"body":"hello","status":600}`))
+	assert.Equal(t, "status: value should be <= 599, got 600", err.Error())
+}
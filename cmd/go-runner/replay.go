@@ -0,0 +1,130 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/capture"
+	"github.com/apache/apisix-go-plugin-runner/pkg/apisixtest"
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+	"github.com/apache/apisix-go-plugin-runner/pkg/runner"
+)
+
+// redactFlags configures the redaction of the captured frames. The replay should use the
+// same flags as the recording, so that the replayed frames are redacted in the same way.
+type redactFlags struct {
+	headers []string
+	body    bool
+}
+
+func (f *redactFlags) register(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringSliceVar(&f.headers, "capture-redact-headers",
+		[]string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+		"the headers whose values are masked in the captured frames")
+	cmd.PersistentFlags().BoolVar(&f.body, "capture-redact-body", false,
+		"mask the request and response bodies in the captured frames")
+}
+
+func (f *redactFlags) hooks() (header func(name string, value []byte), body func(body []byte)) {
+	if len(f.headers) > 0 {
+		header = runner.MaskHeaders(f.headers...)
+	}
+	if f.body {
+		body = runner.MaskBody
+	}
+	return header, body
+}
+
+func replay(files []string, rd capture.Redactor, timeout time.Duration) ([]capture.Mismatch, int, error) {
+	var frames []*capture.Frame
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		fs, err := capture.ReadFrames(f)
+		f.Close()
+		if err != nil {
+			return nil, 0, fmt.Errorf("read %s: %w", name, err)
+		}
+		frames = append(frames, fs...)
+	}
+
+	s, err := apisixtest.NewServer(apisixtest.ServerOptions{})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer s.Close()
+
+	mismatches, err := capture.Replay(frames, func() (net.Conn, error) {
+		return net.Dial("unix", s.Path)
+	}, rd, timeout)
+	return mismatches, len(frames), err
+}
+
+func newReplayCommand() *cobra.Command {
+	var redact redactFlags
+	var timeout time.Duration
+	var verbose bool
+	cmd := &cobra.Command{
+		Use:   "replay capture-file...",
+		Short: "replay the frames captured via `run --capture-file` and diff the responses with the recorded ones",
+		Long: "Replay the frames captured via `run --capture-file` against the registered plugins, and diff the\n" +
+			"responses with the recorded ones. The rotated files should be given from the oldest one.\n" +
+			fmt.Sprintf("The command exits with %d if any response differs, or %d on any other error,\n"+
+				"like the capture can't be replayed.", ExitDiff, ExitError),
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			level := zapcore.WarnLevel
+			if verbose {
+				level = zapcore.InfoLevel
+			}
+			log.NewLogger(level, os.Stderr)
+
+			header, body := redact.hooks()
+			mismatches, n, err := replay(args, capture.Redactor{Header: header, Body: body}, timeout)
+			if err != nil {
+				return &exitError{code: ExitError, err: err}
+			}
+			for _, m := range mismatches {
+				fmt.Fprint(InfoOut, m.String())
+			}
+			fmt.Fprintf(InfoOut, "replayed %d frames, %d mismatch(es)\n", n, len(mismatches))
+			if len(mismatches) > 0 {
+				return &exitError{code: ExitDiff, err: fmt.Errorf("%d response(s) differ", len(mismatches))}
+			}
+			return nil
+		},
+	}
+
+	redact.register(cmd)
+	cmd.PersistentFlags().DurationVar(&timeout, "timeout", 5*time.Second,
+		"the max time to wait for each response of the runner")
+	cmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "show the runner's info logs in stderr")
+	exitOnUsageError(cmd)
+	return cmd
+}
@@ -0,0 +1,122 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/capture"
+	inPlugin "github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	"github.com/apache/apisix-go-plugin-runner/internal/server"
+	"github.com/apache/apisix-go-plugin-runner/pkg/apisixtest"
+	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
+	"github.com/apache/apisix-go-plugin-runner/pkg/httptest"
+	"github.com/apache/apisix-go-plugin-runner/pkg/plugin"
+)
+
+// replayVersion is set in the header by the replay-test plugin, so that the replay can differ
+var replayVersion = "1"
+
+type replayTest struct {
+	plugin.DefaultPlugin
+}
+
+func (p *replayTest) Name() string {
+	return "replay-test"
+}
+
+func (p *replayTest) ParseConf(in []byte) (interface{}, error) {
+	return nil, nil
+}
+
+func (p *replayTest) RequestFilter(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+	v, _ := r.Var("remote_addr")
+	r.Header().Set("X-Version", replayVersion)
+	r.Header().Set("X-Addr", string(v))
+}
+
+func init() {
+	if err := plugin.RegisterPlugin(&replayTest{}); err != nil {
+		panic(err)
+	}
+}
+
+// record sends the RPCs to the runner serving a conn wrapped by the recorder
+func record(t *testing.T, name string) {
+	inPlugin.InitConfCache(time.Hour)
+	rec, err := capture.NewRecorder(capture.Options{Path: name})
+	assert.Nil(t, err)
+
+	apisixSide, runnerSide := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(context.Background(), rec.Wrap(runnerSide), 1)
+		close(done)
+	}()
+
+	c := apisixtest.NewClient(apisixSide)
+	token, err := c.PrepareConf("", httptest.Conf{Name: "replay-test", Value: `{}`})
+	assert.Nil(t, err)
+	res, err := c.HTTPReqCall(&apisixtest.Request{
+		ConfToken: token,
+		Vars:      map[string]string{"remote_addr": "10.0.0.1"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", res.Rewrite.Header.Get("X-Version"))
+	c.Close()
+	<-done
+	assert.Nil(t, rec.Close())
+}
+
+func runReplay(name string) (string, error) {
+	var b bytes.Buffer
+	InfoOut = &b
+	cmd := NewCommand()
+	cmd.SetArgs([]string{"replay", name})
+	err := cmd.Execute()
+	return b.String(), err
+}
+
+func TestReplay(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "runner.cap")
+	record(t, name)
+
+	out, err := runReplay(name)
+	assert.Nil(t, err)
+	assert.Equal(t, "replayed 6 frames, 0 mismatch(es)\n", out)
+
+	replayVersion = "2"
+	defer func() {
+		replayVersion = "1"
+	}()
+	out, err = runReplay(name)
+	assert.Equal(t, ExitDiff, exitCode(err))
+	assert.Contains(t, out, "--- recorded\nhttp req call: rewrite\n+header X-Addr: 10.0.0.1\n+header X-Version: 1\n"+
+		"+++ replayed\nhttp req call: rewrite\n+header X-Addr: 10.0.0.1\n+header X-Version: 2\n")
+
+	_, err = runReplay(filepath.Join(t.TempDir(), "not-found.cap"))
+	assert.Equal(t, ExitError, exitCode(err))
+}
@@ -0,0 +1,341 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	A6 "github.com/api7/ext-plugin-proto/go/A6"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+
+	"github.com/apache/apisix-go-plugin-runner/pkg/apisixtest"
+	"github.com/apache/apisix-go-plugin-runner/pkg/httptest"
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+)
+
+const (
+	// ExitDiff is the exit code of simulate when the output differs from the golden file
+	ExitDiff = 1
+	// ExitError is the exit code of simulate on any other error, like the fixture is invalid,
+	// the runner reports an error or the usage is wrong
+	ExitError = 2
+)
+
+// exitError makes the command exit with the code instead of 1
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// exitOnUsageError makes the invalid args and flags of cmd exit with ExitError, so that they
+// are not mistaken for ExitDiff
+func exitOnUsageError(cmd *cobra.Command) {
+	validate := cmd.Args
+	cmd.Args = func(c *cobra.Command, args []string) error {
+		if err := validate(c, args); err != nil {
+			return &exitError{code: ExitError, err: err}
+		}
+		return nil
+	}
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &exitError{code: ExitError, err: err}
+	})
+}
+
+// values is a header or arg, which can be written as a string or a list of strings
+type values []string
+
+func (v *values) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*v = values{node.Value}
+		return nil
+	}
+	var vs []string
+	if err := node.Decode(&vs); err != nil {
+		return err
+	}
+	*v = vs
+	return nil
+}
+
+type fixturePlugin struct {
+	Name string `yaml:"name"`
+	// Conf is the raw conf if it is a string, otherwise it is encoded in JSON
+	Conf interface{} `yaml:"conf"`
+}
+
+type fixtureRequest struct {
+	Method string `yaml:"method"`
+	// Path can contain the query string, which is merged into the args
+	Path    string            `yaml:"path"`
+	SrcIP   string            `yaml:"src_ip"`
+	Headers map[string]values `yaml:"headers"`
+	Args    map[string]values `yaml:"args"`
+	Body    string            `yaml:"body"`
+	Vars    map[string]string `yaml:"vars"`
+}
+
+type fixtureResponse struct {
+	Status  int               `yaml:"status"`
+	Headers map[string]values `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	Vars    map[string]string `yaml:"vars"`
+}
+
+// fixture describes the route's plugins, the request received by APISIX, and optionally the
+// response from the upstream. As JSON is a subset of YAML, it can be written in both.
+type fixture struct {
+	Plugins  []fixturePlugin  `yaml:"plugins"`
+	Request  fixtureRequest   `yaml:"request"`
+	Response *fixtureResponse `yaml:"response"`
+}
+
+func loadFixture(name string) (*fixture, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fx := &fixture{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(fx); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", name, err)
+	}
+	if len(fx.Plugins) == 0 {
+		return nil, fmt.Errorf("invalid fixture %s: no plugins", name)
+	}
+	return fx, nil
+}
+
+func (p *fixturePlugin) conf() (httptest.Conf, error) {
+	switch v := p.Conf.(type) {
+	case nil:
+		return httptest.Conf{Name: p.Name, Value: "{}"}, nil
+	case string:
+		return httptest.Conf{Name: p.Name, Value: v}, nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return httptest.Conf{}, fmt.Errorf("invalid conf of plugin %s: %w", p.Name, err)
+		}
+		return httptest.Conf{Name: p.Name, Value: string(b)}, nil
+	}
+}
+
+func (r *fixtureRequest) request(token uint32) (*apisixtest.Request, error) {
+	method := strings.ToUpper(r.Method)
+	if method == "" {
+		method = http.MethodGet
+	} else if _, ok := A6.EnumValuesMethod[method]; !ok {
+		// the protocol can only carry the known methods
+		return nil, fmt.Errorf("unsupported method %s", r.Method)
+	}
+
+	req := &apisixtest.Request{
+		ID:        1,
+		ConfToken: token,
+		Method:    method,
+		Path:      r.Path,
+		Header:    http.Header{},
+		Args:      url.Values{},
+		Vars:      r.Vars,
+		Body:      []byte(r.Body),
+	}
+	for k, vs := range r.Headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	for k, vs := range r.Args {
+		req.Args[k] = append(req.Args[k], vs...)
+	}
+	if i := strings.IndexByte(req.Path, '?'); i >= 0 {
+		q, err := url.ParseQuery(req.Path[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid query string of path %s: %w", req.Path, err)
+		}
+		for k, vs := range q {
+			req.Args[k] = append(req.Args[k], vs...)
+		}
+		req.Path = req.Path[:i]
+	}
+	if r.SrcIP != "" {
+		req.SrcIP = net.ParseIP(r.SrcIP)
+		if req.SrcIP == nil {
+			return nil, fmt.Errorf("invalid src_ip %s", r.SrcIP)
+		}
+	}
+	return req, nil
+}
+
+func (r *fixtureResponse) response(token uint32) *apisixtest.Response {
+	resp := &apisixtest.Response{
+		ID:        1,
+		ConfToken: token,
+		Status:    r.Status,
+		Header:    http.Header{},
+		Vars:      r.Vars,
+		Body:      []byte(r.Body),
+	}
+	for k, vs := range r.Headers {
+		for _, v := range vs {
+			resp.Header.Add(k, v)
+		}
+	}
+	return resp
+}
+
+// simulate sends the fixture to the runner serving the registered plugins in process, and
+// renders the actions. The response phase is skipped if the request is stopped, as it won't
+// reach the upstream.
+func simulate(fx *fixture) (string, error) {
+	s, err := apisixtest.NewServer(apisixtest.ServerOptions{StrictConf: true})
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+
+	c, err := s.Dial()
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	confs := make([]httptest.Conf, 0, len(fx.Plugins))
+	for i := range fx.Plugins {
+		conf, err := fx.Plugins[i].conf()
+		if err != nil {
+			return "", err
+		}
+		confs = append(confs, conf)
+	}
+	token, err := c.PrepareConf("", confs...)
+	if err != nil {
+		return "", fmt.Errorf("prepare conf: %w", err)
+	}
+
+	req, err := fx.Request.request(token)
+	if err != nil {
+		return "", err
+	}
+	res, err := c.HTTPReqCall(req)
+	if err != nil {
+		return "", fmt.Errorf("request phase: %w", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# request\n")
+	sb.WriteString(res.String())
+	if fx.Response == nil || res.Stop != nil {
+		return sb.String(), nil
+	}
+
+	respRes, err := c.HTTPRespCall(fx.Response.response(token))
+	if err != nil {
+		return "", fmt.Errorf("response phase: %w", err)
+	}
+	sb.WriteString("# response\n")
+	sb.WriteString(respRes.String())
+	return sb.String(), nil
+}
+
+func newSimulateCommand() *cobra.Command {
+	var golden string
+	var update bool
+	var verbose bool
+	cmd := &cobra.Command{
+		Use:   "simulate fixture",
+		Short: "run the plugins with the request and response in the YAML/JSON fixture, and print the actions",
+		Long: "Run the plugins with the request and response in the YAML/JSON fixture, and print the actions.\n" +
+			"With --golden, the output is compared with the golden file, and the command exits with " +
+			fmt.Sprintf("%d if they differ, or %d if the fixture is invalid or the runner reports an error.", ExitDiff, ExitError),
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// keep stdout for the actions. The logs before it, like registering the plugins,
+			// go to stderr by default
+			level := zapcore.WarnLevel
+			if verbose {
+				level = zapcore.InfoLevel
+			}
+			log.NewLogger(level, os.Stderr)
+
+			fx, err := loadFixture(args[0])
+			if err != nil {
+				return &exitError{code: ExitError, err: err}
+			}
+			out, err := simulate(fx)
+			if err != nil {
+				return &exitError{code: ExitError, err: err}
+			}
+
+			if golden == "" {
+				fmt.Fprint(InfoOut, out)
+				return nil
+			}
+			if update {
+				if err := ioutil.WriteFile(golden, []byte(out), 0644); err != nil {
+					return &exitError{code: ExitError, err: err}
+				}
+				return nil
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				return &exitError{code: ExitError, err: err}
+			}
+			if string(expected) != out {
+				fmt.Fprintf(InfoOut, "--- %s\n%s+++ actual\n%s", golden, expected, out)
+				return &exitError{code: ExitDiff, err: fmt.Errorf("the output differs from %s", golden)}
+			}
+			return nil
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&golden, "golden", "g", "",
+		"compare the output with the golden file instead of printing it")
+	cmd.PersistentFlags().BoolVar(&update, "update", false, "write the output to the golden file")
+	cmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "show the runner's info logs in stderr")
+	exitOnUsageError(cmd)
+	return cmd
+}
+
+// exitCode returns the code to exit with for the error returned by the command
+func exitCode(err error) int {
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	return 1
+}
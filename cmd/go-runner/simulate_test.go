@@ -0,0 +1,160 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runSimulate(t *testing.T, fixture string, args ...string) (string, error) {
+	name := filepath.Join(t.TempDir(), "fixture.yaml")
+	assert.Nil(t, ioutil.WriteFile(name, []byte(fixture), 0644))
+
+	var b bytes.Buffer
+	InfoOut = &b
+	cmd := NewCommand()
+	cmd.SetArgs(append([]string{"simulate", name}, args...))
+	err := cmd.Execute()
+	return b.String(), err
+}
+
+func TestSimulate(t *testing.T) {
+	out, err := runSimulate(t, `
+plugins:
+  - name: say
+    conf: {body: hello}
+request:
+  path: /hello?a=1
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, "# request\nstop 200\n+header X-Resp-A6-Runner: Go\nbody \"hello\"\n", out)
+
+	out, err = runSimulate(t, `{
+  "plugins": [
+    {"name": "response-rewrite", "conf": "{\"headers\":{\"X-A\":\"1\"},\"filters\":[{\"regex\":\"world\",\"scope\":\"once\",\"replace\":\"go\"}]}"}
+  ],
+  "request": {"method": "post", "headers": {"X-B": ["1", "2"]}},
+  "response": {"status": 200, "body": "hello world"}
+}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "# request\npass\n# response\nrewrite-response\n"+
+		"+header X-A: 1\n+header X-Resp-A6-Runner: Go\nbody \"hello go\"\n", out)
+}
+
+func TestSimulateStdout(t *testing.T) {
+	if fixture := os.Getenv("GO_RUNNER_SIMULATE_FIXTURE"); fixture != "" {
+		// run as the go-runner, whose plugins are registered in init
+		os.Args = []string{"go-runner", "simulate", fixture}
+		main()
+		os.Exit(0)
+	}
+
+	name := filepath.Join(t.TempDir(), "fixture.yaml")
+	assert.Nil(t, ioutil.WriteFile(name, []byte(`
+plugins:
+  - name: say
+    conf: {body: hello}
+`), 0644))
+
+	// os.Args may be overwritten by the other tests
+	exe, err := os.Executable()
+	assert.Nil(t, err)
+	cmd := exec.Command(exe, "-test.run=^TestSimulateStdout$")
+	cmd.Env = append(os.Environ(), "GO_RUNNER_SIMULATE_FIXTURE="+name)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	assert.Nil(t, err)
+	// the logs, like registering the plugins, don't go to stdout
+	assert.Equal(t, "# request\nstop 200\n+header X-Resp-A6-Runner: Go\nbody \"hello\"\n", string(out))
+	assert.Contains(t, stderr.String(), "register plugin say")
+}
+
+func TestSimulateGolden(t *testing.T) {
+	fixture := `
+plugins:
+  - name: say
+    conf: {body: hello}
+`
+	golden := filepath.Join(t.TempDir(), "golden.txt")
+	_, err := runSimulate(t, fixture, "--golden", golden, "--update")
+	assert.Nil(t, err)
+	_, err = runSimulate(t, fixture, "--golden", golden)
+	assert.Nil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(golden, []byte("# request\npass\n"), 0644))
+	out, err := runSimulate(t, fixture, "--golden", golden)
+	assert.Equal(t, ExitDiff, exitCode(err))
+	assert.Contains(t, out, "+++ actual\n# request\nstop 200\n")
+}
+
+func TestSimulateError(t *testing.T) {
+	_, err := runSimulate(t, `
+plugins:
+  - name: not-found
+`)
+	assert.Equal(t, ExitError, exitCode(err))
+
+	_, err = runSimulate(t, `
+plugins:
+  - name: say
+request:
+  unknown: 1
+`)
+	assert.Equal(t, ExitError, exitCode(err))
+
+	_, err = runSimulate(t, `request: {path: /}`)
+	assert.Equal(t, ExitError, exitCode(err))
+}
+
+func TestSimulateUnknownMethod(t *testing.T) {
+	_, err := runSimulate(t, `
+plugins:
+  - name: say
+request:
+  method: PSOT
+`)
+	assert.Equal(t, ExitError, exitCode(err))
+	assert.Contains(t, err.Error(), "unsupported method PSOT")
+}
+
+func TestSimulateExitError(t *testing.T) {
+	fixture := `
+plugins:
+  - name: say
+`
+	golden := filepath.Join(t.TempDir(), "not-found", "golden.txt")
+	_, err := runSimulate(t, fixture, "--golden", golden, "--update")
+	assert.Equal(t, ExitError, exitCode(err))
+
+	_, err = runSimulate(t, fixture, "--unknown")
+	assert.Equal(t, ExitError, exitCode(err))
+
+	cmd := NewCommand()
+	cmd.SetArgs([]string{"simulate"})
+	assert.Equal(t, ExitError, exitCode(cmd.Execute()))
+	cmd.SetArgs([]string{"replay"})
+	assert.Equal(t, ExitError, exitCode(cmd.Execute()))
+}
@@ -0,0 +1,191 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package apisix encodes the RPCs like APISIX does, and answers the extra info requests.
+// It is used by the test helpers which act as APISIX.
+package apisix
+
+import (
+	"net"
+	"net/http"
+	"sort"
+
+	A6 "github.com/api7/ext-plugin-proto/go/A6"
+	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
+	hreqc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	hrespc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
+	pc "github.com/api7/ext-plugin-proto/go/A6/PrepareConf"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// ReqCall is the HTTPReqCall sent when APISIX receives a request
+type ReqCall struct {
+	ID        uint32
+	ConfToken uint32
+	SrcIP     net.IP
+	Method    string
+	Path      string
+	Header    map[string][]string
+	Args      map[string][]string
+}
+
+// RespCall is the HTTPRespCall sent when APISIX receives the response from the upstream
+type RespCall struct {
+	ID        uint32
+	ConfToken uint32
+	Status    int
+	Header    map[string][]string
+}
+
+// Conf is the conf of a plugin sent via PrepareConf
+type Conf struct {
+	Name  string
+	Value string
+}
+
+// ExtraInfo contains the answers to the extra info requests. The var not found is answered
+// as empty, like APISIX does.
+type ExtraInfo struct {
+	Vars     map[string][]byte
+	ReqBody  []byte
+	RespBody []byte
+}
+
+func buildTextEntries(builder *flatbuffers.Builder, kvs map[string][]string) flatbuffers.UOffsetT {
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var tes []flatbuffers.UOffsetT
+	for _, k := range keys {
+		for _, v := range kvs[k] {
+			name := builder.CreateString(k)
+			value := builder.CreateString(v)
+			A6.TextEntryStart(builder)
+			A6.TextEntryAddName(builder, name)
+			A6.TextEntryAddValue(builder, value)
+			tes = append(tes, A6.TextEntryEnd(builder))
+		}
+	}
+	return buildVector(builder, tes)
+}
+
+func buildVector(builder *flatbuffers.Builder, tes []flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+	builder.StartVector(flatbuffers.SizeUOffsetT, len(tes), flatbuffers.SizeUOffsetT)
+	for i := len(tes) - 1; i >= 0; i-- {
+		builder.PrependUOffsetT(tes[i])
+	}
+	return builder.EndVector(len(tes))
+}
+
+// EncodePrepareConf encodes the PrepareConf. The key is used by the runner to reuse the token
+// of the same confs, and can be empty.
+func EncodePrepareConf(key string, confs []Conf) []byte {
+	builder := flatbuffers.NewBuilder(1024)
+	var tes []flatbuffers.UOffsetT
+	for _, c := range confs {
+		name := builder.CreateString(c.Name)
+		value := builder.CreateString(c.Value)
+		A6.TextEntryStart(builder)
+		A6.TextEntryAddName(builder, name)
+		A6.TextEntryAddValue(builder, value)
+		tes = append(tes, A6.TextEntryEnd(builder))
+	}
+	vec := buildVector(builder, tes)
+	k := builder.CreateString(key)
+
+	pc.ReqStart(builder)
+	pc.ReqAddConf(builder, vec)
+	pc.ReqAddKey(builder, k)
+	builder.Finish(pc.ReqEnd(builder))
+	return builder.FinishedBytes()
+}
+
+// DecodePrepareConf returns the conf token of the response to PrepareConf
+func DecodePrepareConf(buf []byte) uint32 {
+	return pc.GetRootAsResp(buf, 0).ConfToken()
+}
+
+// EncodeReqCall encodes the HTTPReqCall. The unknown method is encoded as GET.
+func EncodeReqCall(c *ReqCall) []byte {
+	builder := flatbuffers.NewBuilder(1024)
+	path := builder.CreateString(c.Path)
+	hdrs := buildTextEntries(builder, c.Header)
+	args := buildTextEntries(builder, c.Args)
+	ip := c.SrcIP.To4()
+	if ip == nil {
+		ip = c.SrcIP.To16()
+	}
+	srcIP := builder.CreateByteVector(ip)
+
+	hreqc.ReqStart(builder)
+	hreqc.ReqAddId(builder, c.ID)
+	hreqc.ReqAddConfToken(builder, c.ConfToken)
+	hreqc.ReqAddSrcIp(builder, srcIP)
+	hreqc.ReqAddMethod(builder, A6.EnumValuesMethod[c.Method])
+	hreqc.ReqAddPath(builder, path)
+	hreqc.ReqAddHeaders(builder, hdrs)
+	hreqc.ReqAddArgs(builder, args)
+	builder.Finish(hreqc.ReqEnd(builder))
+	return builder.FinishedBytes()
+}
+
+// EncodeRespCall encodes the HTTPRespCall. The status defaults to 200.
+func EncodeRespCall(c *RespCall) []byte {
+	status := c.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	builder := flatbuffers.NewBuilder(1024)
+	hdrs := buildTextEntries(builder, c.Header)
+	hrespc.ReqStart(builder)
+	hrespc.ReqAddId(builder, c.ID)
+	hrespc.ReqAddConfToken(builder, c.ConfToken)
+	hrespc.ReqAddStatus(builder, uint16(status))
+	hrespc.ReqAddHeaders(builder, hdrs)
+	builder.Finish(hrespc.ReqEnd(builder))
+	return builder.FinishedBytes()
+}
+
+// Answer returns the response to the extra info request
+func (info *ExtraInfo) Answer(out []byte) []byte {
+	var res []byte
+	req := ei.GetRootAsReq(out, 0)
+	switch req.InfoType() {
+	case ei.InfoVar:
+		tab := &flatbuffers.Table{}
+		if req.Info(tab) {
+			v := &ei.Var{}
+			v.Init(tab.Bytes, tab.Pos)
+			res = info.Vars[string(v.Name())]
+		}
+	case ei.InfoReqBody:
+		res = info.ReqBody
+	case ei.InfoRespBody:
+		res = info.RespBody
+	}
+
+	builder := flatbuffers.NewBuilder(len(res) + 64)
+	result := builder.CreateByteVector(res)
+	ei.RespStart(builder)
+	ei.RespAddResult(builder, result)
+	builder.Finish(ei.RespEnd(builder))
+	return builder.FinishedBytes()
+}
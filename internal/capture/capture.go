@@ -0,0 +1,364 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package capture records the frames between APISIX and the runner, so that the traffic
+// can be replayed later to reproduce the issue.
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+)
+
+const (
+	DefaultMaxSize  = 100 * 1024 * 1024
+	DefaultMaxFiles = 5
+
+	// queueSize is the number of the frames waiting to be written
+	queueSize = 1024
+
+	// In is the direction of the frame from APISIX
+	In = "in"
+	// Out is the direction of the frame to APISIX
+	Out = "out"
+)
+
+// Frame is a captured frame. The captured file contains a frame in JSON per line.
+type Frame struct {
+	// Conn is the id of the connection, which is unique in the capture file
+	Conn uint64    `json:"conn"`
+	Dir  string    `json:"dir"`
+	Time time.Time `json:"time"`
+	Type byte      `json:"type"`
+	// Length is the length in the frame header. The Payload is nil if the frame can't
+	// be redacted, like the malformed one, or it exceeds the frame size limit.
+	Length  int    `json:"length"`
+	Payload []byte `json:"payload"`
+}
+
+// Options controls the behavior of the Recorder
+type Options struct {
+	// Path is the path of the capture file. The rotated files are named with the suffix
+	// `.1`, `.2` and so on, the larger the older.
+	Path string
+	// MaxSize is the max size in bytes of a capture file, default to DefaultMaxSize
+	MaxSize int
+	// MaxFiles is the number of the capture files kept, including the one being written,
+	// default to DefaultMaxFiles
+	MaxFiles int
+	// Redactor masks the sensitive data before the frame is written
+	Redactor Redactor
+}
+
+// Recorder writes the frames of the wrapped connections to the capture file. The frames are
+// written by a goroutine, so that the connections don't wait for the disk. When it can't keep
+// up, the frames are dropped.
+type Recorder struct {
+	opts   Options
+	nextID uint64
+
+	queueMu sync.RWMutex
+	queue   chan *Frame
+	closed  bool
+	done    chan struct{}
+	dropped uint64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int
+}
+
+// NewRecorder creates the capture file. The existing file is rotated, so that a capture file
+// only contains the frames of the same process.
+func NewRecorder(opts Options) (*Recorder, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = DefaultMaxFiles
+	}
+	if dir := filepath.Dir(opts.Path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &Recorder{
+		opts:  opts,
+		queue: make(chan *Frame, queueSize),
+		done:  make(chan struct{}),
+	}
+	if fi, err := os.Stat(opts.Path); err == nil && fi.Size() > 0 {
+		r.rotateFiles()
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	go r.writeLoop()
+	return r, nil
+}
+
+func (r *Recorder) writeLoop() {
+	defer close(r.done)
+	for fr := range r.queue {
+		r.write(fr)
+	}
+}
+
+// enqueue hands the frame to the writer goroutine without blocking
+func (r *Recorder) enqueue(fr *Frame) {
+	r.queueMu.RLock()
+	defer r.queueMu.RUnlock()
+	if r.closed {
+		return
+	}
+
+	select {
+	case r.queue <- fr:
+	default:
+		if atomic.AddUint64(&r.dropped, 1) == 1 {
+			log.Warnf("capture file %s can't keep up, dropping frames", r.opts.Path)
+		}
+	}
+}
+
+func (r *Recorder) open() error {
+	f, err := os.OpenFile(r.opts.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.size = 0
+	return nil
+}
+
+// rotateFiles renames the capture files, and removes the oldest one beyond MaxFiles
+func (r *Recorder) rotateFiles() {
+	name := func(i int) string {
+		if i == 0 {
+			return r.opts.Path
+		}
+		return fmt.Sprintf("%s.%d", r.opts.Path, i)
+	}
+
+	os.Remove(name(r.opts.MaxFiles - 1))
+	for i := r.opts.MaxFiles - 2; i >= 0; i-- {
+		if err := os.Rename(name(i), name(i+1)); err != nil && !os.IsNotExist(err) {
+			log.Errorf("failed to rotate capture file %s: %s", name(i), err)
+		}
+	}
+}
+
+func (r *Recorder) write(fr *Frame) {
+	line, err := json.Marshal(fr)
+	if err != nil {
+		log.Errorf("failed to encode captured frame: %s", err)
+		return
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return
+	}
+	if r.size > 0 && r.size+len(line) > r.opts.MaxSize {
+		r.f.Close()
+		r.rotateFiles()
+		if err := r.open(); err != nil {
+			log.Errorf("failed to open capture file: %s", err)
+			r.f = nil
+			return
+		}
+	}
+	n, err := r.f.Write(line)
+	r.size += n
+	if err != nil {
+		log.Errorf("failed to write capture file: %s", err)
+	}
+}
+
+// Close stops the recording after the queued frames are written
+func (r *Recorder) Close() error {
+	r.queueMu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.queueMu.Unlock()
+	<-r.done
+	if n := atomic.LoadUint64(&r.dropped); n > 0 {
+		log.Warnf("%d frames are dropped from capture file %s", n, r.opts.Path)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// Wrap returns the conn which records the frames read from and written to c
+func (r *Recorder) Wrap(c net.Conn) net.Conn {
+	rc := &recordedConn{
+		Conn: c,
+		rec:  r,
+		id:   atomic.AddUint64(&r.nextID, 1),
+	}
+	rc.in.emit = func(ty byte, length int, payload []byte) {
+		rc.record(In, ty, length, payload)
+	}
+	rc.out.emit = func(ty byte, length int, payload []byte) {
+		rc.record(Out, ty, length, payload)
+	}
+	return rc
+}
+
+// recordedConn splits the bytes read and written into frames
+type recordedConn struct {
+	net.Conn
+
+	rec *Recorder
+	id  uint64
+
+	mu  sync.Mutex
+	in  frameParser
+	out frameParser
+	// asked contains the extra info requests waiting for the responses, in order
+	asked []extraInfoAsk
+}
+
+func (c *recordedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		c.in.feed(b[:n])
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *recordedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.mu.Lock()
+		c.out.feed(b[:n])
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// record is called with the lock held, so the order of the extra info requests is kept
+func (c *recordedConn) record(dir string, ty byte, length int, payload []byte) {
+	fr := &Frame{
+		Conn:    c.id,
+		Dir:     dir,
+		Time:    time.Now(),
+		Type:    ty,
+		Length:  length,
+		Payload: payload,
+	}
+
+	rd := &c.rec.opts.Redactor
+	// the skipped payload is nil, but the extra info request still needs to be matched
+	skipped := payload == nil
+	var ok bool
+	switch {
+	case dir == Out && ty == util.RPCExtraInfo:
+		var ask extraInfoAsk
+		if !skipped {
+			ask, ok = parseExtraInfoAsk(payload)
+		}
+		c.asked = append(c.asked, ask)
+	case dir == In && ty == util.RPCExtraInfo:
+		var ask extraInfoAsk
+		if len(c.asked) > 0 {
+			ask = c.asked[0]
+			c.asked = c.asked[1:]
+		}
+		ok = !skipped && rd.redactExtraInfo(ask, payload)
+	case dir == In:
+		ok = !skipped && rd.redactCall(ty, payload)
+	default:
+		ok = !skipped && rd.redactResult(ty, payload)
+	}
+	if !ok {
+		fr.Payload = nil
+	}
+	c.rec.enqueue(fr)
+}
+
+// frameParser assembles the frames from the bytes of a direction. The payload beyond the
+// frame size limit is skipped, and emitted as nil.
+type frameParser struct {
+	header [util.HeaderLen]byte
+	got    int
+	length int
+	// read is the bytes of the payload fed so far
+	read    int
+	skip    bool
+	payload []byte
+	emit    func(ty byte, length int, payload []byte)
+}
+
+func (p *frameParser) feed(b []byte) {
+	for len(b) > 0 {
+		if p.got < util.HeaderLen {
+			n := copy(p.header[p.got:], b)
+			p.got += n
+			b = b[n:]
+			if p.got < util.HeaderLen {
+				return
+			}
+			p.length = int(p.header[1])<<16 | int(p.header[2])<<8 | int(p.header[3])
+			p.read = 0
+			p.skip = util.CheckFrameSize(p.header[0], p.length) != nil
+		}
+
+		n := p.length - p.read
+		if n > len(b) {
+			n = len(b)
+		}
+		if !p.skip {
+			// grow with the bytes received, instead of trusting the length in the header
+			p.payload = append(p.payload, b[:n]...)
+		}
+		p.read += n
+		b = b[n:]
+		if p.read == p.length {
+			payload := p.payload
+			if payload == nil && !p.skip {
+				payload = []byte{}
+			}
+			p.emit(p.header[0], p.length, payload)
+			p.got = 0
+			p.payload = nil
+		}
+	}
+}
@@ -0,0 +1,185 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package capture
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	A6 "github.com/api7/ext-plugin-proto/go/A6"
+	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
+	hreqc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/apisix"
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+)
+
+func readCapture(t *testing.T, name string) []*Frame {
+	f, err := os.Open(name)
+	assert.Nil(t, err)
+	defer f.Close()
+	frames, err := ReadFrames(f)
+	assert.Nil(t, err)
+	return frames
+}
+
+func TestFrameParser(t *testing.T) {
+	var got [][]byte
+	p := &frameParser{emit: func(ty byte, length int, payload []byte) {
+		assert.Equal(t, len(payload), length)
+		got = append(got, append([]byte{ty}, payload...))
+	}}
+
+	stream := []byte{1, 0, 0, 2, 'a', 'b', 2, 0, 0, 0, 3, 0, 0, 1, 'c'}
+	// feed the bytes one by one, like the short reads
+	for i := range stream {
+		p.feed(stream[i : i+1])
+	}
+	assert.Equal(t, [][]byte{{1, 'a', 'b'}, {2}, {3, 'c'}}, got)
+}
+
+func TestFrameParser_Oversized(t *testing.T) {
+	util.SetFrameLimits(2, 0)
+	defer util.SetFrameLimits(0, 0)
+
+	var got [][]byte
+	p := &frameParser{emit: func(ty byte, length int, payload []byte) {
+		got = append(got, payload)
+	}}
+	p.feed([]byte{1, 0, 0, 3, 'a', 'b', 'c', 2, 0, 0, 2, 'd', 'e'})
+	assert.Equal(t, [][]byte{nil, []byte("de")}, got)
+
+	// a bogus length doesn't allocate the payload before the bytes come
+	p.feed([]byte{1, 0xff, 0xff, 0xff, 'f'})
+	assert.Nil(t, p.payload)
+}
+
+func TestRecorder(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "runner.cap")
+	rec, err := NewRecorder(Options{
+		Path: name,
+		Redactor: Redactor{
+			Header: MaskHeaders("authorization"),
+			Body:   MaskBody,
+		},
+	})
+	assert.Nil(t, err)
+
+	apisixSide, runnerSide := net.Pipe()
+	rc := rec.Wrap(runnerSide)
+	call := apisix.EncodeReqCall(&apisix.ReqCall{
+		ID:     1,
+		Method: http.MethodGet,
+		Path:   "/",
+		Header: map[string][]string{"Authorization": {"secret"}, "X-Id": {"1"}},
+	})
+	go func() {
+		util.WriteFrame(apisixSide, util.RPCHTTPReqCall, call)
+		header := make([]byte, util.HeaderLen)
+		_, length, _ := util.ReadFrameHeader(apisixSide, header)
+		ask, _ := util.ReadFrameDataNoPool(apisixSide, length)
+		util.WriteFrame(apisixSide, util.RPCExtraInfo,
+			(&apisix.ExtraInfo{ReqBody: []byte("password")}).Answer(ask))
+	}()
+
+	header := make([]byte, util.HeaderLen)
+	_, length, err := util.ReadFrameHeader(rc, header)
+	assert.Nil(t, err)
+	_, err = util.ReadFrameDataNoPool(rc, length)
+	assert.Nil(t, err)
+
+	builder := flatbuffers.NewBuilder(64)
+	ei.ReqBodyStart(builder)
+	info := ei.ReqBodyEnd(builder)
+	ei.ReqStart(builder)
+	ei.ReqAddInfoType(builder, ei.InfoReqBody)
+	ei.ReqAddInfo(builder, info)
+	builder.Finish(ei.ReqEnd(builder))
+	assert.Nil(t, util.WriteFrame(rc, util.RPCExtraInfo, builder.FinishedBytes()))
+	_, length, err = util.ReadFrameHeader(rc, header)
+	assert.Nil(t, err)
+	_, err = util.ReadFrameDataNoPool(rc, length)
+	assert.Nil(t, err)
+	assert.Nil(t, rec.Close())
+
+	frames := readCapture(t, name)
+	assert.Equal(t, 3, len(frames))
+	for _, fr := range frames {
+		assert.Equal(t, uint64(1), fr.Conn)
+		assert.Equal(t, fr.Length, len(fr.Payload))
+	}
+	assert.Equal(t, In, frames[0].Dir)
+	assert.Equal(t, byte(util.RPCHTTPReqCall), frames[0].Type)
+	req := hreqc.GetRootAsReq(frames[0].Payload, 0)
+	hdrs := map[string]string{}
+	te := &A6.TextEntry{}
+	for i := 0; i < req.HeadersLength(); i++ {
+		req.Headers(te, i)
+		hdrs[string(te.Name())] = string(te.Value())
+	}
+	assert.Equal(t, map[string]string{"Authorization": "******", "X-Id": "1"}, hdrs)
+	assert.Equal(t, "extra info: ReqBody\n", describe(frames[1].Type, frames[1].Payload))
+	assert.Equal(t, Out, frames[1].Dir)
+	assert.Equal(t, []byte("********"), ei.GetRootAsResp(frames[2].Payload, 0).ResultBytes())
+	// the original frame is not modified
+	assert.Contains(t, string(call), "secret")
+}
+
+func TestRotate(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "runner.cap")
+	assert.Nil(t, os.WriteFile(name, []byte("previous\n"), 0600))
+
+	rec, err := NewRecorder(Options{Path: name, MaxSize: 1, MaxFiles: 3})
+	assert.Nil(t, err)
+	for i := 0; i < 4; i++ {
+		rec.write(&Frame{Conn: uint64(i + 1)})
+	}
+	assert.Nil(t, rec.Close())
+
+	// each frame exceeds the max size, so it is in its own file, and the oldest are removed
+	assert.Equal(t, uint64(4), readCapture(t, name)[0].Conn)
+	assert.Equal(t, uint64(3), readCapture(t, name+".1")[0].Conn)
+	assert.Equal(t, uint64(2), readCapture(t, name+".2")[0].Conn)
+	_, err = os.Stat(name + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRedactExtraInfo_Var(t *testing.T) {
+	rd := &Redactor{Header: MaskHeaders("X-Token", "Cookie")}
+	for name, expected := range map[string]string{
+		"http_x_token":   "******",
+		"cookie_session": "******",
+		"http_x_id":      "secret",
+		"arg_token":      "secret",
+	} {
+		builder := flatbuffers.NewBuilder(64)
+		v := builder.CreateByteVector([]byte("secret"))
+		ei.RespStart(builder)
+		ei.RespAddResult(builder, v)
+		builder.Finish(ei.RespEnd(builder))
+		payload := builder.FinishedBytes()
+
+		assert.True(t, rd.redactExtraInfo(extraInfoAsk{ty: ei.InfoVar, name: name}, payload))
+		assert.Equal(t, expected, string(ei.GetRootAsResp(payload, 0).ResultBytes()), name)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package capture
+
+import (
+	"net/http"
+	"strings"
+
+	A6 "github.com/api7/ext-plugin-proto/go/A6"
+	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
+	hreqc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	hrespc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
+	flatbuffers "github.com/google/flatbuffers/go"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+)
+
+// Redactor masks the sensitive data of the captured frames. The hooks modify the value in
+// place, so that the frame keeps its layout and can still be replayed. The nil hook is skipped.
+// The other data, like the path, the query args, the Nginx variables `arg_<name>` and the
+// confs of PrepareConf, are never redacted.
+type Redactor struct {
+	// Header masks the value of the request or response header. The Nginx variables asked by
+	// the plugins are also masked like the header they come from: `http_<name>` like the
+	// header, and `cookie_<name>` like the `Cookie` header.
+	Header func(name string, value []byte)
+	// Body masks the request or response body
+	Body func(body []byte)
+}
+
+// MaskHeaders returns a Header hook which replaces the values of the headers with `*`.
+// The names are case insensitive.
+func MaskHeaders(names ...string) func(name string, value []byte) {
+	masked := make(map[string]bool, len(names))
+	for _, name := range names {
+		masked[http.CanonicalHeaderKey(name)] = true
+	}
+	return func(name string, value []byte) {
+		if masked[http.CanonicalHeaderKey(name)] {
+			MaskBody(value)
+		}
+	}
+}
+
+// MaskBody is a Body hook which replaces the body with `*`
+func MaskBody(body []byte) {
+	for i := range body {
+		body[i] = '*'
+	}
+}
+
+// extraInfoAsk is the extra info request, which decides how its response is redacted
+type extraInfoAsk struct {
+	ty   ei.Info
+	name string
+}
+
+// safely runs f, and reports false if it panics on the malformed frame
+func safely(f func()) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	f()
+	return true
+}
+
+func parseExtraInfoAsk(payload []byte) (ask extraInfoAsk, ok bool) {
+	ok = safely(func() {
+		req := ei.GetRootAsReq(payload, 0)
+		ask.ty = req.InfoType()
+		if ask.ty == ei.InfoVar {
+			tab := &flatbuffers.Table{}
+			if req.Info(tab) {
+				v := &ei.Var{}
+				v.Init(tab.Bytes, tab.Pos)
+				ask.name = string(v.Name())
+			}
+		}
+	})
+	return ask, ok
+}
+
+func (rd *Redactor) redactHeaders(n int, get func(obj *A6.TextEntry, j int) bool) {
+	if rd.Header == nil {
+		return
+	}
+	te := &A6.TextEntry{}
+	for i := 0; i < n; i++ {
+		if get(te, i) {
+			if v := te.Value(); v != nil {
+				rd.Header(string(te.Name()), v)
+			}
+		}
+	}
+}
+
+func (rd *Redactor) redactBody(body []byte) {
+	if rd.Body != nil && body != nil {
+		rd.Body(body)
+	}
+}
+
+// redactCall masks the RPC from APISIX
+func (rd *Redactor) redactCall(ty byte, payload []byte) bool {
+	return safely(func() {
+		switch ty {
+		case util.RPCHTTPReqCall:
+			req := hreqc.GetRootAsReq(payload, 0)
+			rd.redactHeaders(req.HeadersLength(), req.Headers)
+		case util.RPCHTTPRespCall:
+			req := hrespc.GetRootAsReq(payload, 0)
+			rd.redactHeaders(req.HeadersLength(), req.Headers)
+		}
+	})
+}
+
+// redactResult masks the response to the RPC
+func (rd *Redactor) redactResult(ty byte, payload []byte) bool {
+	return safely(func() {
+		switch ty {
+		case util.RPCHTTPReqCall:
+			resp := hreqc.GetRootAsResp(payload, 0)
+			tab := &flatbuffers.Table{}
+			if !resp.Action(tab) {
+				return
+			}
+			switch resp.ActionType() {
+			case hreqc.ActionStop:
+				stop := &hreqc.Stop{}
+				stop.Init(tab.Bytes, tab.Pos)
+				rd.redactHeaders(stop.HeadersLength(), stop.Headers)
+				rd.redactBody(stop.BodyBytes())
+			case hreqc.ActionRewrite:
+				rewrite := &hreqc.Rewrite{}
+				rewrite.Init(tab.Bytes, tab.Pos)
+				rd.redactHeaders(rewrite.HeadersLength(), rewrite.Headers)
+				rd.redactHeaders(rewrite.RespHeadersLength(), rewrite.RespHeaders)
+				rd.redactBody(rewrite.BodyBytes())
+			}
+		case util.RPCHTTPRespCall:
+			resp := hrespc.GetRootAsResp(payload, 0)
+			rd.redactHeaders(resp.HeadersLength(), resp.Headers)
+			rd.redactBody(resp.BodyBytes())
+		}
+	})
+}
+
+// redactExtraInfo masks the extra info response according to its request
+func (rd *Redactor) redactExtraInfo(ask extraInfoAsk, payload []byte) bool {
+	return safely(func() {
+		res := ei.GetRootAsResp(payload, 0).ResultBytes()
+		switch ask.ty {
+		case ei.InfoReqBody, ei.InfoRespBody:
+			rd.redactBody(res)
+		case ei.InfoVar:
+			if rd.Header == nil || res == nil {
+				return
+			}
+			if name, ok := varHeader(ask.name); ok {
+				rd.Header(name, res)
+			}
+		}
+	})
+}
+
+// varHeader returns the header which the Nginx variable comes from
+func varHeader(name string) (string, bool) {
+	switch {
+	case strings.HasPrefix(name, "http_"):
+		return strings.ReplaceAll(name[len("http_"):], "_", "-"), true
+	case strings.HasPrefix(name, "cookie_"):
+		// the cookie is a part of the Cookie header
+		return "Cookie", true
+	}
+	return "", false
+}
@@ -0,0 +1,232 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	A6Err "github.com/api7/ext-plugin-proto/go/A6/Err"
+	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
+	hreqc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	hrespc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
+	pc "github.com/api7/ext-plugin-proto/go/A6/PrepareConf"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	"github.com/apache/apisix-go-plugin-runner/pkg/httptest"
+)
+
+// ReadFrames reads the frames from the capture file
+func ReadFrames(r io.Reader) ([]*Frame, error) {
+	var frames []*Frame
+	dec := json.NewDecoder(r)
+	for {
+		fr := &Frame{}
+		if err := dec.Decode(fr); err != nil {
+			if err == io.EOF {
+				return frames, nil
+			}
+			return nil, fmt.Errorf("invalid frame after %d frames: %w", len(frames), err)
+		}
+		frames = append(frames, fr)
+	}
+}
+
+// Mismatch is the frame sent by the runner which differs from the recorded one
+type Mismatch struct {
+	Conn uint64
+	// Index is the index of the recorded frame in the capture
+	Index    int
+	Expected string
+	Actual   string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("conn %d, frame %d\n--- recorded\n%s+++ replayed\n%s", m.Conn, m.Index, m.Expected, m.Actual)
+}
+
+type replayedFrame struct {
+	ty      byte
+	payload []byte
+	err     error
+}
+
+type replayConn struct {
+	conn   net.Conn
+	frames chan replayedFrame
+}
+
+func (rc *replayConn) read(done <-chan struct{}) {
+	header := make([]byte, util.HeaderLen)
+	for {
+		var fr replayedFrame
+		var length int
+		fr.ty, length, fr.err = util.ReadFrameHeader(rc.conn, header)
+		if fr.err == nil {
+			fr.payload, fr.err = util.ReadFrameDataNoPool(rc.conn, length)
+		}
+
+		select {
+		case rc.frames <- fr:
+		case <-done:
+			return
+		}
+		if fr.err != nil {
+			return
+		}
+	}
+}
+
+// Replay sends the recorded frames from APISIX to the runner in order, through a connection
+// dialed for each recorded connection, and compares the frames sent back by the runner with
+// the recorded ones. The frames are compared by their decoded content, as the encoding of the
+// same action may differ, and the frames sent back are redacted like the recorded ones. As the
+// conf tokens are generated by the runner, the recorded tokens are mapped to the replayed ones.
+//
+// The order is only reproducible if the RPCs were handled one by one when being recorded.
+func Replay(frames []*Frame, dial func() (net.Conn, error), rd Redactor,
+	timeout time.Duration) ([]Mismatch, error) {
+
+	done := make(chan struct{})
+	defer close(done)
+
+	conns := map[uint64]*replayConn{}
+	defer func() {
+		for _, rc := range conns {
+			rc.conn.Close()
+		}
+	}()
+
+	tokens := map[uint32]uint32{}
+	var mismatches []Mismatch
+	for i, fr := range frames {
+		rc, ok := conns[fr.Conn]
+		if !ok {
+			c, err := dial()
+			if err != nil {
+				return nil, err
+			}
+			rc = &replayConn{conn: c, frames: make(chan replayedFrame, 16)}
+			conns[fr.Conn] = rc
+			go rc.read(done)
+		}
+
+		if fr.Dir == In {
+			if fr.Payload == nil && fr.Length > 0 {
+				return nil, fmt.Errorf("frame %d of conn %d can't be replayed as its payload is dropped",
+					i, fr.Conn)
+			}
+			payload := append([]byte(nil), fr.Payload...)
+			if !mapConfToken(fr.Type, payload, tokens) {
+				return nil, fmt.Errorf("frame %d of conn %d is malformed", i, fr.Conn)
+			}
+			if err := util.WriteFrame(rc.conn, fr.Type, payload); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var actual replayedFrame
+		select {
+		case actual = <-rc.frames:
+		case <-time.After(timeout):
+			actual.err = fmt.Errorf("no frame in %v", timeout)
+		}
+
+		expected := describe(fr.Type, fr.Payload)
+		if actual.err != nil {
+			mismatches = append(mismatches, Mismatch{
+				Conn:     fr.Conn,
+				Index:    i,
+				Expected: expected,
+				Actual:   fmt.Sprintf("error: %s\n", actual.err),
+			})
+			continue
+		}
+
+		if fr.Type == util.RPCPrepareConf && actual.ty == util.RPCPrepareConf && fr.Payload != nil {
+			tokens[pc.GetRootAsResp(fr.Payload, 0).ConfToken()] = pc.GetRootAsResp(actual.payload, 0).ConfToken()
+			continue
+		}
+
+		if actual.ty != util.RPCExtraInfo && !rd.redactResult(actual.ty, actual.payload) {
+			actual.payload = nil
+		}
+		if got := describe(actual.ty, actual.payload); got != expected {
+			mismatches = append(mismatches, Mismatch{
+				Conn:     fr.Conn,
+				Index:    i,
+				Expected: expected,
+				Actual:   got,
+			})
+		}
+	}
+	return mismatches, nil
+}
+
+// mapConfToken replaces the recorded conf token in the RPC with the replayed one
+func mapConfToken(ty byte, payload []byte, tokens map[uint32]uint32) bool {
+	return safely(func() {
+		switch ty {
+		case util.RPCHTTPReqCall:
+			req := hreqc.GetRootAsReq(payload, 0)
+			if token, ok := tokens[req.ConfToken()]; ok {
+				req.MutateConfToken(token)
+			}
+		case util.RPCHTTPRespCall:
+			req := hrespc.GetRootAsReq(payload, 0)
+			if token, ok := tokens[req.ConfToken()]; ok {
+				req.MutateConfToken(token)
+			}
+		}
+	})
+}
+
+// describe renders the frame sent by the runner, so that it can be compared and shown as a diff
+func describe(ty byte, payload []byte) (s string) {
+	if payload == nil {
+		return fmt.Sprintf("type %d, payload dropped\n", ty)
+	}
+
+	defer func() {
+		if recover() != nil {
+			s = fmt.Sprintf("type %d, malformed %d bytes\n", ty, len(payload))
+		}
+	}()
+
+	switch ty {
+	case util.RPCError:
+		return fmt.Sprintf("error %s\n", A6Err.GetRootAsResp(payload, 0).Code())
+	case util.RPCPrepareConf:
+		return "prepare conf\n"
+	case util.RPCHTTPReqCall:
+		return "http req call: " + httptest.DecodeResult(payload).String()
+	case util.RPCHTTPRespCall:
+		return "http resp call: " + httptest.DecodeRespResult(payload).String()
+	case util.RPCExtraInfo:
+		ask, _ := parseExtraInfoAsk(payload)
+		if ask.ty == ei.InfoVar {
+			return fmt.Sprintf("extra info: var %s\n", ask.name)
+		}
+		return fmt.Sprintf("extra info: %s\n", ask.ty)
+	}
+	return fmt.Sprintf("type %d, %d bytes\n", ty, len(payload))
+}
@@ -0,0 +1,91 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
+
+	"github.com/apache/apisix-go-plugin-runner/pkg/common"
+)
+
+// The protocol between APISIX and the runner doesn't support fetching or sending the body
+// in chunks: the whole body is carried by one frame, which is limited by util.MaxDataSize.
+// What we can do is to bound the memory used by a request, by refusing the body beyond the
+// budget before reading it into the memory.
+var bodyBudget int64
+
+// SetBodyBudget sets the max size of the body which a request can read from or write to APISIX.
+// Zero means no limit except the one of the protocol.
+func SetBodyBudget(n int) {
+	atomic.StoreInt64(&bodyBudget, int64(n))
+}
+
+func checkBodyBudget(size int) error {
+	budget := atomic.LoadInt64(&bodyBudget)
+	if budget > 0 && int64(size) > budget {
+		return fmt.Errorf("%w: %d bytes exceed the budget %d", common.ErrBodyTooLarge, size, budget)
+	}
+	return nil
+}
+
+// checkExtraInfoSize refuses the extra info response carrying the body beyond the budget,
+// so that it can be dropped without being read into the memory
+func checkExtraInfoSize(infoType ei.Info, length uint32) error {
+	if infoType != ei.InfoReqBody && infoType != ei.InfoRespBody {
+		return nil
+	}
+	return checkBodyBudget(int(length))
+}
+
+func (r *Request) BodyReader() (io.Reader, error) {
+	body, err := r.Body()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(body), nil
+}
+
+type requestBodyWriter struct {
+	r *Request
+}
+
+func (w requestBodyWriter) Write(p []byte) (int, error) {
+	if err := checkBodyBudget(len(w.r.body) + len(p)); err != nil {
+		return 0, err
+	}
+	w.r.body = append(w.r.body, p...)
+	return len(p), nil
+}
+
+func (r *Request) BodyWriter() io.Writer {
+	r.body = []byte{}
+	return requestBodyWriter{r}
+}
+
+func (r *Response) BodyReader() (io.Reader, error) {
+	body, err := r.ReadBody()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(body), nil
+}
@@ -0,0 +1,98 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	"github.com/apache/apisix-go-plugin-runner/pkg/common"
+)
+
+func TestBodyReader(t *testing.T) {
+	r, done := createRequestWithBody(t, "", []byte("Hello, Go Runner"))
+	defer done()
+
+	br, err := r.BodyReader()
+	assert.Nil(t, err)
+	b, err := io.ReadAll(br)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello, Go Runner", string(b))
+}
+
+func TestBodyReader_OverBudget(t *testing.T) {
+	SetBodyBudget(16)
+	defer SetBodyBudget(0)
+
+	r := CreateRequest(buildReq(reqOpt{}))
+	cc, sc := net.Pipe()
+	r.BindConn(cc)
+	defer sc.Close()
+	go serveExtraInfo(sc, func(req *ei.Req) []byte {
+		if req.InfoType() == ei.InfoReqBody {
+			return make([]byte, 1024)
+		}
+		return []byte("1.0")
+	})
+
+	_, err := r.BodyReader()
+	assert.True(t, errors.Is(err, common.ErrBodyTooLarge))
+
+	// the conn is still usable after the body is dropped
+	v, err := r.Var("request_time")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.0", string(v))
+}
+
+func TestBodyWriter(t *testing.T) {
+	SetBodyBudget(8)
+	defer SetBodyBudget(0)
+
+	r := CreateRequest(buildReq(reqOpt{}))
+	w := r.BodyWriter()
+	_, err := io.WriteString(w, "Hello, ")
+	assert.Nil(t, err)
+	_, err = io.WriteString(w, "Go Runner")
+	assert.True(t, errors.Is(err, common.ErrBodyTooLarge))
+	_, err = io.WriteString(w, "A")
+	assert.Nil(t, err)
+
+	builder := util.GetBuilder()
+	assert.True(t, r.FetchChanges(1, builder))
+	rewrite := getRewriteAction(t, builder)
+	assert.Equal(t, "Hello, A", string(rewrite.BodyBytes()))
+}
+
+func TestResponse_WriteOverBudget(t *testing.T) {
+	SetBodyBudget(4)
+	defer SetBodyBudget(0)
+
+	r := CreateResponse(buildRespReq(respReqOpt{}))
+	n, err := r.Write([]byte("abc"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	_, err = r.Write([]byte("de"))
+	assert.True(t, errors.Is(err, common.ErrBodyTooLarge))
+	ReuseResponse(r)
+}
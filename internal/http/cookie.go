@@ -0,0 +1,105 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"net/http"
+	"strings"
+)
+
+// requestCookies uses net/http to parse the `Cookie` headers
+func requestCookies(values []string) *http.Request {
+	return &http.Request{Header: http.Header{"Cookie": values}}
+}
+
+func (r *Request) Cookies() []*http.Cookie {
+	return requestCookies(r.Header().Values("Cookie")).Cookies()
+}
+
+func (r *Request) Cookie(name string) (*http.Cookie, error) {
+	return requestCookies(r.Header().Values("Cookie")).Cookie(name)
+}
+
+// AddCookie appends the cookie to the `Cookie` header. The `Cookie` headers are merged into one,
+// as only the name and value are sent. The cookie with invalid name is silently dropped.
+func (r *Request) AddCookie(c *http.Cookie) {
+	pair := (&http.Cookie{Name: c.Name, Value: c.Value}).String()
+	if pair == "" {
+		return
+	}
+	values := r.Header().Values("Cookie")
+	r.setCookieHeader([]string{strings.Join(append(values, pair), "; ")})
+}
+
+// DelCookie removes the pairs of the cookie from the `Cookie` headers. The other pairs are kept as
+// they are, even if they are not valid for net/http.
+func (r *Request) DelCookie(name string) {
+	values := r.Header().Values("Cookie")
+	kept := make([]string, 0, len(values))
+	found := false
+	for _, v := range values {
+		parts := strings.Split(v, ";")
+		pairs := parts[:0]
+		for _, part := range parts {
+			n := part
+			if i := strings.IndexByte(n, '='); i >= 0 {
+				n = n[:i]
+			}
+			if strings.TrimSpace(n) == name {
+				found = true
+				continue
+			}
+			pairs = append(pairs, part)
+		}
+		if len(pairs) == 0 {
+			continue
+		}
+		pairs[0] = strings.TrimLeft(pairs[0], " \t")
+		kept = append(kept, strings.Join(pairs, ";"))
+	}
+	if !found {
+		return
+	}
+	r.setCookieHeader(kept)
+}
+
+// setCookieHeader replaces the `Cookie` headers with the values
+func (r *Request) setCookieHeader(values []string) {
+	hdr := r.Header()
+	if len(values) == 0 {
+		hdr.Del("Cookie")
+		return
+	}
+	hdr.Set("Cookie", values[0])
+	for _, v := range values[1:] {
+		hdr.Add("Cookie", v)
+	}
+}
+
+// SetCookie adds a `Set-Cookie` header. The invalid cookie is silently dropped,
+// like http.SetCookie does.
+func (r *Response) SetCookie(c *http.Cookie) {
+	if v := c.String(); v != "" {
+		r.Header().Add("Set-Cookie", v)
+	}
+}
+
+// SetCookie adds a `Set-Cookie` header. It is equal to http.SetCookie(r, c).
+func (r *ReqResponse) SetCookie(c *http.Cookie) {
+	http.SetCookie(r, c)
+}
@@ -0,0 +1,169 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/api7/ext-plugin-proto/go/A6"
+	hrespc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+)
+
+func TestRequestCookies(t *testing.T) {
+	out := buildReq(reqOpt{headers: []pair{
+		{"cookie", "a=1; b=2"},
+		{"cookie", "c=3"},
+	}})
+	r := CreateRequest(out)
+
+	cookies := r.Cookies()
+	assert.Equal(t, 3, len(cookies))
+	c, err := r.Cookie("b")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", c.Value)
+	_, err = r.Cookie("none")
+	assert.Equal(t, http.ErrNoCookie, err)
+
+	// nothing changed
+	r.DelCookie("none")
+	builder := util.GetBuilder()
+	assert.False(t, r.FetchChanges(1, builder))
+
+	r.DelCookie("b")
+	r.AddCookie(&http.Cookie{Name: "d", Value: "4", Path: "/ignored"})
+	_, err = r.Cookie("b")
+	assert.Equal(t, http.ErrNoCookie, err)
+
+	assert.True(t, r.FetchChanges(1, builder))
+	rewrite := getRewriteAction(t, builder)
+	res := []string{}
+	for i := 0; i < rewrite.HeadersLength(); i++ {
+		e := &A6.TextEntry{}
+		rewrite.Headers(e, i)
+		assert.Equal(t, "Cookie", string(e.Name()))
+		res = append(res, string(e.Value()))
+	}
+	assert.Equal(t, []string{"a=1; c=3; d=4"}, res)
+}
+
+func getCookieHeaders(t *testing.T, r *Request) []string {
+	builder := util.GetBuilder()
+	assert.True(t, r.FetchChanges(1, builder))
+	rewrite := getRewriteAction(t, builder)
+	res := []string{}
+	for i := 0; i < rewrite.HeadersLength(); i++ {
+		e := &A6.TextEntry{}
+		rewrite.Headers(e, i)
+		assert.Equal(t, "Cookie", string(e.Name()))
+		res = append(res, string(e.Value()))
+	}
+	return res
+}
+
+func TestRequestAddCookieMultipleLines(t *testing.T) {
+	out := buildReq(reqOpt{headers: []pair{
+		{"cookie", "a=1; b=2"},
+		{"cookie", "c=3"},
+	}})
+	r := CreateRequest(out)
+	r.AddCookie(&http.Cookie{Name: "d", Value: "4"})
+	r.AddCookie(&http.Cookie{Name: "bad name", Value: "5"})
+	assert.Equal(t, 4, len(r.Cookies()))
+	assert.Equal(t, []string{"a=1; b=2; c=3; d=4"}, getCookieHeaders(t, r))
+}
+
+func TestRequestDelCookieKeepsOthers(t *testing.T) {
+	out := buildReq(reqOpt{headers: []pair{
+		{"cookie", `a=1; json={"x":1}; b=x"y`},
+		{"cookie", "c=3;d=4"},
+		{"cookie", "a=2"},
+	}})
+	r := CreateRequest(out)
+	r.DelCookie("a")
+	_, err := r.Cookie("a")
+	assert.Equal(t, http.ErrNoCookie, err)
+	assert.Equal(t, []string{`json={"x":1}; b=x"y`, "c=3;d=4"}, getCookieHeaders(t, r))
+
+	r.DelCookie("d")
+	assert.Equal(t, []string{`json={"x":1}; b=x"y`, "c=3"}, getCookieHeaders(t, r))
+}
+
+func TestRequestDelAllCookies(t *testing.T) {
+	out := buildReq(reqOpt{headers: []pair{
+		{"cookie", "a=1"},
+	}})
+	r := CreateRequest(out)
+	r.DelCookie("a")
+	assert.Equal(t, 0, len(r.Cookies()))
+
+	builder := util.GetBuilder()
+	assert.True(t, r.FetchChanges(1, builder))
+	rewrite := getRewriteAction(t, builder)
+	assert.Equal(t, 1, rewrite.HeadersLength())
+	e := &A6.TextEntry{}
+	rewrite.Headers(e, 0)
+	assert.Equal(t, "Cookie", string(e.Name()))
+	assert.Nil(t, e.Value())
+}
+
+func TestResponseSetCookie(t *testing.T) {
+	out := buildRespReq(respReqOpt{headers: []pair{
+		{"set-cookie", "a=1"},
+	}})
+	r := CreateResponse(out)
+	r.SetCookie(&http.Cookie{Name: "b", Value: "2", HttpOnly: true})
+	r.SetCookie(&http.Cookie{Name: "c", Value: "3", MaxAge: 10})
+	// invalid
+	r.SetCookie(&http.Cookie{Name: "bad name"})
+
+	builder := util.GetBuilder()
+	assert.True(t, r.FetchChanges(builder))
+	resp := hrespc.GetRootAsResp(builder.FinishedBytes(), 0)
+	res := []string{}
+	for i := 0; i < resp.HeadersLength(); i++ {
+		e := &A6.TextEntry{}
+		resp.Headers(e, i)
+		res = append(res, string(e.Value()))
+	}
+	assert.Equal(t, []string{"a=1", "b=2; HttpOnly", "c=3; Max-Age=10"}, res)
+	ReuseResponse(r)
+}
+
+func TestReqResponseSetCookie(t *testing.T) {
+	r := CreateReqResponse()
+	r.SetCookie(&http.Cookie{Name: "a", Value: "1"})
+	r.SetCookie(&http.Cookie{Name: "b", Value: "2"})
+	r.WriteHeader(401)
+
+	builder := util.GetBuilder()
+	assert.True(t, r.FetchChanges(1, builder))
+	stop := getStopAction(t, builder)
+	res := []string{}
+	for i := 0; i < stop.HeadersLength(); i++ {
+		e := &A6.TextEntry{}
+		stop.Headers(e, i)
+		assert.Equal(t, "Set-Cookie", string(e.Name()))
+		res = append(res, string(e.Value()))
+	}
+	assert.Equal(t, []string{"a=1", "b=2"}, res)
+	ReuseReqResponse(r)
+}
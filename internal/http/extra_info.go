@@ -0,0 +1,76 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"net"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	"github.com/apache/apisix-go-plugin-runner/pkg/common"
+)
+
+// ExtraInfoConn is implemented by the conn which is shared by the RPCs handled concurrently,
+// so that the request can't read the extra info response from it directly.
+type ExtraInfoConn interface {
+	// RoundTripExtraInfo sends the extra info request and waits for its response.
+	// The check is called with the length of the response before reading it. If it fails,
+	// the response is dropped and the error is returned.
+	RoundTripExtraInfo(out []byte, check func(length uint32) error) ([]byte, error)
+}
+
+// RoundTripExtraInfo sends the extra info request via c, and returns the response.
+// The header is the buffer to read the frame header.
+func RoundTripExtraInfo(c net.Conn, header []byte, out []byte, check func(length uint32) error) ([]byte, error) {
+	if ec, ok := c.(ExtraInfoConn); ok {
+		return ec.RoundTripExtraInfo(out, check)
+	}
+
+	if err := util.WriteFrame(c, util.RPCExtraInfo, out); err != nil {
+		return nil, brokenConn(c, err)
+	}
+
+	_, length, err := util.AwaitFrameHeader(c, header)
+	if err != nil {
+		return nil, brokenConn(c, err)
+	}
+
+	if err = check(uint32(length)); err != nil {
+		if e := util.DiscardFrameData(c, length); e != nil {
+			return nil, brokenConn(c, e)
+		}
+		return nil, err
+	}
+
+	// the response is returned to the plugin, so it can't be from the pool
+	buf, err := util.ReadFrameDataNoPool(c, length)
+	if err != nil {
+		return nil, brokenConn(c, err)
+	}
+	return buf, nil
+}
+
+// brokenConn closes the conn which fails to round trip the frame, as the late or partial
+// frame breaks the following ones. The timeout is reported as it is, so that the plugin
+// can tell it from the closed conn.
+func brokenConn(c net.Conn, err error) error {
+	c.Close()
+	if te, ok := err.(*util.TimeoutError); ok {
+		return te
+	}
+	return common.ErrConnClosed
+}
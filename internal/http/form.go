@@ -0,0 +1,184 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/apache/apisix-go-plugin-runner/pkg/common"
+)
+
+const (
+	formContentType = "application/x-www-form-urlencoded"
+	jsonContentType = "application/json"
+
+	// the max memory used to parse the multipart form in Form,
+	// the files beyond it are stored in temporary files
+	maxFormMemory = 32 << 20
+)
+
+func (r *Request) mediaType() (string, map[string]string, error) {
+	ct := r.Header().Get("Content-Type")
+	if ct == "" {
+		return "", nil, fmt.Errorf("%w: missing Content-Type", common.ErrUnsupportedContentType)
+	}
+	return mime.ParseMediaType(ct)
+}
+
+func (r *Request) Form() (url.Values, error) {
+	mt, _, err := r.mediaType()
+	if err != nil {
+		return nil, err
+	}
+
+	switch mt {
+	case formContentType:
+		body, err := r.Body()
+		if err != nil {
+			return nil, err
+		}
+		return url.ParseQuery(string(body))
+	case "multipart/form-data":
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		form, err := mr.ReadForm(maxFormMemory)
+		if err != nil {
+			return nil, err
+		}
+		defer form.RemoveAll()
+		return url.Values(form.Value), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", common.ErrUnsupportedContentType, mt)
+	}
+}
+
+func (r *Request) MultipartReader() (*multipart.Reader, error) {
+	mt, params, err := r.mediaType()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mt, "multipart/") {
+		return nil, fmt.Errorf("%w: %s", common.ErrUnsupportedContentType, mt)
+	}
+	boundary, ok := params["boundary"]
+	if !ok {
+		return nil, http.ErrMissingBoundary
+	}
+
+	body, err := r.Body()
+	if err != nil {
+		return nil, err
+	}
+	return multipart.NewReader(bytes.NewReader(body), boundary), nil
+}
+
+func (r *Request) DecodeJSON(v interface{}) error {
+	mt, _, err := r.mediaType()
+	if err != nil {
+		return err
+	}
+	if mt != jsonContentType && !strings.HasSuffix(mt, "+json") {
+		return fmt.Errorf("%w: %s", common.ErrUnsupportedContentType, mt)
+	}
+
+	body, err := r.Body()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// setBodyWithType rewrites the body, and the Content-Type and Content-Length to match it
+func (r *Request) setBodyWithType(body []byte, contentType string) {
+	r.SetBody(body)
+	hdr := r.Header()
+	hdr.Set("Content-Type", contentType)
+	hdr.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+func (r *Request) SetForm(form url.Values) {
+	r.setBodyWithType([]byte(form.Encode()), formContentType)
+}
+
+func (r *Request) SetMultipartForm(form *multipart.Form) error {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for k, vs := range form.Value {
+		for _, v := range vs {
+			if err := w.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	for k, fhs := range form.File {
+		for _, fh := range fhs {
+			if err := writeFormFile(w, k, fh); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	r.setBodyWithType(buf.Bytes(), w.FormDataContentType())
+	return nil
+}
+
+func writeFormFile(w *multipart.Writer, field string, fh *multipart.FileHeader) error {
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := make(map[string][]string, len(fh.Header))
+	for k, v := range fh.Header {
+		h[k] = v
+	}
+	if len(h["Content-Disposition"]) == 0 {
+		h["Content-Disposition"] = []string{mime.FormatMediaType("form-data",
+			map[string]string{"name": field, "filename": fh.Filename})}
+	}
+	part, err := w.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
+
+func (r *Request) SetJSON(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.setBodyWithType(body, jsonContentType)
+	return nil
+}
@@ -0,0 +1,142 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/url"
+	"testing"
+
+	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	"github.com/apache/apisix-go-plugin-runner/pkg/common"
+)
+
+func createRequestWithBody(t *testing.T, contentType string, body []byte) (*Request, func()) {
+	hdrs := []pair{}
+	if contentType != "" {
+		hdrs = append(hdrs, pair{"content-type", contentType})
+	}
+	r := CreateRequest(buildReq(reqOpt{headers: hdrs}))
+
+	cc, sc := net.Pipe()
+	r.BindConn(cc)
+	go serveExtraInfo(sc, func(req *ei.Req) []byte {
+		assert.Equal(t, ei.InfoReqBody, req.InfoType())
+		return body
+	})
+	return r, func() { sc.Close() }
+}
+
+func TestForm(t *testing.T) {
+	r, done := createRequestWithBody(t, "application/x-www-form-urlencoded; charset=utf-8",
+		[]byte("user=foo&pass=bar&tag=a&tag=b"))
+	defer done()
+
+	form, err := r.Form()
+	assert.Nil(t, err)
+	assert.Equal(t, url.Values{"user": {"foo"}, "pass": {"bar"}, "tag": {"a", "b"}}, form)
+
+	form.Set("pass", "***")
+	r.SetForm(form)
+
+	builder := util.GetBuilder()
+	assert.True(t, r.FetchChanges(1, builder))
+	rewrite := getRewriteAction(t, builder)
+	body := "pass=%2A%2A%2A&tag=a&tag=b&user=foo"
+	assert.Equal(t, body, string(rewrite.BodyBytes()))
+	assert.Equal(t, "application/x-www-form-urlencoded", r.Header().Get("Content-Type"))
+	assert.Equal(t, "35", r.Header().Get("Content-Length"))
+}
+
+func TestForm_UnsupportedContentType(t *testing.T) {
+	for _, ct := range []string{"", "text/plain"} {
+		r, done := createRequestWithBody(t, ct, []byte("a=b"))
+		_, err := r.Form()
+		assert.True(t, errors.Is(err, common.ErrUnsupportedContentType), ct)
+		err = r.DecodeJSON(&struct{}{})
+		assert.True(t, errors.Is(err, common.ErrUnsupportedContentType), ct)
+		_, err = r.MultipartReader()
+		assert.True(t, errors.Is(err, common.ErrUnsupportedContentType), ct)
+		done()
+	}
+}
+
+func TestMultipart(t *testing.T) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	assert.Nil(t, w.WriteField("user", "foo"))
+	fw, err := w.CreateFormFile("file", "a.txt")
+	assert.Nil(t, err)
+	_, err = fw.Write([]byte("content"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	r, done := createRequestWithBody(t, w.FormDataContentType(), buf.Bytes())
+	defer done()
+
+	values, err := r.Form()
+	assert.Nil(t, err)
+	assert.Equal(t, url.Values{"user": {"foo"}}, values)
+
+	mr, err := r.MultipartReader()
+	assert.Nil(t, err)
+	form, err := mr.ReadForm(1024)
+	assert.Nil(t, err)
+	form.Value["user"] = []string{"bar"}
+	assert.Nil(t, r.SetMultipartForm(form))
+
+	// the new body can be read again
+	assert.NotEqual(t, w.FormDataContentType(), r.Header().Get("Content-Type"))
+	mr, err = r.MultipartReader()
+	assert.Nil(t, err)
+	form, err = mr.ReadForm(1024)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bar"}, form.Value["user"])
+	assert.Equal(t, "a.txt", form.File["file"][0].Filename)
+	f, err := form.File["file"][0].Open()
+	assert.Nil(t, err)
+	content, _ := io.ReadAll(f)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestDecodeJSON(t *testing.T) {
+	r, done := createRequestWithBody(t, "application/vnd.api+json", []byte(`{"name":"foo"}`))
+	defer done()
+
+	var v struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	assert.Nil(t, r.DecodeJSON(&v))
+	assert.Equal(t, "foo", v.Name)
+
+	v.Age = 1
+	assert.Nil(t, r.SetJSON(v))
+	body, err := r.Body()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name":"foo","age":1}`, string(body))
+	assert.Equal(t, "application/json", r.Header().Get("Content-Type"))
+	assert.Equal(t, "22", r.Header().Get("Content-Length"))
+}
@@ -19,6 +19,8 @@ package http
 
 import (
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/api7/ext-plugin-proto/go/A6"
 	flatbuffers "github.com/google/flatbuffers/go"
@@ -56,16 +58,27 @@ func newHeader(r ReadHeader) *Header {
 
 func (h *Header) Set(key, value string) {
 	h.hdr.Set(key, value)
-	delete(h.deleteField, key)
 }
 
 func (h *Header) Del(key string) {
-	if h.rawHdr.Get(key) != "" {
+	key = http.CanonicalHeaderKey(key)
+	if _, ok := h.rawHdr[key]; ok {
 		h.deleteField[key] = struct{}{}
-		h.rawHdr.Del(key)
+		delete(h.rawHdr, key)
 	}
 
-	h.hdr.Del(key)
+	delete(h.hdr, key)
+}
+
+func (h *Header) Add(key, value string) {
+	key = http.CanonicalHeaderKey(key)
+	if _, ok := h.hdr[key]; !ok {
+		// keep the original values, the new one is appended to them
+		if raw, ok := h.rawHdr[key]; ok {
+			h.hdr[key] = append([]string(nil), raw...)
+		}
+	}
+	h.hdr.Add(key, value)
 }
 
 func (h *Header) Get(key string) string {
@@ -76,17 +89,129 @@ func (h *Header) Get(key string) string {
 	return h.rawHdr.Get(key)
 }
 
+func (h *Header) Values(key string) []string {
+	key = http.CanonicalHeaderKey(key)
+	vals, ok := h.hdr[key]
+	if !ok {
+		vals = h.rawHdr[key]
+	}
+	if len(vals) == 0 {
+		return nil
+	}
+	return append([]string(nil), vals...)
+}
+
+func (h *Header) Keys() []string {
+	keys := make([]string, 0, len(h.rawHdr)+len(h.hdr))
+	for k := range h.rawHdr {
+		keys = append(keys, k)
+	}
+	for k := range h.hdr {
+		if _, ok := h.rawHdr[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (h *Header) Range(f func(key string, values []string) bool) {
+	for _, k := range h.Keys() {
+		if !f(k, h.Values(k)) {
+			return
+		}
+	}
+}
+
+// Clone returns a copy of the effective headers, which are the original headers
+// merged with the modifications
+func (h *Header) Clone() http.Header {
+	res := make(http.Header, len(h.rawHdr)+len(h.hdr))
+	for k, v := range h.rawHdr {
+		res[k] = append([]string(nil), v...)
+	}
+	for k, v := range h.hdr {
+		res[k] = append([]string(nil), v...)
+	}
+	return res
+}
+
+// copyHeader returns a deep copy of the header with its modifications
+func copyHeader(h *Header) *Header {
+	if h == nil {
+		return nil
+	}
+	c := &Header{
+		hdr:    h.hdr.Clone(),
+		rawHdr: h.rawHdr.Clone(),
+	}
+	if h.deleteField != nil {
+		c.deleteField = make(map[string]struct{}, len(h.deleteField))
+		for k := range h.deleteField {
+			c.deleteField[k] = struct{}{}
+		}
+	}
+	return c
+}
+
 // View
-// Deprecated: refactoring
+// Deprecated: use Clone, Keys/Values or Range instead
 func (h *Header) View() http.Header {
 	return h.hdr
 }
 
+// changed reports whether there is anything to send back to APISIX
+func (h *Header) changed() bool {
+	if len(h.deleteField) > 0 {
+		return true
+	}
+	for k, v := range h.hdr {
+		if raw, ok := h.rawHdr[k]; !ok || !equalValues(raw, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerCarrier reads the original headers as a propagation.TextMapCarrier
+type headerCarrier struct {
+	r ReadHeader
+}
+
+func (c headerCarrier) Get(key string) string {
+	obj := A6.TextEntry{}
+	for i := 0; i < c.r.HeadersLength(); i++ {
+		if c.r.Headers(&obj, i) && strings.EqualFold(string(obj.Name()), key) {
+			return string(obj.Value())
+		}
+	}
+	return ""
+}
+
+// Set does nothing as the carrier is read-only
+func (c headerCarrier) Set(key, value string) {
+}
+
+func (c headerCarrier) Keys() []string {
+	size := c.r.HeadersLength()
+	keys := make([]string, 0, size)
+	obj := A6.TextEntry{}
+	for i := 0; i < size; i++ {
+		if c.r.Headers(&obj, i) {
+			keys = append(keys, string(obj.Name()))
+		}
+	}
+	return keys
+}
+
 func HeaderBuild(h *Header, builder *flatbuffers.Builder) []flatbuffers.UOffsetT {
 	var hdrs []flatbuffers.UOffsetT
 
-	// deleted
+	// deleted, unless the header is set again
 	for d := range h.deleteField {
+		if _, ok := h.hdr[d]; ok {
+			continue
+		}
 		name := builder.CreateString(d)
 		A6.TextEntryStart(builder)
 		A6.TextEntryAddName(builder, name)
@@ -94,11 +219,15 @@ func HeaderBuild(h *Header, builder *flatbuffers.Builder) []flatbuffers.UOffsetT
 		hdrs = append(hdrs, te)
 	}
 
-	// set
+	// set, each value of the changed header is sent as a separate entry
 	for hKey, hVal := range h.hdr {
-		if raw, ok := h.rawHdr[hKey]; !ok || raw[0] != hVal[0] {
-			name := builder.CreateString(hKey)
-			value := builder.CreateString(hVal[0])
+		if raw, ok := h.rawHdr[hKey]; ok && equalValues(raw, hVal) {
+			continue
+		}
+
+		name := builder.CreateString(hKey)
+		for _, v := range hVal {
+			value := builder.CreateString(v)
 			A6.TextEntryStart(builder)
 			A6.TextEntryAddName(builder, name)
 			A6.TextEntryAddValue(builder, value)
@@ -109,3 +238,15 @@ func HeaderBuild(h *Header, builder *flatbuffers.Builder) []flatbuffers.UOffsetT
 
 	return hdrs
 }
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
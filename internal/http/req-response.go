@@ -130,6 +130,20 @@ func (r *ReqResponse) FetchChanges(id uint32, builder *flatbuffers.Builder) bool
 	return true
 }
 
+// Snapshot returns a copy of the response like Request.Snapshot.
+// It should be released with ReuseReqResponse.
+func (r *ReqResponse) Snapshot() *ReqResponse {
+	c := reqRespPool.Get().(*ReqResponse)
+	if r.hdr != nil {
+		c.hdr = r.hdr.Clone()
+	}
+	if r.body != nil {
+		c.body = bytes.NewBuffer(append([]byte{}, r.body.Bytes()...))
+	}
+	c.code = r.code
+	return c
+}
+
 var reqRespPool = sync.Pool{
 	New: func() interface{} {
 		return &ReqResponse{}
@@ -19,7 +19,6 @@ package http
 
 import (
 	"context"
-	"encoding/binary"
 	"net"
 	"net/http"
 	"net/url"
@@ -31,11 +30,13 @@ import (
 	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
 	hrc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
 	flatbuffers "github.com/google/flatbuffers/go"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/apache/apisix-go-plugin-runner/internal/metrics"
+	"github.com/apache/apisix-go-plugin-runner/internal/tracing"
 	"github.com/apache/apisix-go-plugin-runner/internal/util"
-	"github.com/apache/apisix-go-plugin-runner/pkg/common"
 	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
-	"github.com/apache/apisix-go-plugin-runner/pkg/log"
 )
 
 type Request struct {
@@ -59,6 +60,8 @@ type Request struct {
 	cancel context.CancelFunc
 
 	respHdr http.Header
+
+	store *Store
 }
 
 func (r *Request) ConfToken() uint32 {
@@ -69,8 +72,9 @@ func (r *Request) ID() uint32 {
 	return r.r.Id()
 }
 
+// SrcIP returns a copy, as the RPC's data is reused after the RPC
 func (r *Request) SrcIP() net.IP {
-	return r.r.SrcIpBytes()
+	return append(net.IP(nil), r.r.SrcIpBytes()...)
 }
 
 func (r *Request) Method() string {
@@ -79,7 +83,8 @@ func (r *Request) Method() string {
 
 func (r *Request) Path() []byte {
 	if r.path == nil {
-		return r.r.Path()
+		// copy it, as the RPC's data is reused after the RPC
+		return append([]byte{}, r.r.Path()...)
 	}
 	return r.path
 }
@@ -88,6 +93,62 @@ func (r *Request) SetPath(path []byte) {
 	r.path = path
 }
 
+func (r *Request) Host() (string, error) {
+	if r.hdr != nil {
+		if v, ok := r.hdr.hdr["Host"]; ok && len(v) > 0 {
+			return v[0], nil
+		}
+	}
+
+	v, err := r.Var("host")
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+func (r *Request) SetHost(host string) {
+	r.Header().Set("Host", host)
+}
+
+func (r *Request) Scheme() (string, error) {
+	v, err := r.Var("scheme")
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+func (r *Request) RawURI() (string, error) {
+	if r.path == nil && (r.args == nil || reflect.DeepEqual(r.args, r.rawArgs)) {
+		v, err := r.Var("request_uri")
+		if err != nil {
+			return "", err
+		}
+		return string(v), nil
+	}
+
+	u := url.URL{Path: string(r.Path()), RawQuery: r.Args().Encode()}
+	return u.RequestURI(), nil
+}
+
+func (r *Request) SetRawURI(uri string) error {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return err
+	}
+
+	r.SetPath([]byte(u.Path))
+	args := r.Args()
+	for k := range args {
+		delete(args, k)
+	}
+	for k, v := range u.Query() {
+		args[k] = v
+	}
+	return nil
+}
+
 func (r *Request) Header() pkgHTTP.Header {
 	if r.hdr == nil {
 		r.hdr = newHeader(r.r)
@@ -194,6 +255,7 @@ func (r *Request) Reset() {
 	r.conn = nil
 	r.ctx = nil
 	r.respHdr = nil
+	r.store = nil
 	// Keep the fields below
 	// r.extraInfoHeader = nil
 }
@@ -319,6 +381,18 @@ func (r *Request) BindConn(c net.Conn) {
 	r.conn = c
 }
 
+// SetContext replaces the request's context. The ctx should be derived from the
+// original one, so that the request is still canceled when the server is exiting.
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// TraceCarrier returns the carrier to extract the trace context from the original
+// headers. Unlike Header(), it doesn't mark the headers as changed.
+func (r *Request) TraceCarrier() propagation.TextMapCarrier {
+	return headerCarrier{r.r}
+}
+
 func (r *Request) Context() context.Context {
 	if r.ctx != nil {
 		return r.ctx
@@ -326,13 +400,33 @@ func (r *Request) Context() context.Context {
 	return context.Background()
 }
 
+func (r *Request) Ctx() pkgHTTP.Store {
+	if r.store == nil {
+		r.store = NewStore()
+	}
+	return r.store
+}
+
+// Store returns the store of the request, or nil if Ctx is never called
+func (r *Request) Store() *Store {
+	return r.store
+}
+
 func (r *Request) hasChanges() bool {
-	return r.path != nil || r.hdr != nil ||
+	return r.path != nil || (r.hdr != nil && r.hdr.changed()) ||
 		r.args != nil || r.respHdr != nil || r.body != nil
 }
 
 func (r *Request) askExtraInfo(builder *flatbuffers.Builder,
-	infoType ei.Info, info flatbuffers.UOffsetT) ([]byte, error) {
+	infoType ei.Info, info flatbuffers.UOffsetT) (res []byte, err error) {
+
+	start := time.Now()
+	_, span := tracing.Start(r.Context(), "askExtraInfo",
+		trace.WithAttributes(tracing.ExtraInfoTypeKey.String(infoType.String())))
+	defer func() {
+		metrics.ObserveRPC(util.RPCExtraInfo, start, err != nil)
+		tracing.EndWithError(span, err)
+	}()
 
 	ei.ReqStart(builder)
 	ei.ReqAddInfoType(builder, infoType)
@@ -340,51 +434,26 @@ func (r *Request) askExtraInfo(builder *flatbuffers.Builder,
 	eiRes := ei.ReqEnd(builder)
 	builder.Finish(eiRes)
 
-	c := r.conn
 	if len(r.extraInfoHeader) == 0 {
 		r.extraInfoHeader = make([]byte, util.HeaderLen)
 	}
-	header := r.extraInfoHeader
-
-	out := builder.FinishedBytes()
-	size := len(out)
-	binary.BigEndian.PutUint32(header, uint32(size))
-	header[0] = util.RPCExtraInfo
-
-	n, err := util.WriteBytes(c, header, len(header))
-	if err != nil {
-		util.WriteErr(n, err)
-		return nil, common.ErrConnClosed
-	}
-
-	n, err = util.WriteBytes(c, out, size)
+	buf, err := RoundTripExtraInfo(r.conn, r.extraInfoHeader, builder.FinishedBytes(),
+		func(length uint32) error {
+			return checkExtraInfoSize(infoType, length)
+		})
 	if err != nil {
-		util.WriteErr(n, err)
-		return nil, common.ErrConnClosed
-	}
-
-	n, err = util.ReadBytes(c, header, util.HeaderLen)
-	if util.ReadErr(n, err, util.HeaderLen) {
-		return nil, common.ErrConnClosed
-	}
-
-	ty := header[0]
-	header[0] = 0
-	length := binary.BigEndian.Uint32(header)
-
-	log.Infof("receive rpc type: %d data length: %d", ty, length)
-
-	buf := make([]byte, length)
-	n, err = util.ReadBytes(c, buf, int(length))
-	if util.ReadErr(n, err, int(length)) {
-		return nil, common.ErrConnClosed
+		return nil, err
 	}
 
 	resp := ei.GetRootAsResp(buf, 0)
-	res := resp.ResultBytes()
+	res = resp.ResultBytes()
 	return res, nil
 }
 
+// because apisix has an implicit 60s timeout, so set the timeout to 56 seconds(smaller than 60s)
+// so plugin writer can still break the execution with a custom response before the apisix implicit timeout.
+const rpcTimeout = 56 * time.Second
+
 var reqPool = sync.Pool{
 	New: func() interface{} {
 		return &Request{}
@@ -392,16 +461,52 @@ var reqPool = sync.Pool{
 }
 
 func CreateRequest(buf []byte) *Request {
+	return CreateRequestWithContext(context.Background(), buf)
+}
+
+// CreateRequestWithContext is like CreateRequest, but the request's context is derived
+// from the given ctx, so that the request can be canceled when the server is exiting.
+func CreateRequestWithContext(ctx context.Context, buf []byte) *Request {
 	req := reqPool.Get().(*Request)
 	req.r = hrc.GetRootAsReq(buf, 0)
-	// because apisix has an implicit 60s timeout, so set the timeout to 56 seconds(smaller than 60s)
-	// so plugin writer can still break the execution with a custom response before the apisix implicit timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 56*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, rpcTimeout)
 	req.ctx = ctx
 	req.cancel = cancel
 	return req
 }
 
+// Snapshot returns a copy of the request with the changes made so far, which can go on
+// while the request is still used by another goroutine. The copy shares the RPC's data
+// and the conn, and its context is derived from ctx. It should be released with ReuseRequest.
+func (r *Request) Snapshot(ctx context.Context) *Request {
+	c := reqPool.Get().(*Request)
+	c.r = r.r
+	c.conn = r.conn
+	if r.path != nil {
+		c.path = append([]byte{}, r.path...)
+	}
+	c.hdr = copyHeader(r.hdr)
+	if r.args != nil {
+		c.args = cloneUrlValues(r.args)
+		c.rawArgs = cloneUrlValues(r.rawArgs)
+	}
+	if r.vars != nil {
+		c.vars = make(map[string][]byte, len(r.vars))
+		for k, v := range r.vars {
+			c.vars[k] = v
+		}
+	}
+	if r.body != nil {
+		c.body = append([]byte{}, r.body...)
+	}
+	if r.respHdr != nil {
+		c.respHdr = r.respHdr.Clone()
+	}
+	c.store = copyStore(r.store)
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	return c
+}
+
 func ReuseRequest(r *Request) {
 	r.Reset()
 	reqPool.Put(r)
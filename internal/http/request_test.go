@@ -31,7 +31,12 @@ import (
 	hrc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
 	flatbuffers "github.com/google/flatbuffers/go"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/apache/apisix-go-plugin-runner/internal/tracing"
 	"github.com/apache/apisix-go-plugin-runner/internal/util"
 	"github.com/apache/apisix-go-plugin-runner/pkg/common"
 )
@@ -259,6 +264,121 @@ func TestHeader(t *testing.T) {
 	assert.Equal(t, exp, res)
 }
 
+func TestHeader_MultiValue(t *testing.T) {
+	out := buildReq(reqOpt{headers: []pair{
+		{"k", "v"},
+		{"via", "1.1 a"},
+		{"vary", "Accept"},
+		{"vary", "Origin"},
+	}})
+	r := CreateRequest(out)
+	hdr := r.Header()
+	assert.Equal(t, []string{"Accept", "Origin"}, hdr.Values("Vary"))
+	assert.Nil(t, hdr.Values("none"))
+	assert.Equal(t, []string{"K", "Vary", "Via"}, hdr.Keys())
+
+	hdr.Add("via", "1.1 b")
+	hdr.Add("x-new", "1")
+	hdr.Add("X-New", "2")
+	assert.Equal(t, []string{"1.1 a", "1.1 b"}, hdr.Values("Via"))
+	assert.Equal(t, "1.1 a", hdr.Get("via"))
+	assert.Equal(t, []string{"1", "2"}, hdr.Values("x-new"))
+
+	// modifying the returned values doesn't change the header
+	vals := hdr.Values("vary")
+	vals[0] = "changed"
+	assert.Equal(t, []string{"Accept", "Origin"}, hdr.Values("Vary"))
+
+	hdr.Del("k")
+	keys := []string{}
+	hdr.Range(func(key string, values []string) bool {
+		keys = append(keys, key)
+		return key != "Via"
+	})
+	assert.Equal(t, []string{"Vary", "Via"}, keys)
+
+	builder := util.GetBuilder()
+	assert.True(t, r.FetchChanges(1, builder))
+	rewrite := getRewriteAction(t, builder)
+
+	res := http.Header{}
+	for i := 0; i < rewrite.HeadersLength(); i++ {
+		e := &A6.TextEntry{}
+		rewrite.Headers(e, i)
+		res.Add(string(e.Name()), string(e.Value()))
+	}
+	exp := http.Header{
+		"K":     []string{""},
+		"Via":   []string{"1.1 a", "1.1 b"},
+		"X-New": []string{"1", "2"},
+	}
+	assert.Equal(t, exp, res)
+}
+
+func TestHeader_Clone(t *testing.T) {
+	out := buildReq(reqOpt{headers: []pair{
+		{"k", "v"},
+		{"vary", "Accept"},
+		{"vary", "Origin"},
+		{"cat", "dog"},
+	}})
+	r := CreateRequest(out)
+	hdr := r.Header()
+	assert.Equal(t, http.Header{
+		"K":    []string{"v"},
+		"Vary": []string{"Accept", "Origin"},
+		"Cat":  []string{"dog"},
+	}, hdr.Clone())
+
+	// reading the headers is not a change
+	builder := util.GetBuilder()
+	assert.False(t, r.FetchChanges(1, builder))
+
+	hdr.Set("k", "v2")
+	hdr.Del("CAT")
+	hdr.Add("x-new", "1")
+	res := hdr.Clone()
+	assert.Equal(t, http.Header{
+		"K":     []string{"v2"},
+		"Vary":  []string{"Accept", "Origin"},
+		"X-New": []string{"1"},
+	}, res)
+
+	res.Set("k", "changed")
+	assert.Equal(t, "v2", hdr.Get("k"))
+}
+
+func TestHeader_DelAfterSet(t *testing.T) {
+	out := buildReq(reqOpt{headers: []pair{
+		{"k", "v"},
+		{"cat", "dog"},
+	}})
+	r := CreateRequest(out)
+	hdr := r.Header()
+
+	// the deletion is sent even if the header is set in the middle
+	hdr.Del("k")
+	hdr.Set("K", "v2")
+	hdr.Del("k")
+	// the set header replaces the deleted one
+	hdr.Del("cat")
+	hdr.Set("Cat", "")
+	assert.Equal(t, []string{"Cat"}, hdr.Keys())
+
+	builder := util.GetBuilder()
+	assert.True(t, r.FetchChanges(1, builder))
+	rewrite := getRewriteAction(t, builder)
+	assert.Equal(t, 2, rewrite.HeadersLength())
+
+	res := map[string]bool{}
+	for i := 0; i < rewrite.HeadersLength(); i++ {
+		e := &A6.TextEntry{}
+		rewrite.Headers(e, i)
+		res[string(e.Name())] = e.Value() != nil
+	}
+	assert.Equal(t, map[string]bool{"K": false, "Cat": true}, res)
+}
+
 func TestArgs(t *testing.T) {
 	out := buildReq(reqOpt{args: []pair{
 		{"del", "a"},
@@ -356,6 +476,135 @@ func TestVar(t *testing.T) {
 	}
 }
 
+// serveExtraInfo answers the ExtraInfo requests with the result of f until the conn is closed
+func serveExtraInfo(sc net.Conn, f func(req *ei.Req) []byte) {
+	for {
+		header := make([]byte, util.HeaderLen)
+		n, err := util.ReadBytes(sc, header, util.HeaderLen)
+		if util.ReadErr(n, err, util.HeaderLen) {
+			return
+		}
+
+		header[0] = 0
+		length := binary.BigEndian.Uint32(header)
+		buf := make([]byte, length)
+		n, err = util.ReadBytes(sc, buf, int(length))
+		if util.ReadErr(n, err, int(length)) {
+			return
+		}
+
+		builder := util.GetBuilder()
+		res := builder.CreateByteVector(f(ei.GetRootAsReq(buf, 0)))
+		ei.RespStart(builder)
+		ei.RespAddResult(builder, res)
+		builder.Finish(ei.RespEnd(builder))
+		out := builder.FinishedBytes()
+		binary.BigEndian.PutUint32(header, uint32(len(out)))
+		header[0] = util.RPCExtraInfo
+
+		if _, err = util.WriteBytes(sc, header, len(header)); err != nil {
+			return
+		}
+		if _, err = util.WriteBytes(sc, out, len(out)); err != nil {
+			return
+		}
+	}
+}
+
+// serveVars answers the Var requests with the vars
+func serveVars(t *testing.T, sc net.Conn, vars map[string]string) {
+	serveExtraInfo(sc, func(req *ei.Req) []byte {
+		info := getVarInfo(t, req)
+		return []byte(vars[string(info.Name())])
+	})
+}
+
+func TestHostAndScheme(t *testing.T) {
+	out := buildReq(reqOpt{})
+	r := CreateRequest(out)
+
+	cc, sc := net.Pipe()
+	r.BindConn(cc)
+	defer sc.Close()
+	go serveVars(t, sc, map[string]string{
+		"host":   "example.com",
+		"scheme": "https",
+	})
+
+	host, err := r.Host()
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", host)
+	scheme, err := r.Scheme()
+	assert.Nil(t, err)
+	assert.Equal(t, "https", scheme)
+
+	r.SetHost("upstream.com")
+	host, err = r.Host()
+	assert.Nil(t, err)
+	assert.Equal(t, "upstream.com", host)
+
+	builder := util.GetBuilder()
+	assert.True(t, r.FetchChanges(1, builder))
+	rewrite := getRewriteAction(t, builder)
+	assert.Equal(t, 1, rewrite.HeadersLength())
+	e := &A6.TextEntry{}
+	rewrite.Headers(e, 0)
+	assert.Equal(t, "Host", string(e.Name()))
+	assert.Equal(t, "upstream.com", string(e.Value()))
+}
+
+func TestRawURI(t *testing.T) {
+	out := buildReq(reqOpt{path: "/a b", args: []pair{{"k", "v"}}})
+	r := CreateRequest(out)
+
+	cc, sc := net.Pipe()
+	r.BindConn(cc)
+	defer sc.Close()
+	go serveVars(t, sc, map[string]string{
+		"request_uri": "/a%20b?k=v",
+	})
+
+	uri, err := r.RawURI()
+	assert.Nil(t, err)
+	assert.Equal(t, "/a%20b?k=v", uri)
+
+	// reading the args is not a change
+	r.Args()
+	uri, err = r.RawURI()
+	assert.Nil(t, err)
+	assert.Equal(t, "/a%20b?k=v", uri)
+
+	r.Args().Add("x", "1")
+	uri, err = r.RawURI()
+	assert.Nil(t, err)
+	assert.Equal(t, "/a%20b?k=v&x=1", uri)
+
+	assert.NotNil(t, r.SetRawURI("no-slash"))
+	assert.Nil(t, r.SetRawURI("/c?x=2&y=3&y=4"))
+	uri, err = r.RawURI()
+	assert.Nil(t, err)
+	assert.Equal(t, "/c?x=2&y=3&y=4", uri)
+
+	builder := util.GetBuilder()
+	assert.True(t, r.FetchChanges(1, builder))
+	rewrite := getRewriteAction(t, builder)
+	assert.Equal(t, "/c", string(rewrite.Path()))
+
+	deleted := []string{}
+	res := url.Values{}
+	for i := 0; i < rewrite.ArgsLength(); i++ {
+		e := &A6.TextEntry{}
+		rewrite.Args(e, i)
+		if e.Value() == nil {
+			deleted = append(deleted, string(e.Name()))
+		} else {
+			res.Add(string(e.Name()), string(e.Value()))
+		}
+	}
+	assert.Equal(t, []string{"k"}, deleted)
+	assert.Equal(t, url.Values{"x": {"2"}, "y": {"3", "4"}}, res)
+}
+
 func TestVar_FailedToSendExtraInfoReq(t *testing.T) {
 	out := buildReq(reqOpt{})
 	r := CreateRequest(out)
@@ -408,6 +657,48 @@ func TestVar_FailedToReadExtraInfoResp(t *testing.T) {
 	assert.Equal(t, common.ErrConnClosed, err)
 }
 
+func TestVar_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracing.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer tracing.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	out := buildReq(reqOpt{})
+	r := CreateRequest(out)
+	ctx, parent := tracing.Start(r.Context(), "plugin")
+	r.SetContext(ctx)
+
+	cc, sc := net.Pipe()
+	r.BindConn(cc)
+	sc.Close()
+
+	_, err := r.Var("request_time")
+	assert.Equal(t, common.ErrConnClosed, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 2, len(spans))
+	span := spans[0]
+	assert.Equal(t, "askExtraInfo", span.Name)
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+	assert.Equal(t, codes.Error, span.Status.Code)
+	assert.Contains(t, span.Attributes, tracing.ExtraInfoTypeKey.String("Var"))
+}
+
+func TestTraceCarrier(t *testing.T) {
+	out := buildReq(reqOpt{headers: []pair{
+		{"Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{"X-Foo", "bar"},
+	}})
+	r := CreateRequest(out)
+
+	c := r.TraceCarrier()
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", c.Get("traceparent"))
+	assert.Equal(t, "", c.Get("tracestate"))
+	assert.Equal(t, []string{"Traceparent", "X-Foo"}, c.Keys())
+	// reading the trace context doesn't change the request
+	assert.False(t, r.FetchChanges(1, flatbuffers.NewBuilder(1024)))
+}
+
 func TestContext(t *testing.T) {
 	out := buildReq(reqOpt{})
 	now := time.Now()
@@ -19,18 +19,21 @@ package http
 
 import (
 	"bytes"
-	"encoding/binary"
+	"context"
 	"net"
+	"net/http"
 	"sync"
+	"time"
 
+	"github.com/apache/apisix-go-plugin-runner/internal/metrics"
+	"github.com/apache/apisix-go-plugin-runner/internal/tracing"
 	"github.com/apache/apisix-go-plugin-runner/internal/util"
-	"github.com/apache/apisix-go-plugin-runner/pkg/common"
 	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
 
 	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
-	"github.com/apache/apisix-go-plugin-runner/pkg/log"
 	hrc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
 	flatbuffers "github.com/google/flatbuffers/go"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Response struct {
@@ -48,10 +51,23 @@ type Response struct {
 	vars map[string][]byte
 	// originBody is read-only
 	originBody []byte
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	store *Store
 }
 
 func (r *Response) askExtraInfo(builder *flatbuffers.Builder,
-	infoType ei.Info, info flatbuffers.UOffsetT) ([]byte, error) {
+	infoType ei.Info, info flatbuffers.UOffsetT) (res []byte, err error) {
+
+	start := time.Now()
+	_, span := tracing.Start(r.Context(), "askExtraInfo",
+		trace.WithAttributes(tracing.ExtraInfoTypeKey.String(infoType.String())))
+	defer func() {
+		metrics.ObserveRPC(util.RPCExtraInfo, start, err != nil)
+		tracing.EndWithError(span, err)
+	}()
 
 	ei.ReqStart(builder)
 	ei.ReqAddInfoType(builder, infoType)
@@ -59,48 +75,19 @@ func (r *Response) askExtraInfo(builder *flatbuffers.Builder,
 	eiRes := ei.ReqEnd(builder)
 	builder.Finish(eiRes)
 
-	c := r.conn
 	if len(r.extraInfoHeader) == 0 {
 		r.extraInfoHeader = make([]byte, util.HeaderLen)
 	}
-	header := r.extraInfoHeader
-
-	out := builder.FinishedBytes()
-	size := len(out)
-	binary.BigEndian.PutUint32(header, uint32(size))
-	header[0] = util.RPCExtraInfo
-
-	n, err := util.WriteBytes(c, header, len(header))
+	buf, err := RoundTripExtraInfo(r.conn, r.extraInfoHeader, builder.FinishedBytes(),
+		func(length uint32) error {
+			return checkExtraInfoSize(infoType, length)
+		})
 	if err != nil {
-		util.WriteErr(n, err)
-		return nil, common.ErrConnClosed
-	}
-
-	n, err = util.WriteBytes(c, out, size)
-	if err != nil {
-		util.WriteErr(n, err)
-		return nil, common.ErrConnClosed
-	}
-
-	n, err = util.ReadBytes(c, header, util.HeaderLen)
-	if util.ReadErr(n, err, util.HeaderLen) {
-		return nil, common.ErrConnClosed
-	}
-
-	ty := header[0]
-	header[0] = 0
-	length := binary.BigEndian.Uint32(header)
-
-	log.Infof("receive rpc type: %d data length: %d", ty, length)
-
-	buf := make([]byte, length)
-	n, err = util.ReadBytes(c, buf, int(length))
-	if util.ReadErr(n, err, int(length)) {
-		return nil, common.ErrConnClosed
+		return nil, err
 	}
 
 	resp := ei.GetRootAsResp(buf, 0)
-	res := resp.ResultBytes()
+	res = resp.ResultBytes()
 	return res, nil
 }
 
@@ -127,6 +114,9 @@ func (r *Response) Write(b []byte) (int, error) {
 	if r.body == nil {
 		r.body = &bytes.Buffer{}
 	}
+	if err := checkBodyBudget(r.body.Len() + len(b)); err != nil {
+		return 0, err
+	}
 
 	return r.body.Write(b)
 }
@@ -192,7 +182,7 @@ func (r *Response) ConfToken() uint32 {
 }
 
 func (r *Response) HasChange() bool {
-	return !(r.body == nil && r.hdr == nil && r.statusCode == 0)
+	return r.body != nil || (r.hdr != nil && r.hdr.changed()) || r.statusCode != 0
 }
 
 func (r *Response) FetchChanges(builder *flatbuffers.Builder) bool {
@@ -239,17 +229,58 @@ func (r *Response) FetchChanges(builder *flatbuffers.Builder) bool {
 	return true
 }
 
+// DiscardChanges drops the changes made by the plugins
+func (r *Response) DiscardChanges() {
+	r.body = nil
+	r.statusCode = 0
+	r.hdr = nil
+}
+
+// Fail discards the changes, and replaces the response with the status code.
+// The body from the upstream is replaced with the status text, so that it is not leaked.
+func (r *Response) Fail(statusCode int) {
+	r.DiscardChanges()
+	r.statusCode = statusCode
+	r.body = bytes.NewBufferString(http.StatusText(statusCode))
+}
+
 func (r *Response) BindConn(c net.Conn) {
 	r.conn = c
 }
 
+// SetContext replaces the response's context. Like Request.SetContext, the ctx should be
+// derived from the original one.
+func (r *Response) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+func (r *Response) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+func (r *Response) Ctx() pkgHTTP.Store {
+	if r.store == nil {
+		r.store = NewStore()
+	}
+	return r.store
+}
+
 func (r *Response) Reset() {
+	if r.cancel != nil {
+		defer r.cancel()
+	}
 	r.body = nil
 	r.statusCode = 0
 	r.hdr = nil
 	r.conn = nil
 	r.vars = nil
 	r.originBody = nil
+	r.ctx = nil
+	r.cancel = nil
+	r.store = nil
 }
 
 var respPool = sync.Pool{
@@ -259,12 +290,43 @@ var respPool = sync.Pool{
 }
 
 func CreateResponse(buf []byte) *Response {
+	return CreateResponseWithContext(context.Background(), buf)
+}
+
+// CreateResponseWithContext is like CreateResponse, but the response's context is derived
+// from the given ctx, so that the response can be canceled when the server is exiting.
+// Like the request, the context times out before the implicit timeout of APISIX.
+func CreateResponseWithContext(ctx context.Context, buf []byte) *Response {
 	resp := respPool.Get().(*Response)
 
 	resp.r = hrc.GetRootAsReq(buf, 0)
+	resp.ctx, resp.cancel = context.WithTimeout(ctx, rpcTimeout)
 	return resp
 }
 
+// Snapshot returns a copy of the response like Request.Snapshot.
+// It should be released with ReuseResponse.
+func (r *Response) Snapshot(ctx context.Context) *Response {
+	c := respPool.Get().(*Response)
+	c.r = r.r
+	c.conn = r.conn
+	c.hdr = copyHeader(r.hdr)
+	c.statusCode = r.statusCode
+	if r.body != nil {
+		c.body = bytes.NewBuffer(append([]byte{}, r.body.Bytes()...))
+	}
+	if r.vars != nil {
+		c.vars = make(map[string][]byte, len(r.vars))
+		for k, v := range r.vars {
+			c.vars[k] = v
+		}
+	}
+	c.originBody = r.originBody
+	c.store = copyStore(r.store)
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	return c
+}
+
 func ReuseResponse(r *Response) {
 	r.Reset()
 	respPool.Put(r)
@@ -173,6 +173,52 @@ func TestResponse_Header(t *testing.T) {
 	ReuseResponse(r)
 }
 
+func TestResponse_Header_MultiValue(t *testing.T) {
+	out := buildRespReq(respReqOpt{headers: []pair{
+		{"set-cookie", "a=1"},
+		{"vary", "Accept"},
+	}})
+	r := CreateResponse(out)
+	hdr := r.Header()
+	hdr.Add("Set-Cookie", "b=2")
+	hdr.Add("Set-Cookie", "c=3")
+	assert.Equal(t, []string{"a=1", "b=2", "c=3"}, hdr.Values("set-cookie"))
+
+	// unchanged values are not sent back
+	hdr.Set("vary", "Origin")
+	hdr.Set("vary", "Accept")
+
+	builder := util.GetBuilder()
+	assert.True(t, r.FetchChanges(builder))
+	resp := hrc.GetRootAsResp(builder.FinishedBytes(), 0)
+
+	res := http.Header{}
+	for i := 0; i < resp.HeadersLength(); i++ {
+		e := &A6.TextEntry{}
+		resp.Headers(e, i)
+		res.Add(string(e.Name()), string(e.Value()))
+	}
+	exp := http.Header{
+		"Set-Cookie": []string{"a=1", "b=2", "c=3"},
+	}
+	assert.Equal(t, exp, res)
+	ReuseResponse(r)
+}
+
+func TestResponse_HeaderRead(t *testing.T) {
+	out := buildRespReq(respReqOpt{headers: []pair{
+		{"k", "v"},
+	}})
+	r := CreateResponse(out)
+	assert.Equal(t, http.Header{"K": []string{"v"}}, r.Header().Clone())
+	r.Header().Set("k", "v")
+	assert.False(t, r.HasChange())
+
+	r.Header().Del("k")
+	assert.True(t, r.HasChange())
+	ReuseResponse(r)
+}
+
 func TestResponse_Write(t *testing.T) {
 	out := buildRespReq(respReqOpt{
 		id:         1234,
@@ -0,0 +1,92 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"sort"
+	"sync"
+)
+
+// Store implements pkgHTTP.Store. It is safe for concurrent use, as the plugins may
+// access it from their own goroutines.
+type Store struct {
+	lock sync.RWMutex
+	m    map[string]interface{}
+}
+
+func NewStore() *Store {
+	return &Store{m: map[string]interface{}{}}
+}
+
+func (s *Store) Get(key string) (interface{}, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	v, ok := s.m[key]
+	return v, ok
+}
+
+func (s *Store) Set(key string, value interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.m[key] = value
+}
+
+func (s *Store) Del(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.m, key)
+}
+
+// Range iterates the store in the order of keys. The store can be modified in f.
+func (s *Store) Range(f func(key string, value interface{}) bool) {
+	s.lock.RLock()
+	keys := make([]string, 0, len(s.m))
+	for k := range s.m {
+		keys = append(keys, k)
+	}
+	s.lock.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if v, ok := s.Get(k); ok {
+			if !f(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// copyStore returns a copy of the store. The values are not copied.
+func copyStore(s *Store) *Store {
+	if s == nil {
+		return nil
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	c := &Store{m: make(map[string]interface{}, len(s.m))}
+	for k, v := range s.m {
+		c.m[k] = v
+	}
+	return c
+}
+
+func (s *Store) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.m)
+}
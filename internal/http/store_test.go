@@ -0,0 +1,70 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
+)
+
+func TestStore(t *testing.T) {
+	r := CreateRequest(buildReq(reqOpt{}))
+	assert.Nil(t, r.Store())
+
+	s := r.Ctx()
+	s.Set("b", 2)
+	s.Set("a", "1")
+	s.Set("c", 3)
+	s.Del("c")
+
+	v, ok := s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", v)
+
+	keys := []string{}
+	s.Range(func(key string, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []string{"a", "b"}, keys)
+	assert.Equal(t, 2, r.Store().Len())
+
+	ReuseRequest(r)
+	assert.Nil(t, r.Store())
+}
+
+func TestStoreKey(t *testing.T) {
+	const countKey = pkgHTTP.Key[int]("count")
+	s := NewStore()
+
+	_, ok := countKey.Get(s)
+	assert.False(t, ok)
+	countKey.Set(s, 1)
+	n, ok := countKey.Get(s)
+	assert.True(t, ok)
+	assert.Equal(t, 1, n)
+
+	// the value in a different type
+	s.Set("count", "1")
+	n, ok = countKey.Get(s)
+	assert.False(t, ok)
+	assert.Equal(t, 0, n)
+}
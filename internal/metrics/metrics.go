@@ -0,0 +1,250 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package metrics collects the runner's metrics and exposes them in the Prometheus
+// text format. The metrics are always collected, the HTTP listener is optional.
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+)
+
+const namespace = "apisix_go_runner"
+
+const (
+	resultOK    = "ok"
+	resultError = "error"
+)
+
+var (
+	// use our own registry so that the metrics of the embedding application are not mixed
+	registry = prometheus.NewRegistry()
+
+	rpcTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_total",
+		Help:      "Number of the RPCs, partitioned by type and result.",
+	}, []string{"type", "result"})
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Latency of the RPCs, partitioned by type.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type"})
+
+	filterDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "plugin_filter_duration_seconds",
+		Help:      "Latency of the plugin filters, partitioned by plugin and phase.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"plugin", "phase"})
+	filterShortCircuitTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plugin_short_circuit_total",
+		Help:      "Number of the responses generated by the plugin filters, which stop the plugin chain.",
+	}, []string{"plugin", "phase"})
+	filterPanicsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plugin_panics_total",
+		Help:      "Number of the panics recovered from the plugin filters.",
+	}, []string{"plugin", "phase"})
+	filterTimeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plugin_timeouts_total",
+		Help:      "Number of the plugin filters exceeding their timeouts.",
+	}, []string{"plugin", "phase"})
+
+	confCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "conf_cache_size",
+		Help:      "Number of the cached confs.",
+	})
+	confCacheHitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "conf_cache_hits_total",
+		Help:      "Number of the conf lookups which find the conf token.",
+	})
+	confCacheMissesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "conf_cache_misses_total",
+		Help:      "Number of the conf lookups which don't find the conf token.",
+	})
+	confCacheEvictionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "conf_cache_evictions_total",
+		Help:      "Number of the confs evicted from the cache, partitioned by reason.",
+	}, []string{"reason"})
+
+	activeConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_connections",
+		Help:      "Number of the connections from APISIX.",
+	})
+
+	dataTooLargeTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "data_too_large_total",
+		Help:      "Number of the responses rejected because they exceed the max data size.",
+	})
+	frameRefusedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "frame_refused_total",
+		Help:      "Number of the frames from APISIX refused by the limits, partitioned by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	registry.MustRegister(
+		rpcTotal,
+		rpcDuration,
+		filterDuration,
+		filterShortCircuitTotal,
+		filterPanicsTotal,
+		filterTimeoutsTotal,
+		confCacheSize,
+		confCacheHitsTotal,
+		confCacheMissesTotal,
+		confCacheEvictionsTotal,
+		activeConnections,
+		dataTooLargeTotal,
+		frameRefusedTotal,
+	)
+}
+
+var rpcTypeNames = map[byte]string{
+	util.RPCError:        "RPCError",
+	util.RPCPrepareConf:  "RPCPrepareConf",
+	util.RPCHTTPReqCall:  "RPCHTTPReqCall",
+	util.RPCExtraInfo:    "RPCExtraInfo",
+	util.RPCHTTPRespCall: "RPCHTTPRespCall",
+}
+
+func rpcTypeName(ty byte) string {
+	if name, ok := rpcTypeNames[ty]; ok {
+		return name
+	}
+	// don't create a new label for each unknown type
+	return "unknown"
+}
+
+// ObserveRPC records an RPC of the given type which is started at the start
+func ObserveRPC(ty byte, start time.Time, failed bool) {
+	name := rpcTypeName(ty)
+	result := resultOK
+	if failed {
+		result = resultError
+	}
+	rpcTotal.WithLabelValues(name, result).Inc()
+	rpcDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+}
+
+// ObserveFilter records a run of the plugin's filter in the given phase.
+// The shortCircuit means the filter generates the response and stops the plugin chain.
+func ObserveFilter(plugin, phase string, start time.Time, shortCircuit bool) {
+	filterDuration.WithLabelValues(plugin, phase).Observe(time.Since(start).Seconds())
+	if shortCircuit {
+		filterShortCircuitTotal.WithLabelValues(plugin, phase).Inc()
+	}
+}
+
+// FilterPanicked records a panic recovered from the plugin's filter in the given phase
+func FilterPanicked(plugin, phase string) {
+	filterPanicsTotal.WithLabelValues(plugin, phase).Inc()
+}
+
+// FilterTimedOut records a run of the plugin's filter exceeding its timeout in the given phase
+func FilterTimedOut(plugin, phase string) {
+	filterTimeoutsTotal.WithLabelValues(plugin, phase).Inc()
+}
+
+// ResetConfCache is called when the conf cache is recreated
+func ResetConfCache() {
+	confCacheSize.Set(0)
+}
+
+// ConfCached is called when a conf is added to the conf cache
+func ConfCached() {
+	confCacheSize.Inc()
+}
+
+// ConfEvicted is called when a conf is removed from the conf cache
+func ConfEvicted(reason string) {
+	confCacheSize.Dec()
+	confCacheEvictionsTotal.WithLabelValues(reason).Inc()
+}
+
+// ConfLookedUp records a lookup of the conf token
+func ConfLookedUp(found bool) {
+	if found {
+		confCacheHitsTotal.Inc()
+	} else {
+		confCacheMissesTotal.Inc()
+	}
+}
+
+// ConnOpened is called when APISIX connects to the runner
+func ConnOpened() {
+	activeConnections.Inc()
+}
+
+// ConnClosed is called when the connection from APISIX is closed
+func ConnClosed() {
+	activeConnections.Dec()
+}
+
+// DataTooLarge records a response which is rejected because of its size
+func DataTooLarge() {
+	dataTooLargeTotal.Inc()
+}
+
+// FrameRefused records a frame refused by the limits. The reason is the scope of the
+// broken memory limit, "timeout", or "queue" for the RPC beyond the concurrent ones queued.
+func FrameRefused(reason string) {
+	frameRefusedTotal.WithLabelValues(reason).Inc()
+}
+
+// Handler returns the HTTP handler which exposes the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Serve starts an HTTP server to expose the metrics at /metrics on the addr.
+// The returned server should be closed by the caller.
+func Serve(addr string) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Errorf("metrics server: %s", err)
+		}
+	}()
+	return srv, nil
+}
@@ -0,0 +1,86 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+)
+
+func TestObserveRPC(t *testing.T) {
+	ObserveRPC(util.RPCPrepareConf, time.Now(), false)
+	ObserveRPC(util.RPCHTTPReqCall, time.Now(), false)
+	ObserveRPC(util.RPCHTTPReqCall, time.Now(), true)
+	ObserveRPC(127, time.Now(), true)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(rpcTotal.WithLabelValues("RPCPrepareConf", "ok")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rpcTotal.WithLabelValues("RPCHTTPReqCall", "ok")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rpcTotal.WithLabelValues("RPCHTTPReqCall", "error")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(rpcTotal.WithLabelValues("unknown", "error")))
+	assert.Equal(t, 3, testutil.CollectAndCount(rpcDuration))
+}
+
+func TestObserveFilter(t *testing.T) {
+	ObserveFilter("foo", "request", time.Now(), false)
+	ObserveFilter("foo", "request", time.Now(), true)
+	ObserveFilter("bar", "response", time.Now(), false)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(filterShortCircuitTotal.WithLabelValues("foo", "request")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(filterShortCircuitTotal.WithLabelValues("bar", "response")))
+	assert.Equal(t, 2, testutil.CollectAndCount(filterDuration))
+}
+
+func TestConfCache(t *testing.T) {
+	ResetConfCache()
+	ConfCached()
+	ConfCached()
+	ConfEvicted("Expired")
+	ConfLookedUp(true)
+	ConfLookedUp(false)
+	ConfLookedUp(false)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(confCacheSize))
+	assert.Equal(t, float64(1), testutil.ToFloat64(confCacheEvictionsTotal.WithLabelValues("Expired")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(confCacheHitsTotal))
+	assert.Equal(t, float64(2), testutil.ToFloat64(confCacheMissesTotal))
+
+	ResetConfCache()
+	assert.Equal(t, float64(0), testutil.ToFloat64(confCacheSize))
+}
+
+func TestHandler(t *testing.T) {
+	ConnOpened()
+	ConnOpened()
+	ConnClosed()
+	DataTooLarge()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "apisix_go_runner_active_connections 1\n")
+	assert.Contains(t, body, "apisix_go_runner_data_too_large_total 1\n")
+	assert.Contains(t, body, "# TYPE apisix_go_runner_rpc_duration_seconds histogram")
+}
@@ -18,6 +18,8 @@
 package plugin
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 	"sync"
 	"time"
@@ -27,14 +29,49 @@ import (
 	pc "github.com/api7/ext-plugin-proto/go/A6/PrepareConf"
 	flatbuffers "github.com/google/flatbuffers/go"
 
+	"github.com/apache/apisix-go-plugin-runner/internal/metrics"
 	"github.com/apache/apisix-go-plugin-runner/internal/util"
 	"github.com/apache/apisix-go-plugin-runner/pkg/log"
 )
 
 var (
 	cache *ConfCache
+
+	strictConf       bool
+	pluginStrictConf map[string]bool
 )
 
+// ErrInvalidConf is returned by PrepareConf in the strict mode, when a plugin's conf
+// can't be parsed or the plugin is not found
+type ErrInvalidConf struct {
+	Plugin string
+	Err    error
+}
+
+func (err ErrInvalidConf) Error() string {
+	return fmt.Sprintf("invalid conf for plugin %s: %s", err.Plugin, err.Err)
+}
+
+func (err ErrInvalidConf) Unwrap() error {
+	return err.Err
+}
+
+// SetStrictConf configures whether PrepareConf fails when a plugin's conf is invalid.
+// The perPlugin overrides the global setting for the given plugins.
+// In the lenient mode, the plugin with invalid conf is skipped.
+// This method should be called before calling `server.Run`.
+func SetStrictConf(global bool, perPlugin map[string]bool) {
+	strictConf = global
+	pluginStrictConf = perPlugin
+}
+
+func isStrictConf(name string) bool {
+	if strict, ok := pluginStrictConf[name]; ok {
+		return strict
+	}
+	return strictConf
+}
+
 type ConfEntry struct {
 	Name  string
 	Value interface{}
@@ -48,6 +85,10 @@ type ConfCache struct {
 	keyCache   *ttlcache.Cache
 
 	tokenCounter uint32
+
+	// releasing tracks the expired or removed entries whose release is in flight, as the
+	// cache runs the callbacks in their own goroutines
+	releasing sync.WaitGroup
 }
 
 func newConfCache(ttl time.Duration) *ConfCache {
@@ -63,9 +104,64 @@ func newConfCache(ttl time.Duration) *ConfCache {
 		cache.SkipTTLExtensionOnHit(false)
 		*c = cache
 	}
+	// it is called before the expiration callback is started
+	cc.tokenCache.SetCheckExpirationCallback(func(key string, value interface{}) bool {
+		cc.releasing.Add(1)
+		return true
+	})
+	cc.tokenCache.SetExpirationReasonCallback(cc.onTokenEvicted)
 	return cc
 }
 
+func (cc *ConfCache) onTokenEvicted(key string, reason ttlcache.EvictionReason, value interface{}) {
+	if reason == ttlcache.Expired || reason == ttlcache.Removed {
+		defer cc.releasing.Done()
+	}
+
+	log.Infof("release conf of token %s, reason: %s", key, reason)
+	metrics.ConfEvicted(reason.String())
+	releaseRuleConf(value.(RuleConf))
+}
+
+func releaseRuleConf(conf RuleConf) {
+	for _, c := range conf {
+		plugin := findPlugin(c.Name)
+		if plugin == nil || plugin.ReleaseConf == nil {
+			continue
+		}
+		releaseConf(c.Name, plugin.ReleaseConf, c.Value)
+	}
+}
+
+func releaseConf(name string, f ReleaseConfFunc, conf interface{}) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("panic recovered when releasing conf of plugin %s: %s", name, err)
+		}
+	}()
+	f(conf)
+}
+
+// Close releases all cached confs and stops the cache. It returns after the confs which are
+// expired before are released too.
+func (cc *ConfCache) Close() {
+	// no conf is cached meanwhile, and the ones cached later are released by Set
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	for _, key := range cc.tokenCache.GetKeys() {
+		cc.releasing.Add(1)
+		if err := cc.tokenCache.Remove(key); err != nil {
+			// already expired
+			cc.releasing.Done()
+		}
+	}
+
+	cc.tokenCache.Close()
+	cc.keyCache.Close()
+	cc.releasing.Wait()
+}
+
 func (cc *ConfCache) Set(req *pc.Req) (uint32, error) {
 	cc.lock.Lock()
 	defer cc.lock.Unlock()
@@ -91,6 +187,10 @@ func (cc *ConfCache) Set(req *pc.Req) (uint32, error) {
 			name := string(te.Name())
 			plugin := findPlugin(name)
 			if plugin == nil {
+				if isStrictConf(name) {
+					releaseRuleConf(entries)
+					return 0, ErrInvalidConf{Plugin: name, Err: errors.New("plugin not found")}
+				}
 				log.Warnf("can't find plugin %s, skip", name)
 				continue
 			}
@@ -98,8 +198,12 @@ func (cc *ConfCache) Set(req *pc.Req) (uint32, error) {
 			log.Infof("prepare conf for plugin %s", name)
 
 			v := te.Value()
-			conf, err := plugin.ParseConf(v)
+			conf, err := plugin.parseConf(v)
 			if err != nil {
+				if isStrictConf(name) {
+					releaseRuleConf(entries)
+					return 0, ErrInvalidConf{Plugin: name, Err: err}
+				}
 				log.Errorf(
 					"failed to parse configuration for plugin %s, configuration: %s, err: %v",
 					name, string(v), err)
@@ -117,8 +221,11 @@ func (cc *ConfCache) Set(req *pc.Req) (uint32, error) {
 	token := cc.tokenCounter
 	err := cc.tokenCache.Set(strconv.FormatInt(int64(token), 10), entries)
 	if err != nil {
+		// like the cache is closed
+		releaseRuleConf(entries)
 		return 0, err
 	}
+	metrics.ConfCached()
 
 	err = cc.keyCache.Set(key, token)
 	return token, err
@@ -130,6 +237,7 @@ func (cc *ConfCache) SetInTest(token uint32, entries RuleConf) error {
 
 func (cc *ConfCache) Get(token uint32) (RuleConf, error) {
 	res, err := cc.tokenCache.Get(strconv.FormatInt(int64(token), 10))
+	metrics.ConfLookedUp(err == nil)
 	if err != nil {
 		return nil, err
 	}
@@ -138,9 +246,19 @@ func (cc *ConfCache) Get(token uint32) (RuleConf, error) {
 
 func InitConfCache(ttl time.Duration) {
 	cache = newConfCache(ttl)
+	metrics.ResetConfCache()
+}
+
+// CloseConfCache releases all cached confs. It should be called when the runner is exiting.
+func CloseConfCache() {
+	cache.Close()
 }
 
 func PrepareConf(buf []byte) (*flatbuffers.Builder, error) {
+	if err := checkPrepareConf(buf); err != nil {
+		return nil, err
+	}
+
 	req := pc.GetRootAsReq(buf, 0)
 
 	token, err := cache.Set(req)
@@ -160,6 +278,20 @@ func GetRuleConf(token uint32) (RuleConf, error) {
 	return cache.Get(token)
 }
 
+// ParsePluginConf parses the conf of the named plugin like PrepareConf, including the
+// validation with the schema. Unlike PrepareConf, it fails if the plugin is not found.
+func ParsePluginConf(name string, value []byte) (interface{}, error) {
+	plugin := findPlugin(name)
+	if plugin == nil {
+		return nil, ErrInvalidConf{Plugin: name, Err: errors.New("plugin not found")}
+	}
+	conf, err := plugin.parseConf(value)
+	if err != nil {
+		return nil, ErrInvalidConf{Plugin: name, Err: err}
+	}
+	return conf, nil
+}
+
 func SetRuleConfInTest(token uint32, conf RuleConf) error {
 	return cache.SetInTest(token, conf)
 }
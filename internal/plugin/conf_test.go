@@ -22,6 +22,7 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -52,7 +53,7 @@ func TestPrepareConf(t *testing.T) {
 	assert.Equal(t, uint32(2), resp.ConfToken())
 }
 
-func prepareConfWithData(builder *flatbuffers.Builder, arg ...flatbuffers.UOffsetT) {
+func prepareConfWithData(builder *flatbuffers.Builder, arg ...flatbuffers.UOffsetT) (*flatbuffers.Builder, error) {
 	tes := []flatbuffers.UOffsetT{}
 	for i := 0; i < len(arg); i += 2 {
 		A6.TextEntryStart(builder)
@@ -76,7 +77,7 @@ func prepareConfWithData(builder *flatbuffers.Builder, arg ...flatbuffers.UOffse
 	builder.Finish(root)
 	b := builder.FinishedBytes()
 
-	PrepareConf(b)
+	return PrepareConf(b)
 }
 
 func TestPrepareConfUnknownPlugin(t *testing.T) {
@@ -265,3 +266,162 @@ func TestGetRuleConfCheckConf(t *testing.T) {
 	assert.Equal(t, 1, len(res))
 	assert.Equal(t, "echo", res[0].Name)
 }
+
+func TestReleaseConfWhenExpired(t *testing.T) {
+	released := make(chan interface{}, 1)
+	RegisterPlugin("release-expired", emptyParseConf, emptyRequestFilter, emptyResponseFilter,
+		WithReleaseConf(func(conf interface{}) {
+			released <- conf
+		}),
+	)
+
+	InitConfCache(10 * time.Millisecond)
+	builder := flatbuffers.NewBuilder(1024)
+	name := builder.CreateString("release-expired")
+	value := builder.CreateString(`{"body":"yes"}`)
+	prepareConfWithData(builder, name, value)
+
+	select {
+	case conf := <-released:
+		assert.Equal(t, `{"body":"yes"}`, conf)
+	case <-time.After(time.Second):
+		t.Fatal("conf is not released")
+	}
+}
+
+func TestReleaseConfWhenClosed(t *testing.T) {
+	var lock sync.Mutex
+	released := []interface{}{}
+	RegisterPlugin("release-closed", emptyParseConf, emptyRequestFilter, emptyResponseFilter,
+		WithReleaseConf(func(conf interface{}) {
+			lock.Lock()
+			released = append(released, conf)
+			lock.Unlock()
+			panic("should be recovered")
+		}),
+	)
+
+	InitConfCache(time.Hour)
+	builder := flatbuffers.NewBuilder(1024)
+	name := builder.CreateString("release-closed")
+	value := builder.CreateString(`a`)
+	prepareConfWithData(builder, name, value)
+	builder = flatbuffers.NewBuilder(1024)
+	name = builder.CreateString("release-closed")
+	value = builder.CreateString(`b`)
+	prepareConfWithData(builder, name, value)
+
+	CloseConfCache()
+	sort.Slice(released, func(i, j int) bool {
+		return released[i].(string) < released[j].(string)
+	})
+	assert.Equal(t, []interface{}{"a", "b"}, released)
+}
+
+func TestReleaseConfExpiredBeforeClose(t *testing.T) {
+	var released int32
+	RegisterPlugin("release-in-flight", emptyParseConf, emptyRequestFilter, emptyResponseFilter,
+		WithReleaseConf(func(conf interface{}) {
+			time.Sleep(100 * time.Millisecond)
+			atomic.AddInt32(&released, 1)
+		}),
+	)
+
+	InitConfCache(10 * time.Millisecond)
+	builder := flatbuffers.NewBuilder(1024)
+	name := builder.CreateString("release-in-flight")
+	value := builder.CreateString(`a`)
+	prepareConfWithData(builder, name, value)
+	// the conf is expired, and its release is in flight
+	time.Sleep(50 * time.Millisecond)
+
+	CloseConfCache()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&released))
+}
+
+func TestReleaseConfWhenSetFails(t *testing.T) {
+	released := make(chan interface{}, 1)
+	RegisterPlugin("release-set-fails", emptyParseConf, emptyRequestFilter, emptyResponseFilter,
+		WithReleaseConf(func(conf interface{}) {
+			released <- conf
+		}),
+	)
+
+	InitConfCache(time.Hour)
+	CloseConfCache()
+	builder := flatbuffers.NewBuilder(1024)
+	name := builder.CreateString("release-set-fails")
+	value := builder.CreateString(`a`)
+	_, err := prepareConfWithData(builder, name, value)
+	assert.NotNil(t, err)
+	assert.Equal(t, "a", <-released)
+}
+
+func TestPrepareConfStrict(t *testing.T) {
+	SetStrictConf(true, nil)
+	defer SetStrictConf(false, nil)
+
+	released := make(chan interface{}, 1)
+	RegisterPlugin("strict-good", emptyParseConf, emptyRequestFilter, emptyResponseFilter,
+		WithReleaseConf(func(conf interface{}) {
+			released <- conf
+		}),
+	)
+	f := func(in []byte) (conf interface{}, err error) {
+		return nil, errors.New("ouch")
+	}
+	RegisterPlugin("strict-bad", f, emptyRequestFilter, emptyResponseFilter)
+
+	InitConfCache(10 * time.Millisecond)
+	builder := flatbuffers.NewBuilder(1024)
+	good := builder.CreateString("strict-good")
+	goodConf := builder.CreateString("a")
+	bad := builder.CreateString("strict-bad")
+	badConf := builder.CreateString("b")
+	_, err := prepareConfWithData(builder, good, goodConf, bad, badConf)
+	assert.Equal(t, ErrInvalidConf{Plugin: "strict-bad", Err: errors.New("ouch")}, err)
+	assert.Equal(t, "invalid conf for plugin strict-bad: ouch", err.Error())
+	// the parsed conf is released
+	assert.Equal(t, "a", <-released)
+
+	builder = flatbuffers.NewBuilder(1024)
+	name := builder.CreateString("strict-unknown")
+	value := builder.CreateString("a")
+	_, err = prepareConfWithData(builder, name, value)
+	assert.Equal(t, "strict-unknown", err.(ErrInvalidConf).Plugin)
+
+	// the token is not consumed
+	builder = flatbuffers.NewBuilder(1024)
+	good = builder.CreateString("strict-good")
+	goodConf = builder.CreateString("a")
+	bd, err := prepareConfWithData(builder, good, goodConf)
+	assert.Nil(t, err)
+	resp := pc.GetRootAsResp(bd.FinishedBytes(), 0)
+	assert.Equal(t, uint32(1), resp.ConfToken())
+}
+
+func TestPrepareConfPluginStrict(t *testing.T) {
+	f := func(in []byte) (conf interface{}, err error) {
+		return nil, errors.New("ouch")
+	}
+	RegisterPlugin("plugin-strict-bad", f, emptyRequestFilter, emptyResponseFilter)
+	InitConfCache(10 * time.Millisecond)
+
+	SetStrictConf(false, map[string]bool{"plugin-strict-bad": true})
+	builder := flatbuffers.NewBuilder(1024)
+	name := builder.CreateString("plugin-strict-bad")
+	value := builder.CreateString("a")
+	_, err := prepareConfWithData(builder, name, value)
+	assert.NotNil(t, err)
+
+	SetStrictConf(true, map[string]bool{"plugin-strict-bad": false})
+	builder = flatbuffers.NewBuilder(1024)
+	name = builder.CreateString("plugin-strict-bad")
+	value = builder.CreateString("a")
+	_, err = prepareConfWithData(builder, name, value)
+	assert.Nil(t, err)
+	res, _ := GetRuleConf(1)
+	assert.Equal(t, 0, len(res))
+
+	SetStrictConf(false, nil)
+}
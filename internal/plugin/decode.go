@@ -0,0 +1,97 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"fmt"
+
+	A6 "github.com/api7/ext-plugin-proto/go/A6"
+	hreqc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	hrespc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
+	pc "github.com/api7/ext-plugin-proto/go/A6/PrepareConf"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// ErrMalformedRPC is returned when the RPC's data is not a valid flatbuffers message
+type ErrMalformedRPC struct {
+	RPC    string
+	Reason string
+}
+
+func (err ErrMalformedRPC) Error() string {
+	return fmt.Sprintf("malformed %s: %s", err.RPC, err.Reason)
+}
+
+// The flatbuffers accessors don't check the offsets, and panic when they point out of the
+// buffer. So the fields are walked through before the RPC is handled, and the panic is turned
+// into ErrMalformedRPC. As the accessors are deterministic, the later access can't panic.
+
+func checkRPC(rpc string, buf []byte, walk func(buf []byte)) (err error) {
+	if len(buf) < flatbuffers.SizeUOffsetT {
+		return ErrMalformedRPC{RPC: rpc, Reason: fmt.Sprintf("only %d bytes", len(buf))}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrMalformedRPC{RPC: rpc, Reason: fmt.Sprint(r)}
+		}
+	}()
+	walk(buf)
+	return nil
+}
+
+func walkTextEntries(n int, get func(obj *A6.TextEntry, j int) bool) {
+	te := A6.TextEntry{}
+	for i := 0; i < n; i++ {
+		if get(&te, i) {
+			te.Name()
+			te.Value()
+		}
+	}
+}
+
+func checkPrepareConf(buf []byte) error {
+	return checkRPC("PrepareConf", buf, func(buf []byte) {
+		req := pc.GetRootAsReq(buf, 0)
+		req.Key()
+		walkTextEntries(req.ConfLength(), req.Conf)
+	})
+}
+
+func checkHTTPReqCall(buf []byte) error {
+	return checkRPC("HTTPReqCall", buf, func(buf []byte) {
+		req := hreqc.GetRootAsReq(buf, 0)
+		req.Id()
+		req.ConfToken()
+		req.SrcIpBytes()
+		req.Method()
+		req.Path()
+		walkTextEntries(req.ArgsLength(), req.Args)
+		walkTextEntries(req.HeadersLength(), req.Headers)
+	})
+}
+
+func checkHTTPRespCall(buf []byte) error {
+	return checkRPC("HTTPRespCall", buf, func(buf []byte) {
+		resp := hrespc.GetRootAsReq(buf, 0)
+		resp.Id()
+		resp.ConfToken()
+		resp.Status()
+		walkTextEntries(resp.HeadersLength(), resp.Headers)
+	})
+}
@@ -0,0 +1,157 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	A6 "github.com/api7/ext-plugin-proto/go/A6"
+	hreqc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	hrespc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
+	pc "github.com/api7/ext-plugin-proto/go/A6/PrepareConf"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+)
+
+func buildTextEntries(builder *flatbuffers.Builder, kvs ...string) []flatbuffers.UOffsetT {
+	tes := []flatbuffers.UOffsetT{}
+	for i := 0; i < len(kvs); i += 2 {
+		name := builder.CreateString(kvs[i])
+		value := builder.CreateString(kvs[i+1])
+		A6.TextEntryStart(builder)
+		A6.TextEntryAddName(builder, name)
+		A6.TextEntryAddValue(builder, value)
+		tes = append(tes, A6.TextEntryEnd(builder))
+	}
+	return tes
+}
+
+func buildVector(builder *flatbuffers.Builder, tes []flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+	builder.StartVector(flatbuffers.SizeUOffsetT, len(tes), flatbuffers.SizeUOffsetT)
+	for i := len(tes) - 1; i >= 0; i-- {
+		builder.PrependUOffsetT(tes[i])
+	}
+	return builder.EndVector(len(tes))
+}
+
+func buildPrepareConf() []byte {
+	builder := flatbuffers.NewBuilder(1024)
+	confs := buildVector(builder, buildTextEntries(builder, "not-found", `{"body":"x"}`))
+	key := builder.CreateString("route")
+	pc.ReqStart(builder)
+	pc.ReqAddConf(builder, confs)
+	pc.ReqAddKey(builder, key)
+	builder.Finish(pc.ReqEnd(builder))
+	return builder.FinishedBytes()
+}
+
+func buildHTTPReqCall() []byte {
+	builder := flatbuffers.NewBuilder(1024)
+	path := builder.CreateString("/hello")
+	args := buildVector(builder, buildTextEntries(builder, "k", "v"))
+	hdrs := buildVector(builder, buildTextEntries(builder, "X-Req", "foo", "Traceparent", "bar"))
+	ip := builder.CreateByteVector([]byte{127, 0, 0, 1})
+	hreqc.ReqStart(builder)
+	hreqc.ReqAddId(builder, 1)
+	hreqc.ReqAddConfToken(builder, 1)
+	hreqc.ReqAddSrcIp(builder, ip)
+	hreqc.ReqAddMethod(builder, A6.MethodGET)
+	hreqc.ReqAddPath(builder, path)
+	hreqc.ReqAddArgs(builder, args)
+	hreqc.ReqAddHeaders(builder, hdrs)
+	builder.Finish(hreqc.ReqEnd(builder))
+	return builder.FinishedBytes()
+}
+
+func buildHTTPRespCall() []byte {
+	builder := flatbuffers.NewBuilder(1024)
+	hdrs := buildVector(builder, buildTextEntries(builder, "X-Resp", "foo"))
+	hrespc.ReqStart(builder)
+	hrespc.ReqAddId(builder, 1)
+	hrespc.ReqAddConfToken(builder, 1)
+	hrespc.ReqAddStatus(builder, 200)
+	hrespc.ReqAddHeaders(builder, hdrs)
+	builder.Finish(hrespc.ReqEnd(builder))
+	return builder.FinishedBytes()
+}
+
+// addSeeds adds the valid message, its truncated and corrupted copies
+func addSeeds(f *testing.F, valid []byte) {
+	f.Add(valid)
+	f.Add([]byte{})
+	f.Add(valid[:len(valid)/2])
+	corrupted := append([]byte{}, valid...)
+	corrupted[0] = 0xff
+	f.Add(corrupted)
+}
+
+func checkHandled(t *testing.T, bd *flatbuffers.Builder, err error) {
+	if err != nil {
+		return
+	}
+	assert.NotNil(t, bd)
+	util.PutBuilder(bd)
+}
+
+func TestMalformedRPC(t *testing.T) {
+	for _, buf := range [][]byte{nil, {1, 2}, {0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}} {
+		_, err := PrepareConf(buf)
+		assert.IsType(t, ErrMalformedRPC{}, err)
+		_, err = HTTPReqCall(context.Background(), buf, nil)
+		assert.IsType(t, ErrMalformedRPC{}, err)
+		_, err = HTTPRespCall(context.Background(), buf, nil)
+		assert.IsType(t, ErrMalformedRPC{}, err)
+	}
+
+	assert.NoError(t, checkPrepareConf(buildPrepareConf()))
+	assert.NoError(t, checkHTTPReqCall(buildHTTPReqCall()))
+	assert.NoError(t, checkHTTPRespCall(buildHTTPRespCall()))
+}
+
+func FuzzPrepareConf(f *testing.F) {
+	InitConfCache(time.Minute)
+	addSeeds(f, buildPrepareConf())
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		bd, err := PrepareConf(buf)
+		checkHandled(t, bd, err)
+	})
+}
+
+func FuzzHTTPReqCall(f *testing.F) {
+	InitConfCache(time.Minute)
+	SetRuleConfInTest(1, RuleConf{})
+	addSeeds(f, buildHTTPReqCall())
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		bd, err := HTTPReqCall(context.Background(), buf, nil)
+		checkHandled(t, bd, err)
+	})
+}
+
+func FuzzHTTPRespCall(f *testing.F) {
+	InitConfCache(time.Minute)
+	SetRuleConfInTest(1, RuleConf{})
+	addSeeds(f, buildHTTPRespCall())
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		bd, err := HTTPRespCall(context.Background(), buf, nil)
+		checkHandled(t, bd, err)
+	})
+}
@@ -0,0 +1,107 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	inHTTP "github.com/apache/apisix-go-plugin-runner/internal/http"
+	"github.com/apache/apisix-go-plugin-runner/internal/metrics"
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+)
+
+// FailurePolicy decides how the plugin chain goes on when the filter of a plugin fails
+type FailurePolicy struct {
+	// FailOpen skips the failed plugin and runs the next one. The changes to the response
+	// made by the panicked plugin are dropped, but the ones to the request are kept. All the
+	// changes made by the timed out plugin are dropped. Otherwise the request is stopped with Status.
+	FailOpen bool
+	// Status is the status code to stop the request with when the policy is fail-closed.
+	// Default to 500 for the panic, and 504 for the timeout.
+	Status int
+}
+
+func (p FailurePolicy) status(def int) int {
+	if p.Status == 0 {
+		return def
+	}
+	return p.Status
+}
+
+// applyToRequest applies the policy to the response of the request phase
+func (p FailurePolicy) applyToRequest(w *inHTTP.ReqResponse) {
+	w.Reset()
+	if !p.FailOpen {
+		w.WriteHeader(p.status(http.StatusInternalServerError))
+	}
+}
+
+// applyToResponse applies the policy to the response of the response phase
+func (p FailurePolicy) applyToResponse(w *inHTTP.Response) {
+	if p.FailOpen {
+		w.DiscardChanges()
+		return
+	}
+	w.Fail(p.status(http.StatusInternalServerError))
+}
+
+// WithPanicPolicy sets the policy applied when the filters of the plugin panic.
+// By default, the request is stopped with 500.
+func WithPanicPolicy(p FailurePolicy) Option {
+	return func(opt *pluginOpts) {
+		opt.PanicPolicy = p
+	}
+}
+
+// ErrFilterPanicked is recorded in the plugin's span when its filter panics
+type ErrFilterPanicked struct {
+	Plugin string
+	Phase  string
+	Value  interface{}
+}
+
+func (err ErrFilterPanicked) Error() string {
+	return fmt.Sprintf("panic in %s filter of plugin %s: %v", err.Phase, err.Plugin, err.Value)
+}
+
+// recoverFilter recovers the panic in the plugin's filter, so that it doesn't break the
+// connection shared by other requests
+func recoverFilter(name, phase string, perr *error) {
+	if r := recover(); r != nil {
+		err := ErrFilterPanicked{Plugin: name, Phase: phase, Value: r}
+		log.Errorf("%s\n%s", err, debug.Stack())
+		metrics.FilterPanicked(name, phase)
+		*perr = err
+	}
+}
+
+func runRequestFilter(name string, plugin *pluginOpts, conf interface{},
+	w *inHTTP.ReqResponse, r *inHTTP.Request) (err error) {
+
+	defer recoverFilter(name, "request", &err)
+	plugin.RequestFilter(conf, w, r)
+	return nil
+}
+
+func runResponseFilter(name string, plugin *pluginOpts, conf interface{}, w *inHTTP.Response) (err error) {
+	defer recoverFilter(name, "response", &err)
+	plugin.ResponseFilter(conf, w)
+	return nil
+}
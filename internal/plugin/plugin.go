@@ -18,17 +18,24 @@
 package plugin
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"sort"
 	"sync"
+	"time"
 
 	hreqc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
 	hrespc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
 	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"go.opentelemetry.io/otel/trace"
 
 	inHTTP "github.com/apache/apisix-go-plugin-runner/internal/http"
+	"github.com/apache/apisix-go-plugin-runner/internal/metrics"
+	"github.com/apache/apisix-go-plugin-runner/internal/tracing"
 	"github.com/apache/apisix-go-plugin-runner/internal/util"
 	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
 	"github.com/apache/apisix-go-plugin-runner/pkg/log"
@@ -37,15 +44,74 @@ import (
 type ParseConfFunc func(in []byte) (conf interface{}, err error)
 type RequestFilterFunc func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request)
 type ResponseFilterFunc func(conf interface{}, w pkgHTTP.Response)
+type InitFunc func() error
+type InitSettingsFunc func(settings []byte) error
+type CloseFunc func() error
+type ReleaseConfFunc func(conf interface{})
 
 type pluginOpts struct {
 	ParseConf      ParseConfFunc
 	RequestFilter  RequestFilterFunc
 	ResponseFilter ResponseFilterFunc
+
+	Init         InitFunc
+	InitSettings InitSettingsFunc
+	Close        CloseFunc
+	ReleaseConf  ReleaseConfFunc
+
+	// Schema is the JSON Schema of the conf
+	Schema []byte
+	schema *jsonschema.Schema
+
+	// PanicPolicy is applied when the filters panic
+	PanicPolicy FailurePolicy
+	// Timeout is the max execution time of the filters, and TimeoutPolicy is applied
+	// when it is exceeded. Zero means no limit.
+	Timeout       time.Duration
+	TimeoutPolicy FailurePolicy
+}
+
+// Option configures the optional part of a plugin
+type Option func(opt *pluginOpts)
+
+// WithInit sets the function called before the runner starts listening
+func WithInit(f InitFunc) Option {
+	return func(opt *pluginOpts) {
+		opt.Init = f
+	}
+}
+
+// WithInitSettings sets the function called with the plugin's global settings before Init
+func WithInitSettings(f InitSettingsFunc) Option {
+	return func(opt *pluginOpts) {
+		opt.InitSettings = f
+	}
+}
+
+// WithClose sets the function called when the runner is shutting down
+func WithClose(f CloseFunc) Option {
+	return func(opt *pluginOpts) {
+		opt.Close = f
+	}
+}
+
+// WithReleaseConf sets the function called with the parsed conf when it is
+// expired or evicted from the conf cache
+func WithReleaseConf(f ReleaseConfFunc) Option {
+	return func(opt *pluginOpts) {
+		opt.ReleaseConf = f
+	}
+}
+
+// WithSchema sets the JSON Schema which the raw conf is validated with before ParseConf
+func WithSchema(schema []byte) Option {
+	return func(opt *pluginOpts) {
+		opt.Schema = schema
+	}
 }
 
 type pluginRegistries struct {
-	sync.Mutex
+	sync.RWMutex
 	opts map[string]*pluginOpts
 }
 
@@ -69,7 +135,8 @@ var (
 	ResponsePhase = responsePhase{}
 )
 
-func RegisterPlugin(name string, pc ParseConfFunc, sv RequestFilterFunc, rsv ResponseFilterFunc) error {
+func RegisterPlugin(name string, pc ParseConfFunc, sv RequestFilterFunc, rsv ResponseFilterFunc,
+	opts ...Option) error {
 	log.Infof("register plugin %s", name)
 
 	if name == "" {
@@ -90,6 +157,17 @@ func RegisterPlugin(name string, pc ParseConfFunc, sv RequestFilterFunc, rsv Res
 		RequestFilter:  sv,
 		ResponseFilter: rsv,
 	}
+	for _, o := range opts {
+		o(opt)
+	}
+	if opt.Schema != nil {
+		s, err := compileSchema(name, opt.Schema)
+		if err != nil {
+			return fmt.Errorf("invalid schema of plugin %s: %w", name, err)
+		}
+		opt.schema = s
+	}
+
 	pluginRegistry.Lock()
 	defer pluginRegistry.Unlock()
 	if _, found := pluginRegistry.opts[name]; found {
@@ -100,16 +178,104 @@ func RegisterPlugin(name string, pc ParseConfFunc, sv RequestFilterFunc, rsv Res
 }
 
 func findPlugin(name string) *pluginOpts {
+	pluginRegistry.RLock()
+	defer pluginRegistry.RUnlock()
 	if opt, found := pluginRegistry.opts[name]; found {
 		return opt
 	}
 	return nil
 }
 
+func sortedPluginNames() []string {
+	pluginRegistry.RLock()
+	defer pluginRegistry.RUnlock()
+
+	names := make([]string, 0, len(pluginRegistry.opts))
+	for name := range pluginRegistry.opts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pluginSettings is the global settings of the plugins in JSON, keyed by the plugin name
+var pluginSettings map[string][]byte
+
+// SetPluginSettings sets the global settings handed to the plugins by InitPlugins.
+// This method should be called before calling `server.Run`.
+func SetPluginSettings(settings map[string][]byte) {
+	pluginSettings = settings
+}
+
+// InitPlugins calls the InitSettings and Init methods of the registered plugins in the order
+// of their names. It stops at the first error.
+func InitPlugins() error {
+	for name := range pluginSettings {
+		if findPlugin(name) == nil {
+			log.Warnf("settings of plugin %s are ignored as it is not registered", name)
+		}
+	}
+
+	for _, name := range sortedPluginNames() {
+		plugin := findPlugin(name)
+		if plugin.InitSettings != nil {
+			log.Infof("init settings of plugin %s", name)
+			if err := plugin.InitSettings(pluginSettings[name]); err != nil {
+				return fmt.Errorf("failed to init settings of plugin %s: %w", name, err)
+			}
+		}
+		if plugin.Init == nil {
+			continue
+		}
+
+		log.Infof("init plugin %s", name)
+		if err := plugin.Init(); err != nil {
+			return fmt.Errorf("failed to init plugin %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ClosePlugins calls the Close method of the registered plugins in the order of their names.
+func ClosePlugins() {
+	for _, name := range sortedPluginNames() {
+		plugin := findPlugin(name)
+		if plugin.Close == nil {
+			continue
+		}
+
+		log.Infof("close plugin %s", name)
+		if err := plugin.Close(); err != nil {
+			log.Errorf("failed to close plugin %s: %s", name, err)
+		}
+	}
+}
+
+func startRPCSpan(ctx context.Context, name string, id, token uint32) (context.Context, trace.Span) {
+	return tracing.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			tracing.RequestIDKey.Int64(int64(id)),
+			tracing.ConfTokenKey.Int64(int64(token)),
+		),
+	)
+}
+
+func startPluginSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, name, trace.WithAttributes(tracing.PluginNameKey.String(name)))
+}
+
 type requestPhase struct {
 }
 
-func (ph *requestPhase) filter(conf RuleConf, w *inHTTP.ReqResponse, r *inHTTP.Request) error {
+// filter runs the plugins of the conf. If a plugin times out, the request is left to it, and
+// the returned builder is the response to the RPC. With the fail-open policy, the chain goes on
+// with the snapshot of the request taken before running the plugin, see timeout.go.
+func (ph *requestPhase) filter(conf RuleConf, w *inHTTP.ReqResponse, r *inHTTP.Request) (*flatbuffers.Builder, error) {
+	// each plugin sees its own span in the request's context
+	ctx := r.Context()
+	origR := r
+
 	for _, c := range conf {
 		plugin := findPlugin(c.Name)
 		if plugin == nil {
@@ -119,14 +285,71 @@ func (ph *requestPhase) filter(conf RuleConf, w *inHTTP.ReqResponse, r *inHTTP.R
 
 		log.Infof("run plugin %s", c.Name)
 
-		plugin.RequestFilter(c.Value, w, r)
+		pluginCtx, span := startPluginSpan(ctx, c.Name)
+		r.SetContext(pluginCtx)
+
+		start := time.Now()
+		var err error
+		if timeout := filterTimeout(plugin, c.Value); timeout > 0 {
+			policy := plugin.TimeoutPolicy
+			var snapW *inHTTP.ReqResponse
+			var snapR *inHTTP.Request
+			if policy.FailOpen {
+				// the plugin may still change them after the timeout
+				snapW, snapR = w.Snapshot(), r.Snapshot(ctx)
+			}
+
+			timeoutCtx, cancel := context.WithTimeout(pluginCtx, timeout)
+			r.SetContext(timeoutCtx)
+			// the goroutine keeps its own w and r, which are replaced after the timeout
+			name, value, fw, fr := c.Name, c.Value, w, r
+			err = runWithTimeout(timeoutCtx, c.Name, "request", timeout, func() error {
+				return runRequestFilter(name, plugin, value, fw, fr)
+			})
+			cancel()
+
+			if _, ok := err.(ErrFilterTimeout); ok {
+				metrics.ObserveFilter(c.Name, "request", start, !policy.FailOpen)
+				span.SetAttributes(tracing.ShortCircuitKey.Bool(!policy.FailOpen))
+				tracing.EndWithError(span, err)
+				if !policy.FailOpen {
+					return stopRequest(r.ID(), policy.status(http.StatusGatewayTimeout)), nil
+				}
+				// leave w and r to the plugin
+				w, r = snapW, snapR
+				continue
+			}
+			if policy.FailOpen {
+				inHTTP.ReuseReqResponse(snapW)
+				inHTTP.ReuseRequest(snapR)
+			}
+		} else {
+			err = runRequestFilter(c.Name, plugin, c.Value, w, r)
+		}
+		if err != nil {
+			plugin.PanicPolicy.applyToRequest(w)
+		}
+		metrics.ObserveFilter(c.Name, "request", start, w.HasChange())
+
+		span.SetAttributes(tracing.ShortCircuitKey.Bool(w.HasChange()))
+		tracing.EndWithError(span, err)
 
 		if w.HasChange() {
 			// response is generated, no need to continue
 			break
 		}
 	}
-	return nil
+
+	r.SetContext(ctx)
+	if r == origR {
+		return nil, nil
+	}
+
+	// the original request is left to the timed out plugin
+	builder := ph.builder(r.ID(), w, r)
+	inHTTP.ReuseReqResponse(w)
+	inHTTP.ReuseRequest(r)
+	return builder, nil
 }
 
 func (ph *requestPhase) builder(id uint32, resp *inHTTP.ReqResponse, req *inHTTP.Request) *flatbuffers.Builder {
@@ -147,34 +370,97 @@ func (ph *requestPhase) builder(id uint32, resp *inHTTP.ReqResponse, req *inHTTP
 	return builder
 }
 
-func HTTPReqCall(buf []byte, conn net.Conn) (*flatbuffers.Builder, error) {
-	req := inHTTP.CreateRequest(buf)
+// guardTimeout prepares the RPC for the plugins with timeouts, see timeout.go.
+// It returns the buf and conn to create the request with, and the func to call when the RPC is done.
+func guardTimeout(ctx context.Context, conf RuleConf, buf []byte, conn net.Conn) (
+	context.Context, []byte, net.Conn, context.CancelFunc) {
+
+	if !conf.hasTimeout() {
+		return ctx, buf, conn, func() {}
+	}
+	// the request's context is released even if the request is not reused
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, append([]byte(nil), buf...), guardConn(conn), cancel
+}
+
+func HTTPReqCall(ctx context.Context, buf []byte, conn net.Conn) (builder *flatbuffers.Builder, err error) {
+	if err := checkHTTPReqCall(buf); err != nil {
+		return nil, err
+	}
+
+	conf, confErr := GetRuleConf(hreqc.GetRootAsReq(buf, 0).ConfToken())
+	ctx, buf, conn, done := guardTimeout(ctx, conf, buf, conn)
+	defer done()
+
+	// the request is not reused if it is left to the timed out plugin
+	abandoned := false
+	req := inHTTP.CreateRequestWithContext(ctx, buf)
 	req.BindConn(conn)
-	defer inHTTP.ReuseRequest(req)
+	defer func() {
+		if !abandoned {
+			inHTTP.ReuseRequest(req)
+		}
+	}()
+
+	// continue the trace from the `traceparent` header sent by the client
+	ctx = tracing.Extract(req.Context(), req.TraceCarrier())
+	ctx, span := startRPCSpan(ctx, "HTTPReqCall", req.ID(), req.ConfToken())
+	req.SetContext(ctx)
+	defer func() {
+		tracing.EndWithError(span, err)
+	}()
 
 	resp := inHTTP.CreateReqResponse()
-	defer inHTTP.ReuseReqResponse(resp)
+	defer func() {
+		if !abandoned {
+			inHTTP.ReuseReqResponse(resp)
+		}
+	}()
 
-	token := req.ConfToken()
-	conf, err := GetRuleConf(token)
-	if err != nil {
-		return nil, err
+	if confErr != nil {
+		return nil, confErr
 	}
 
-	err = RequestPhase.filter(conf, resp, req)
+	builder, err = RequestPhase.filter(conf, resp, req)
 	if err != nil {
 		return nil, err
 	}
+	if builder != nil {
+		detach(conn)
+		abandoned = true
+		return builder, nil
+	}
 
 	id := req.ID()
-	builder := RequestPhase.builder(id, resp, req)
+	builder = RequestPhase.builder(id, resp, req)
 	return builder, nil
 }
 
+// RunRequestPhase runs the plugins of the conf with the req like HTTPReqCall, and returns
+// the response to the RPC. It is used to test the plugins without the RPC.
+func RunRequestPhase(conf RuleConf, req *inHTTP.Request) (*flatbuffers.Builder, error) {
+	resp := inHTTP.CreateReqResponse()
+	builder, err := RequestPhase.filter(conf, resp, req)
+	if builder != nil {
+		// left to the timed out plugin
+		return builder, nil
+	}
+	defer inHTTP.ReuseReqResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return RequestPhase.builder(req.ID(), resp, req), nil
+}
+
 type responsePhase struct {
 }
 
-func (ph *responsePhase) filter(conf RuleConf, w *inHTTP.Response) error {
+// filter runs the plugins of the conf. Like requestPhase.filter, the returned builder is the
+// response to the RPC if a plugin times out.
+func (ph *responsePhase) filter(conf RuleConf, w *inHTTP.Response) (*flatbuffers.Builder, error) {
+	ctx := w.Context()
+	origW := w
+
 	for _, c := range conf {
 		plugin := findPlugin(c.Name)
 		if plugin == nil {
@@ -184,14 +470,67 @@ func (ph *responsePhase) filter(conf RuleConf, w *inHTTP.Response) error {
 
 		log.Infof("run plugin %s", c.Name)
 
-		plugin.ResponseFilter(c.Value, w)
+		pluginCtx, span := startPluginSpan(ctx, c.Name)
+		w.SetContext(pluginCtx)
+
+		start := time.Now()
+		var err error
+		if timeout := filterTimeout(plugin, c.Value); timeout > 0 {
+			policy := plugin.TimeoutPolicy
+			var snapW *inHTTP.Response
+			if policy.FailOpen {
+				// the plugin may still change it after the timeout
+				snapW = w.Snapshot(ctx)
+			}
+
+			timeoutCtx, cancel := context.WithTimeout(pluginCtx, timeout)
+			w.SetContext(timeoutCtx)
+			name, value, fw := c.Name, c.Value, w
+			err = runWithTimeout(timeoutCtx, c.Name, "response", timeout, func() error {
+				return runResponseFilter(name, plugin, value, fw)
+			})
+			cancel()
+
+			if _, ok := err.(ErrFilterTimeout); ok {
+				metrics.ObserveFilter(c.Name, "response", start, !policy.FailOpen)
+				span.SetAttributes(tracing.ShortCircuitKey.Bool(!policy.FailOpen))
+				tracing.EndWithError(span, err)
+				if !policy.FailOpen {
+					return failResponse(w.ID(), policy.status(http.StatusGatewayTimeout)), nil
+				}
+				// leave w to the plugin
+				w = snapW
+				continue
+			}
+			if policy.FailOpen {
+				inHTTP.ReuseResponse(snapW)
+			}
+		} else {
+			err = runResponseFilter(c.Name, plugin, c.Value, w)
+		}
+		if err != nil {
+			plugin.PanicPolicy.applyToResponse(w)
+		}
+		metrics.ObserveFilter(c.Name, "response", start, w.HasChange())
+
+		span.SetAttributes(tracing.ShortCircuitKey.Bool(w.HasChange()))
+		tracing.EndWithError(span, err)
 
 		if w.HasChange() {
 			// response is generated, no need to continue
 			break
 		}
 	}
-	return nil
+
+	w.SetContext(ctx)
+	if w == origW {
+		return nil, nil
+	}
+
+	// the original response is left to the timed out plugin
+	builder := ph.builder(w.ID(), w)
+	inHTTP.ReuseResponse(w)
+	return builder, nil
 }
 
 func (ph *responsePhase) builder(id uint32, resp *inHTTP.Response) *flatbuffers.Builder {
@@ -208,21 +547,44 @@ func (ph *responsePhase) builder(id uint32, resp *inHTTP.Response) *flatbuffers.
 	return builder
 }
 
-func HTTPRespCall(buf []byte, conn net.Conn) (*flatbuffers.Builder, error) {
-	resp := inHTTP.CreateResponse(buf)
+func HTTPRespCall(ctx context.Context, buf []byte, conn net.Conn) (builder *flatbuffers.Builder, err error) {
+	if err := checkHTTPRespCall(buf); err != nil {
+		return nil, err
+	}
+
+	conf, confErr := GetRuleConf(hrespc.GetRootAsReq(buf, 0).ConfToken())
+	ctx, buf, conn, done := guardTimeout(ctx, conf, buf, conn)
+	defer done()
+
+	// the response is not reused if it is left to the timed out plugin
+	abandoned := false
+	resp := inHTTP.CreateResponseWithContext(ctx, buf)
 	resp.BindConn(conn)
-	defer inHTTP.ReuseResponse(resp)
+	defer func() {
+		if !abandoned {
+			inHTTP.ReuseResponse(resp)
+		}
+	}()
 
-	token := resp.ConfToken()
-	conf, err := GetRuleConf(token)
-	if err != nil {
-		return nil, err
+	ctx, span := startRPCSpan(resp.Context(), "HTTPRespCall", resp.ID(), resp.ConfToken())
+	resp.SetContext(ctx)
+	defer func() {
+		tracing.EndWithError(span, err)
+	}()
+
+	if confErr != nil {
+		return nil, confErr
 	}
 
-	err = ResponsePhase.filter(conf, resp)
+	builder, err = ResponsePhase.filter(conf, resp)
 	if err != nil {
 		return nil, err
 	}
+	if builder != nil {
+		detach(conn)
+		abandoned = true
+		return builder, nil
+	}
 
 	id := resp.ID()
 	return ResponsePhase.builder(id, resp), nil
@@ -18,18 +18,27 @@
 package plugin
 
 import (
+	"context"
 	"errors"
+	"net"
 	"net/http"
 	"testing"
 	"time"
 
 	inHTTP "github.com/apache/apisix-go-plugin-runner/internal/http"
+	"github.com/apache/apisix-go-plugin-runner/internal/tracing"
+	"github.com/apache/apisix-go-plugin-runner/pkg/common"
 	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
 
+	A6 "github.com/api7/ext-plugin-proto/go/A6"
 	hreqc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
 	hrespc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
 	flatbuffers "github.com/google/flatbuffers/go"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -58,7 +67,7 @@ func TestHTTPReqCall(t *testing.T) {
 	builder.Finish(r)
 	out := builder.FinishedBytes()
 
-	b, err := HTTPReqCall(out, nil)
+	b, err := HTTPReqCall(context.Background(), out, nil)
 	assert.Nil(t, err)
 
 	out = b.FinishedBytes()
@@ -92,7 +101,7 @@ func TestHTTPReqCall_FailedToParseConf(t *testing.T) {
 	builder.Finish(r)
 	out := builder.FinishedBytes()
 
-	b, err := HTTPReqCall(out, nil)
+	b, err := HTTPReqCall(context.Background(), out, nil)
 	assert.Nil(t, err)
 
 	out = b.FinishedBytes()
@@ -463,7 +472,7 @@ func TestHTTPRespCall(t *testing.T) {
 	builder.Finish(r)
 	out := builder.FinishedBytes()
 
-	b, err := HTTPRespCall(out, nil)
+	b, err := HTTPRespCall(context.Background(), out, nil)
 	assert.Nil(t, err)
 
 	out = b.FinishedBytes()
@@ -498,7 +507,7 @@ func TestHTTPRespCall_FailedToParseConf(t *testing.T) {
 	builder.Finish(r)
 	out := builder.FinishedBytes()
 
-	b, err := HTTPRespCall(out, nil)
+	b, err := HTTPRespCall(context.Background(), out, nil)
 	assert.Nil(t, err)
 
 	out = b.FinishedBytes()
@@ -540,3 +549,449 @@ func TestResponseFilter(t *testing.T) {
 	assert.Equal(t, "bar", resp.Header().Get("bee"))
 	assert.Equal(t, 200, resp.StatusCode())
 }
+
+func TestResponseFilter_StoreNotCarried(t *testing.T) {
+	InitConfCache(10 * time.Millisecond)
+
+	setFilter := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		r.Ctx().Set("user", "foo")
+	}
+	found := true
+	getFilter := func(conf interface{}, w pkgHTTP.Response) {
+		_, found = w.Ctx().Get("user")
+	}
+	RegisterPlugin("store-not-carried", emptyParseConf, setFilter, getFilter)
+	SetRuleConfInTest(1, RuleConf{{Name: "store-not-carried", Value: ""}})
+
+	_, err := HTTPReqCall(context.Background(), buildReqCallInTest(), nil)
+	assert.Nil(t, err)
+	_, err = HTTPRespCall(context.Background(), buildRespCallInTest(), nil)
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func buildReqCallInTest() []byte {
+	builder := flatbuffers.NewBuilder(1024)
+	hreqc.ReqStart(builder)
+	hreqc.ReqAddId(builder, 233)
+	hreqc.ReqAddConfToken(builder, 1)
+	builder.Finish(hreqc.ReqEnd(builder))
+	return builder.FinishedBytes()
+}
+
+func buildRespCallInTest() []byte {
+	builder := flatbuffers.NewBuilder(1024)
+	hrespc.ReqStart(builder)
+	hrespc.ReqAddId(builder, 233)
+	hrespc.ReqAddStatus(builder, 200)
+	hrespc.ReqAddConfToken(builder, 1)
+	builder.Finish(hrespc.ReqEnd(builder))
+	return builder.FinishedBytes()
+}
+
+func TestRequestFilter_Panic(t *testing.T) {
+	panicFilter := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		w.Header().Set("X-Partial", "1")
+		r.Header().Set("X-Req", "panic")
+		panic("ouch")
+	}
+	nextFilter := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		r.Header().Set("X-Next", "1")
+	}
+	RegisterPlugin("panic-default", emptyParseConf, panicFilter, emptyResponseFilter)
+	RegisterPlugin("panic-open", emptyParseConf, panicFilter, emptyResponseFilter,
+		WithPanicPolicy(FailurePolicy{FailOpen: true}))
+	RegisterPlugin("panic-closed", emptyParseConf, panicFilter, emptyResponseFilter,
+		WithPanicPolicy(FailurePolicy{Status: 503}))
+	RegisterPlugin("panic-next", emptyParseConf, nextFilter, emptyResponseFilter)
+
+	out := buildReqCallInTest()
+
+	req := inHTTP.CreateRequest(out)
+	resp := inHTTP.CreateReqResponse()
+	_, err := RequestPhase.filter(RuleConf{{Name: "panic-default"}, {Name: "panic-next"}}, resp, req)
+	assert.Nil(t, err)
+	b := RequestPhase.builder(233, resp, req)
+	stop := hreqc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, hreqc.ActionStop, stop.ActionType())
+	assert.Equal(t, "", resp.Header().Get("X-Partial"))
+	assert.Equal(t, "", req.Header().Get("X-Next"))
+	action := &hreqc.Stop{}
+	tab := &flatbuffers.Table{}
+	stop.Action(tab)
+	action.Init(tab.Bytes, tab.Pos)
+	assert.Equal(t, uint16(500), action.Status())
+
+	req = inHTTP.CreateRequest(out)
+	resp = inHTTP.CreateReqResponse()
+	RequestPhase.filter(RuleConf{{Name: "panic-closed"}, {Name: "panic-next"}}, resp, req)
+	assert.True(t, resp.HasChange())
+	b = RequestPhase.builder(233, resp, req)
+	stop = hreqc.GetRootAsResp(b.FinishedBytes(), 0)
+	stop.Action(tab)
+	action.Init(tab.Bytes, tab.Pos)
+	assert.Equal(t, uint16(503), action.Status())
+
+	req = inHTTP.CreateRequest(out)
+	resp = inHTTP.CreateReqResponse()
+	RequestPhase.filter(RuleConf{{Name: "panic-open"}, {Name: "panic-next"}}, resp, req)
+	assert.False(t, resp.HasChange())
+	assert.Equal(t, "panic", req.Header().Get("X-Req"))
+	assert.Equal(t, "1", req.Header().Get("X-Next"))
+}
+
+func TestResponseFilter_Panic(t *testing.T) {
+	panicFilter := func(conf interface{}, w pkgHTTP.Response) {
+		w.Header().Set("X-Partial", "1")
+		w.Write([]byte("partial"))
+		panic("ouch")
+	}
+	nextFilter := func(conf interface{}, w pkgHTTP.Response) {
+		w.Header().Set("X-Next", "1")
+	}
+	RegisterPlugin("resp-panic-default", emptyParseConf, emptyRequestFilter, panicFilter)
+	RegisterPlugin("resp-panic-open", emptyParseConf, emptyRequestFilter, panicFilter,
+		WithPanicPolicy(FailurePolicy{FailOpen: true}))
+	RegisterPlugin("resp-panic-next", emptyParseConf, emptyRequestFilter, nextFilter)
+
+	out := buildRespCallInTest()
+
+	resp := inHTTP.CreateResponse(out)
+	_, err := ResponsePhase.filter(RuleConf{{Name: "resp-panic-default"}, {Name: "resp-panic-next"}}, resp)
+	assert.Nil(t, err)
+	b := ResponsePhase.builder(233, resp)
+	res := hrespc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, uint16(500), res.Status())
+	assert.Equal(t, "Internal Server Error", string(res.BodyBytes()))
+	assert.Equal(t, 0, res.HeadersLength())
+
+	resp = inHTTP.CreateResponse(out)
+	ResponsePhase.filter(RuleConf{{Name: "resp-panic-open"}, {Name: "resp-panic-next"}}, resp)
+	assert.Equal(t, "", resp.Header().Get("X-Partial"))
+	assert.Equal(t, "1", resp.Header().Get("X-Next"))
+	b = ResponsePhase.builder(233, resp)
+	res = hrespc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, uint16(0), res.Status())
+	assert.Equal(t, 0, len(res.BodyBytes()))
+}
+
+type timeoutConf time.Duration
+
+func (c timeoutConf) FilterTimeout() time.Duration {
+	return time.Duration(c)
+}
+
+func TestHTTPReqCall_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	blockFilter := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		// ignore the ctx
+		<-release
+		w.WriteHeader(200)
+	}
+	headerFilter := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		r.Header().Set("X-Before", "1")
+	}
+	blockHeaderFilter := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		r.Header().Set("X-Blocked", "1")
+		<-release
+	}
+	afterFilter := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		r.Header().Set("X-After", r.Header().Get("X-Before")+r.Header().Get("X-Blocked"))
+	}
+	authFilter := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		w.WriteHeader(403)
+	}
+	RegisterPlugin("timeout-block", emptyParseConf, blockFilter, emptyResponseFilter,
+		WithTimeout(10*time.Millisecond, FailurePolicy{}))
+	RegisterPlugin("timeout-block-open", emptyParseConf, blockFilter, emptyResponseFilter,
+		WithTimeout(10*time.Millisecond, FailurePolicy{FailOpen: true}))
+	RegisterPlugin("timeout-block-header-open", emptyParseConf, blockHeaderFilter, emptyResponseFilter,
+		WithTimeout(10*time.Millisecond, FailurePolicy{FailOpen: true}))
+	RegisterPlugin("timeout-header", emptyParseConf, headerFilter, emptyResponseFilter)
+	RegisterPlugin("timeout-after", emptyParseConf, afterFilter, emptyResponseFilter)
+	RegisterPlugin("timeout-auth", emptyParseConf, authFilter, emptyResponseFilter)
+	RegisterPlugin("timeout-by-conf", emptyParseConf, blockFilter, emptyResponseFilter)
+
+	InitConfCache(time.Minute)
+	out := buildReqCallInTest()
+
+	SetRuleConfInTest(1, RuleConf{{Name: "timeout-header"}, {Name: "timeout-block"}})
+	b, err := HTTPReqCall(context.Background(), out, nil)
+	assert.Nil(t, err)
+	resp := hreqc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, hreqc.ActionStop, resp.ActionType())
+	tab := &flatbuffers.Table{}
+	resp.Action(tab)
+	stop := &hreqc.Stop{}
+	stop.Init(tab.Bytes, tab.Pos)
+	assert.Equal(t, uint16(504), stop.Status())
+
+	SetRuleConfInTest(1, RuleConf{{Name: "timeout-header"}, {Name: "timeout-block-open"}})
+	b, err = HTTPReqCall(context.Background(), out, nil)
+	assert.Nil(t, err)
+	resp = hreqc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, hreqc.ActionRewrite, resp.ActionType())
+	resp.Action(tab)
+	rewrite := &hreqc.Rewrite{}
+	rewrite.Init(tab.Bytes, tab.Pos)
+	te := &A6.TextEntry{}
+	assert.True(t, rewrite.Headers(te, 0))
+	assert.Equal(t, "X-Before", string(te.Name()))
+
+	// the chain goes on without the changes of the timed out plugin
+	SetRuleConfInTest(1, RuleConf{{Name: "timeout-header"}, {Name: "timeout-block-header-open"},
+		{Name: "timeout-block-open"}, {Name: "timeout-after"}})
+	b, err = HTTPReqCall(context.Background(), out, nil)
+	assert.Nil(t, err)
+	resp = hreqc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, hreqc.ActionRewrite, resp.ActionType())
+	resp.Action(tab)
+	rewrite.Init(tab.Bytes, tab.Pos)
+	hdrs := map[string]string{}
+	for i := 0; i < rewrite.HeadersLength(); i++ {
+		assert.True(t, rewrite.Headers(te, i))
+		hdrs[string(te.Name())] = string(te.Value())
+	}
+	assert.Equal(t, map[string]string{"X-Before": "1", "X-After": "1"}, hdrs)
+
+	SetRuleConfInTest(1, RuleConf{{Name: "timeout-block-open"}, {Name: "timeout-auth"}})
+	b, err = HTTPReqCall(context.Background(), out, nil)
+	assert.Nil(t, err)
+	resp = hreqc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, hreqc.ActionStop, resp.ActionType())
+	resp.Action(tab)
+	stop.Init(tab.Bytes, tab.Pos)
+	assert.Equal(t, uint16(403), stop.Status())
+
+	SetRuleConfInTest(1, RuleConf{{Name: "timeout-by-conf", Value: timeoutConf(10 * time.Millisecond)}})
+	b, err = HTTPReqCall(context.Background(), out, nil)
+	assert.Nil(t, err)
+	resp = hreqc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, hreqc.ActionStop, resp.ActionType())
+}
+
+func TestHTTPReqCall_TimeoutNotExceeded(t *testing.T) {
+	var deadline time.Time
+	ctxFilter := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		deadline, _ = r.Context().Deadline()
+		w.WriteHeader(403)
+	}
+	RegisterPlugin("timeout-ctx", emptyParseConf, ctxFilter, emptyResponseFilter,
+		WithTimeout(time.Second, FailurePolicy{}))
+
+	InitConfCache(time.Minute)
+	SetRuleConfInTest(1, RuleConf{{Name: "timeout-ctx"}})
+	b, err := HTTPReqCall(context.Background(), buildReqCallInTest(), nil)
+	assert.Nil(t, err)
+	resp := hreqc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, hreqc.ActionStop, resp.ActionType())
+	assert.True(t, time.Until(deadline) < time.Second)
+}
+
+func TestHTTPRespCall_Timeout(t *testing.T) {
+	deadlines := make(chan time.Time, 2)
+	blockFilter := func(conf interface{}, w pkgHTTP.Response) {
+		deadline, _ := w.Context().Deadline()
+		deadlines <- deadline
+		<-w.Context().Done()
+		time.Sleep(50 * time.Millisecond)
+	}
+	RegisterPlugin("resp-timeout-block", emptyParseConf, emptyRequestFilter, blockFilter,
+		WithTimeout(10*time.Millisecond, FailurePolicy{}))
+	RegisterPlugin("resp-timeout-block-open", emptyParseConf, emptyRequestFilter, blockFilter,
+		WithTimeout(10*time.Millisecond, FailurePolicy{FailOpen: true}))
+	RegisterPlugin("resp-timeout-next", emptyParseConf, emptyRequestFilter, func(conf interface{}, w pkgHTTP.Response) {
+		w.WriteHeader(403)
+	})
+
+	InitConfCache(time.Minute)
+	out := buildRespCallInTest()
+
+	SetRuleConfInTest(1, RuleConf{{Name: "resp-timeout-block"}})
+	b, err := HTTPRespCall(context.Background(), out, nil)
+	assert.Nil(t, err)
+	assert.False(t, (<-deadlines).IsZero())
+	resp := hrespc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, uint16(504), resp.Status())
+	assert.Equal(t, "Gateway Timeout", string(resp.BodyBytes()))
+
+	SetRuleConfInTest(1, RuleConf{{Name: "resp-timeout-block-open"}})
+	b, err = HTTPRespCall(context.Background(), out, nil)
+	assert.Nil(t, err)
+	resp = hrespc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, uint16(0), resp.Status())
+	assert.Equal(t, 0, len(resp.BodyBytes()))
+	<-deadlines
+
+	SetRuleConfInTest(1, RuleConf{{Name: "resp-timeout-block-open"}, {Name: "resp-timeout-next"}})
+	b, err = HTTPRespCall(context.Background(), out, nil)
+	assert.Nil(t, err)
+	resp = hrespc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, uint16(403), resp.Status())
+}
+
+func TestGuardedConn(t *testing.T) {
+	assert.Nil(t, guardConn(nil))
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	c := guardConn(sc)
+	detach(c)
+	_, err := c.(inHTTP.ExtraInfoConn).RoundTripExtraInfo([]byte("x"), func(uint32) error { return nil })
+	assert.Equal(t, common.ErrConnClosed, err)
+}
+
+func TestInitAndClosePlugins(t *testing.T) {
+	var seq []string
+	RegisterPlugin("lifecycle-b", emptyParseConf, emptyRequestFilter, emptyResponseFilter,
+		WithInit(func() error {
+			seq = append(seq, "init b")
+			return nil
+		}),
+		WithClose(func() error {
+			seq = append(seq, "close b")
+			return errors.New("ouch")
+		}),
+	)
+	RegisterPlugin("lifecycle-a", emptyParseConf, emptyRequestFilter, emptyResponseFilter,
+		WithInit(func() error {
+			seq = append(seq, "init a")
+			return nil
+		}),
+		WithClose(func() error {
+			seq = append(seq, "close a")
+			return nil
+		}),
+	)
+
+	assert.Nil(t, InitPlugins())
+	ClosePlugins()
+	assert.Equal(t, []string{"init a", "init b", "close a", "close b"}, seq)
+}
+
+func TestInitPluginsFailed(t *testing.T) {
+	RegisterPlugin("lifecycle-failed", emptyParseConf, emptyRequestFilter, emptyResponseFilter,
+		WithInit(func() error {
+			return errors.New("ouch")
+		}),
+	)
+	defer func() {
+		pluginRegistry.Lock()
+		delete(pluginRegistry.opts, "lifecycle-failed")
+		pluginRegistry.Unlock()
+	}()
+
+	err := InitPlugins()
+	assert.Equal(t, "failed to init plugin lifecycle-failed: ouch", err.Error())
+}
+
+func TestInitPluginSettings(t *testing.T) {
+	var seq []string
+	RegisterPlugin("settings-a", emptyParseConf, emptyRequestFilter, emptyResponseFilter,
+		WithInitSettings(func(settings []byte) error {
+			seq = append(seq, "settings a "+string(settings))
+			return nil
+		}),
+		WithInit(func() error {
+			seq = append(seq, "init a")
+			return nil
+		}),
+	)
+	RegisterPlugin("settings-b", emptyParseConf, emptyRequestFilter, emptyResponseFilter,
+		WithInitSettings(func(settings []byte) error {
+			seq = append(seq, "settings b "+string(settings))
+			return errors.New("ouch")
+		}),
+	)
+	defer func() {
+		pluginRegistry.Lock()
+		delete(pluginRegistry.opts, "settings-a")
+		delete(pluginRegistry.opts, "settings-b")
+		pluginRegistry.Unlock()
+		SetPluginSettings(nil)
+	}()
+
+	SetPluginSettings(map[string][]byte{
+		"settings-a": []byte(`{"pool":10}`),
+		"not-found":  []byte(`{}`),
+	})
+	err := InitPlugins()
+	assert.Equal(t, "failed to init settings of plugin settings-b: ouch", err.Error())
+	assert.Equal(t, []string{`settings a {"pool":10}`, "init a", "settings b "}, seq)
+}
+
+func TestHTTPReqCall_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracing.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer tracing.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	var pluginSpanID trace.SpanID
+	traceFilter := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		pluginSpanID = trace.SpanContextFromContext(r.Context()).SpanID()
+		w.WriteHeader(403)
+	}
+	RegisterPlugin("trace-a", emptyParseConf, traceFilter, emptyResponseFilter)
+	RegisterPlugin("trace-b", emptyParseConf, emptyRequestFilter, emptyResponseFilter)
+
+	InitConfCache(10 * time.Millisecond)
+	SetRuleConfInTest(1, RuleConf{{Name: "trace-a"}, {Name: "trace-b"}})
+
+	builder := flatbuffers.NewBuilder(1024)
+	name := builder.CreateString("Traceparent")
+	value := builder.CreateString("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	A6.TextEntryStart(builder)
+	A6.TextEntryAddName(builder, name)
+	A6.TextEntryAddValue(builder, value)
+	te := A6.TextEntryEnd(builder)
+	hreqc.ReqStartHeadersVector(builder, 1)
+	builder.PrependUOffsetT(te)
+	hdrs := builder.EndVector(1)
+	hreqc.ReqStart(builder)
+	hreqc.ReqAddId(builder, 233)
+	hreqc.ReqAddConfToken(builder, 1)
+	hreqc.ReqAddHeaders(builder, hdrs)
+	r := hreqc.ReqEnd(builder)
+	builder.Finish(r)
+	out := builder.FinishedBytes()
+
+	_, err := HTTPReqCall(context.Background(), out, nil)
+	assert.Nil(t, err)
+
+	// trace-b is skipped as trace-a generates the response
+	spans := exporter.GetSpans()
+	assert.Equal(t, 2, len(spans))
+	pluginSpan, rpcSpan := spans[0], spans[1]
+	assert.Equal(t, "HTTPReqCall", rpcSpan.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", rpcSpan.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", rpcSpan.Parent.SpanID().String())
+
+	assert.Equal(t, "trace-a", pluginSpan.Name)
+	assert.Equal(t, rpcSpan.SpanContext.SpanID(), pluginSpan.Parent.SpanID())
+	assert.Equal(t, pluginSpanID, pluginSpan.SpanContext.SpanID())
+	assert.Contains(t, pluginSpan.Attributes, tracing.ShortCircuitKey.Bool(true))
+}
+
+func TestHTTPRespCall_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracing.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer tracing.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	InitConfCache(10 * time.Millisecond)
+
+	builder := flatbuffers.NewBuilder(1024)
+	hrespc.ReqStart(builder)
+	hrespc.ReqAddId(builder, 233)
+	hrespc.ReqAddConfToken(builder, 1)
+	r := hrespc.ReqEnd(builder)
+	builder.Finish(r)
+	out := builder.FinishedBytes()
+
+	_, err := HTTPRespCall(context.Background(), out, nil)
+	assert.NotNil(t, err)
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 1, len(spans))
+	assert.Equal(t, "HTTPRespCall", spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+}
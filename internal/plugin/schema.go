@@ -0,0 +1,117 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaViolation is a place where the conf doesn't match the schema.
+// The Path is a JSON pointer to the value, like `/filters/0/regex`.
+type SchemaViolation struct {
+	Path string
+	Msg  string
+}
+
+// ErrSchemaViolation is returned when the raw conf doesn't match the plugin's JSON Schema
+type ErrSchemaViolation struct {
+	Violations []SchemaViolation
+}
+
+func (err ErrSchemaViolation) Error() string {
+	msgs := make([]string, 0, len(err.Violations))
+	for _, v := range err.Violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", v.Path, v.Msg))
+	}
+	return "conf doesn't match the schema: " + strings.Join(msgs, "; ")
+}
+
+func compileSchema(name string, schema []byte) (*jsonschema.Schema, error) {
+	url := name + ".schema.json"
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(url, bytes.NewReader(schema)); err != nil {
+		return nil, err
+	}
+	return c.Compile(url)
+}
+
+func validateConfSchema(s *jsonschema.Schema, in []byte) error {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(in))
+	// keep the precision of the numbers
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return ErrSchemaViolation{Violations: []SchemaViolation{{Path: "/", Msg: err.Error()}}}
+	}
+
+	err := s.Validate(v)
+	if err == nil {
+		return nil
+	}
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+
+	res := ErrSchemaViolation{}
+	var collect func(ve *jsonschema.ValidationError)
+	collect = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			path := ve.InstanceLocation
+			if path == "" {
+				path = "/"
+			}
+			res.Violations = append(res.Violations, SchemaViolation{Path: path, Msg: ve.Message})
+			return
+		}
+		for _, c := range ve.Causes {
+			collect(c)
+		}
+	}
+	collect(ve)
+	return res
+}
+
+// parseConf checks the conf with the schema if any, and then parses it
+func (opt *pluginOpts) parseConf(in []byte) (interface{}, error) {
+	if opt.schema != nil {
+		if err := validateConfSchema(opt.schema, in); err != nil {
+			return nil, err
+		}
+	}
+	return opt.ParseConf(in)
+}
+
+// PluginSchemas returns the JSON Schema of the registered plugins which declare it
+func PluginSchemas() map[string][]byte {
+	pluginRegistry.RLock()
+	defer pluginRegistry.RUnlock()
+
+	res := map[string][]byte{}
+	for name, opt := range pluginRegistry.opts {
+		if opt.Schema != nil {
+			res[name] = opt.Schema
+		}
+	}
+	return res
+}
@@ -0,0 +1,97 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSchema = []byte(`{
+	"type": "object",
+	"properties": {
+		"status": {"type": "integer", "minimum": 200},
+		"filters": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {"regex": {"type": "string"}},
+				"required": ["regex"]
+			}
+		}
+	},
+	"required": ["status"]
+}`)
+
+func TestRegisterPluginWithBadSchema(t *testing.T) {
+	err := RegisterPlugin("bad-schema", emptyParseConf, emptyRequestFilter, emptyResponseFilter,
+		WithSchema([]byte(`{"type": 1}`)))
+	assert.NotNil(t, err)
+	assert.Nil(t, findPlugin("bad-schema"))
+}
+
+func TestPrepareConfWithSchema(t *testing.T) {
+	parsed := 0
+	pc := func(in []byte) (interface{}, error) {
+		parsed++
+		return string(in), nil
+	}
+	err := RegisterPlugin("schema", pc, emptyRequestFilter, emptyResponseFilter, WithSchema(testSchema))
+	assert.Nil(t, err)
+	assert.Equal(t, testSchema, PluginSchemas()["schema"])
+
+	SetStrictConf(true, nil)
+	defer SetStrictConf(false, nil)
+	InitConfCache(10 * time.Millisecond)
+
+	cases := []struct {
+		conf       string
+		violations []SchemaViolation
+	}{
+		{`{"status": 200, "filters": [{"regex": "a"}]}`, nil},
+		{`{"status": 100}`, []SchemaViolation{{Path: "/status", Msg: "must be >= 200 but found 100"}}},
+		{`{"status": 200, "filters": [{"regex": "a"}, {}]}`,
+			[]SchemaViolation{{Path: "/filters/1", Msg: "missing properties: 'regex'"}}},
+		{`{"status": 200, "filters": [{"regex": 1}]}`,
+			[]SchemaViolation{{Path: "/filters/0/regex", Msg: "expected string, but got number"}}},
+		{`{`, []SchemaViolation{{Path: "/", Msg: "unexpected EOF"}}},
+	}
+
+	for _, c := range cases {
+		parsed = 0
+		builder := flatbuffers.NewBuilder(1024)
+		name := builder.CreateString("schema")
+		value := builder.CreateString(c.conf)
+		_, err := prepareConfWithData(builder, name, value)
+		if c.violations == nil {
+			assert.Nil(t, err)
+			assert.Equal(t, 1, parsed)
+			continue
+		}
+
+		var sv ErrSchemaViolation
+		assert.True(t, errors.As(err, &sv), c.conf)
+		assert.Equal(t, c.violations, sv.Violations)
+		assert.Equal(t, "schema", err.(ErrInvalidConf).Plugin)
+		assert.Equal(t, 0, parsed)
+	}
+}
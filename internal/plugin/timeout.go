@@ -0,0 +1,167 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	hrespc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
+	flatbuffers "github.com/google/flatbuffers/go"
+
+	inHTTP "github.com/apache/apisix-go-plugin-runner/internal/http"
+	"github.com/apache/apisix-go-plugin-runner/internal/metrics"
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	"github.com/apache/apisix-go-plugin-runner/pkg/common"
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+)
+
+// A plugin which ignores the ctx can't be stopped, so when it times out, the request is left to
+// it and the RPC is answered without touching the request anymore:
+//  * the RPC's data is copied, as the frame's buffer is reused once the RPC is answered
+//  * the conn is guarded, so that the plugin can't send the extra info request after that
+//  * the request is not reused
+// With the fail-open policy, the request is snapshotted before running the plugin, and the
+// following plugins run with the snapshot, so the changes of the timed out plugin are dropped.
+
+// WithTimeout sets the max execution time of the filters, and the policy applied when it is
+// exceeded. By default, the request is stopped with 504.
+func WithTimeout(timeout time.Duration, p FailurePolicy) Option {
+	return func(opt *pluginOpts) {
+		opt.Timeout = timeout
+		opt.TimeoutPolicy = p
+	}
+}
+
+// confTimeout is implemented by the conf which overrides the timeout of the plugin
+type confTimeout interface {
+	FilterTimeout() time.Duration
+}
+
+// filterTimeout returns the max execution time of the plugin's filter with the conf
+func filterTimeout(plugin *pluginOpts, conf interface{}) time.Duration {
+	if ct, ok := conf.(confTimeout); ok {
+		if d := ct.FilterTimeout(); d > 0 {
+			return d
+		}
+	}
+	return plugin.Timeout
+}
+
+// hasTimeout reports whether any plugin of the conf runs with a timeout
+func (conf RuleConf) hasTimeout() bool {
+	for _, c := range conf {
+		plugin := findPlugin(c.Name)
+		if plugin != nil && filterTimeout(plugin, c.Value) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrFilterTimeout is recorded in the plugin's span when its filter times out
+type ErrFilterTimeout struct {
+	Plugin  string
+	Phase   string
+	Timeout time.Duration
+}
+
+func (err ErrFilterTimeout) Error() string {
+	return fmt.Sprintf("%s filter of plugin %s: timeout after %v", err.Phase, err.Plugin, err.Timeout)
+}
+
+// runWithTimeout runs the filter in a goroutine, and returns ErrFilterTimeout if it doesn't
+// return before the ctx is done. The ctx is the one passed to the filter.
+func runWithTimeout(ctx context.Context, name, phase string, timeout time.Duration, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		err := ErrFilterTimeout{Plugin: name, Phase: phase, Timeout: timeout}
+		log.Errorf("%s", err)
+		metrics.FilterTimedOut(name, phase)
+		return err
+	}
+}
+
+// guardedConn is bound to the request whose plugins run with timeouts
+type guardedConn struct {
+	net.Conn
+
+	lock     sync.Mutex
+	header   []byte
+	detached bool
+}
+
+func guardConn(c net.Conn) net.Conn {
+	if c == nil {
+		return nil
+	}
+	return &guardedConn{Conn: c, header: make([]byte, util.HeaderLen)}
+}
+
+// RoundTripExtraInfo implements http.ExtraInfoConn
+func (gc *guardedConn) RoundTripExtraInfo(out []byte, check func(length uint32) error) ([]byte, error) {
+	gc.lock.Lock()
+	defer gc.lock.Unlock()
+	if gc.detached {
+		return nil, common.ErrConnClosed
+	}
+	return inHTTP.RoundTripExtraInfo(gc.Conn, gc.header, out, check)
+}
+
+// detach waits for the extra info request in flight, and fails the following ones
+func detach(c net.Conn) {
+	if gc, ok := c.(*guardedConn); ok {
+		gc.lock.Lock()
+		gc.detached = true
+		gc.lock.Unlock()
+	}
+}
+
+// stopRequest builds the stop action with the status
+func stopRequest(id uint32, status int) *flatbuffers.Builder {
+	w := inHTTP.CreateReqResponse()
+	defer inHTTP.ReuseReqResponse(w)
+	w.WriteHeader(status)
+
+	builder := util.GetBuilder()
+	w.FetchChanges(id, builder)
+	return builder
+}
+
+// failResponse builds the response replaced with the status, like inHTTP.Response.Fail
+func failResponse(id uint32, status int) *flatbuffers.Builder {
+	builder := util.GetBuilder()
+	body := builder.CreateByteVector([]byte(http.StatusText(status)))
+	hrespc.RespStart(builder)
+	hrespc.RespAddId(builder, id)
+	hrespc.RespAddStatus(builder, uint16(status))
+	hrespc.RespAddBody(builder, body)
+	builder.Finish(hrespc.RespEnd(builder))
+	return builder
+}
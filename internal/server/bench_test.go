@@ -0,0 +1,132 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
+	hrc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+)
+
+func init() {
+	plugin.RegisterPlugin("bench-var",
+		func(in []byte) (interface{}, error) {
+			return nil, nil
+		},
+		func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+			r.Var("remote_addr")
+		},
+		func(conf interface{}, w pkgHTTP.Response) {},
+	)
+}
+
+// benchClient plays APISIX in the benchmarks. It reuses its buffers, so that the
+// allocations reported are the runner's.
+type benchClient struct {
+	conn   net.Conn
+	header []byte
+	buf    []byte
+}
+
+func (c *benchClient) write(b *testing.B, ty byte, out []byte) {
+	binary.BigEndian.PutUint32(c.header, uint32(len(out)))
+	c.header[0] = ty
+	if _, err := util.WriteBytes(c.conn, c.header, util.HeaderLen); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := util.WriteBytes(c.conn, out, len(out)); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func (c *benchClient) read(b *testing.B) byte {
+	if _, err := util.ReadBytes(c.conn, c.header, util.HeaderLen); err != nil {
+		b.Fatal(err)
+	}
+	ty := c.header[0]
+	c.header[0] = 0
+	length := int(binary.BigEndian.Uint32(c.header))
+	if cap(c.buf) < length {
+		c.buf = make([]byte, length)
+	}
+	if _, err := util.ReadBytes(c.conn, c.buf[:length], length); err != nil {
+		b.Fatal(err)
+	}
+	return ty
+}
+
+func benchmarkHTTPReqCall(b *testing.B, conf plugin.RuleConf) {
+	// like the runner started by the command, the info logs are dropped
+	log.NewLogger(zapcore.WarnLevel, os.Stdout)
+	plugin.InitConfCache(time.Minute)
+	if err := plugin.SetRuleConfInTest(1, conf); err != nil {
+		b.Fatal(err)
+	}
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	go handleConn(context.Background(), sc, nil)
+
+	bd := flatbuffers.NewBuilder(1024)
+	path := bd.CreateString("/hello")
+	hrc.ReqStart(bd)
+	hrc.ReqAddId(bd, 233)
+	hrc.ReqAddConfToken(bd, 1)
+	hrc.ReqAddPath(bd, path)
+	bd.Finish(hrc.ReqEnd(bd))
+	req := bd.FinishedBytes()
+
+	eiBd := flatbuffers.NewBuilder(1024)
+	v := eiBd.CreateByteVector([]byte("127.0.0.1"))
+	ei.RespStart(eiBd)
+	ei.RespAddResult(eiBd, v)
+	eiBd.Finish(ei.RespEnd(eiBd))
+	eiResp := eiBd.FinishedBytes()
+
+	c := &benchClient{conn: cc, header: make([]byte, util.HeaderLen)}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.write(b, util.RPCHTTPReqCall, req)
+		for c.read(b) == util.RPCExtraInfo {
+			c.write(b, util.RPCExtraInfo, eiResp)
+		}
+	}
+}
+
+func BenchmarkHTTPReqCall(b *testing.B) {
+	benchmarkHTTPReqCall(b, plugin.RuleConf{})
+}
+
+func BenchmarkHTTPReqCall_ExtraInfo(b *testing.B) {
+	benchmarkHTTPReqCall(b, plugin.RuleConf{{Name: "bench-var"}})
+}
@@ -0,0 +1,369 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/metrics"
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	"github.com/apache/apisix-go-plugin-runner/pkg/common"
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+)
+
+// pipelinedConn is the conn whose HTTPReqCall/HTTPRespCall are handled concurrently.
+// The frames are read by a single goroutine, and the writes are serialized. As the extra
+// info response doesn't carry an id, it is correlated with the request in FIFO order.
+type pipelinedConn struct {
+	net.Conn
+
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	waiters []*extraInfoWaiter
+	closed  bool
+}
+
+type extraInfoWaiter struct {
+	check func(length uint32) error
+	res   chan extraInfoResult
+}
+
+type extraInfoResult struct {
+	buf []byte
+	err error
+}
+
+// writeFrameLocked should be called with the writeLock held
+func (pc *pipelinedConn) writeFrameLocked(ty byte, out []byte) error {
+	return util.WriteFrame(pc.Conn, ty, out)
+}
+
+// reportError answers the refused RPC with the error. It returns false if the conn can't
+// be used anymore.
+func (pc *pipelinedConn) reportError(err error) bool {
+	bd := ReportError(err)
+	defer util.PutBuilder(bd)
+	return pc.writeFrame(util.RPCError, bd.FinishedBytes()) == nil
+}
+
+func (pc *pipelinedConn) writeFrame(ty byte, out []byte) error {
+	pc.writeLock.Lock()
+	defer pc.writeLock.Unlock()
+	return pc.writeFrameLocked(ty, out)
+}
+
+// RoundTripExtraInfo implements http.ExtraInfoConn
+func (pc *pipelinedConn) RoundTripExtraInfo(out []byte, check func(length uint32) error) ([]byte, error) {
+	w := &extraInfoWaiter{
+		check: check,
+		res:   make(chan extraInfoResult, 1),
+	}
+
+	// enqueue the waiter with the write lock held, so that the waiters are in the same
+	// order as the requests sent
+	pc.writeLock.Lock()
+	pc.lock.Lock()
+	if pc.closed {
+		pc.lock.Unlock()
+		pc.writeLock.Unlock()
+		return nil, common.ErrConnClosed
+	}
+	pc.waiters = append(pc.waiters, w)
+	pc.lock.Unlock()
+	err := pc.writeFrameLocked(util.RPCExtraInfo, out)
+	pc.writeLock.Unlock()
+
+	if err != nil {
+		// the conn is broken, closing it makes the reader fail all waiters
+		pc.Conn.Close()
+	}
+	return pc.wait(w)
+}
+
+// wait waits for the extra info response up to the read timeout
+func (pc *pipelinedConn) wait(w *extraInfoWaiter) ([]byte, error) {
+	timeout := util.ReadTimeout()
+	if timeout <= 0 {
+		res := <-w.res
+		return res.buf, res.err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-w.res:
+		return res.buf, res.err
+	case <-timer.C:
+		// the late response would be delivered to the next waiter, so the conn can't be
+		// used anymore
+		log.Errorf("wait for extra info response: timeout after %v", timeout)
+		pc.Conn.Close()
+		return nil, &util.TimeoutError{Op: "read", Limit: timeout}
+	}
+}
+
+func (pc *pipelinedConn) popWaiter() *extraInfoWaiter {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	if len(pc.waiters) == 0 {
+		return nil
+	}
+	w := pc.waiters[0]
+	pc.waiters[0] = nil
+	pc.waiters = pc.waiters[1:]
+	return w
+}
+
+// failWaiters is called when the conn can't be read anymore
+func (pc *pipelinedConn) failWaiters() {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	pc.closed = true
+	for _, w := range pc.waiters {
+		w.res <- extraInfoResult{err: common.ErrConnClosed}
+	}
+	pc.waiters = nil
+}
+
+// deliverExtraInfo reads the extra info response and passes it to the first waiter.
+// It returns false if the conn can't be read anymore.
+func (pc *pipelinedConn) deliverExtraInfo(length int) bool {
+	w := pc.popWaiter()
+	if w == nil {
+		log.Errorf("drop unexpected extra info response")
+		return util.DiscardFrameData(pc.Conn, length) == nil
+	}
+
+	if err := w.check(uint32(length)); err != nil {
+		if e := util.DiscardFrameData(pc.Conn, length); e != nil {
+			w.res <- extraInfoResult{err: common.ErrConnClosed}
+			return false
+		}
+		w.res <- extraInfoResult{err: err}
+		return true
+	}
+
+	// the response is returned to the plugin, so it can't be from the pool
+	buf, err := util.ReadFrameDataNoPool(pc.Conn, length)
+	if err != nil {
+		w.res <- extraInfoResult{err: common.ErrConnClosed}
+		return false
+	}
+	w.res <- extraInfoResult{buf: buf}
+	return true
+}
+
+// serve handles the RPC and writes its response
+func (pc *pipelinedConn) serve(ctx context.Context, ty byte, buf *[]byte, mem *util.ConnMemory, st *connState) {
+	defer func() {
+		if !st.end() {
+			// the last in-flight RPC closes the conn
+			st.closeIfIdle()
+		}
+	}()
+
+	rc := newRPCConn(pc, mem)
+	// release in defer, so that the frames are not leaked when the RPC panics
+	defer func() {
+		rc.done()
+		mem.Release(len(*buf))
+		releaseFrameData(ty, buf)
+	}()
+
+	bd, respTy := dispatchRPC(ctx, ty, *buf, rc)
+	err := pc.writeFrame(respTy, bd.FinishedBytes())
+	util.PutBuilder(bd)
+	if err != nil {
+		pc.Conn.Close()
+	}
+}
+
+// rpcJob is an HTTPReqCall/HTTPRespCall to be handled by the workerPool
+type rpcJob struct {
+	ty  byte
+	buf *[]byte
+}
+
+// pendingPerWorker bounds the jobs queued by the workerPool, as the connection's memory is not
+// limited by default
+const pendingPerWorker = 16
+
+// errQueueFull answers the RPC beyond the jobs the workerPool can queue
+var errQueueFull = errors.New("too many RPCs queued on the connection")
+
+// workerPool runs the jobs with up to size goroutines. The jobs beyond it are queued instead
+// of blocking the reader, otherwise the in-flight RPCs can't get their extra info responses.
+// Up to size * pendingPerWorker jobs are queued, the reader should refuse the others.
+type workerPool struct {
+	size int
+	run  func(job rpcJob)
+	wg   sync.WaitGroup
+
+	lock    sync.Mutex
+	running int
+	pending []rpcJob
+}
+
+// full reports whether the job can't be submitted. As only the reader submits the jobs, the
+// pool is still not full when it submits the job later.
+func (p *workerPool) full() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.running >= p.size && len(p.pending) >= p.size*pendingPerWorker
+}
+
+// submit runs the job in a new goroutine if the pool is not full, otherwise queues it
+func (p *workerPool) submit(job rpcJob) {
+	p.lock.Lock()
+	if p.running >= p.size {
+		p.pending = append(p.pending, job)
+		p.lock.Unlock()
+		return
+	}
+	p.running++
+	p.lock.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for ok := true; ok; job, ok = p.next() {
+			p.run(job)
+		}
+	}()
+}
+
+// next pops the queued job. It returns false and frees the goroutine if there is none.
+func (p *workerPool) next() (rpcJob, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.pending) == 0 {
+		p.running--
+		return rpcJob{}, false
+	}
+	job := p.pending[0]
+	p.pending[0] = rpcJob{}
+	p.pending = p.pending[1:]
+	return job, true
+}
+
+// wait waits for all jobs to be finished
+func (p *workerPool) wait() {
+	p.wg.Wait()
+}
+
+// handleConnConcurrently is like handleConn, but up to workers HTTPReqCall/HTTPRespCall
+// are handled concurrently. The other RPCs, like PrepareConf, are still handled in order,
+// as the following RPCs may depend on them.
+func handleConnConcurrently(ctx context.Context, c net.Conn, st *connState, workers int) {
+	defer recoverPanic()
+
+	log.Infof("Client connected (%s), handle RPCs concurrently", c.RemoteAddr().Network())
+	defer c.Close()
+
+	metrics.ConnOpened()
+	defer metrics.ConnClosed()
+
+	pc := &pipelinedConn{Conn: c}
+	mem := util.NewConnMemory()
+	pool := &workerPool{
+		size: workers,
+		run: func(job rpcJob) {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Errorf("panic recovered: %s", err)
+					// the RPC is not answered, so the conn can't be used anymore
+					c.Close()
+				}
+			}()
+			pc.serve(ctx, job.ty, job.buf, mem, st)
+		},
+	}
+	// wait for the in-flight RPCs after failing their extra info requests
+	defer pool.wait()
+	defer pc.failWaiters()
+
+	header := make([]byte, util.HeaderLen)
+	for {
+		ty, length, err := util.ReadFrameHeader(c, header)
+		if err != nil {
+			break
+		}
+
+		if ty == util.RPCExtraInfo {
+			// the extra info response is checked by the rpcConn of the RPC waiting for it
+			if !pc.deliverExtraInfo(length) {
+				break
+			}
+			continue
+		}
+
+		if err := mem.Acquire(ty, length); err != nil {
+			pc.writeLock.Lock()
+			ok := refuseFrame(c, ty, length, err)
+			pc.writeLock.Unlock()
+			if !ok {
+				break
+			}
+			continue
+		}
+
+		buf, err := util.ReadFrameData(c, length)
+		if err != nil {
+			observeLimit(err)
+			break
+		}
+
+		isHTTPCall := ty == util.RPCHTTPReqCall || ty == util.RPCHTTPRespCall
+		if isHTTPCall && pool.full() {
+			// APISIX sends the RPCs faster than the workers handle them
+			log.Errorf("%s", errQueueFull)
+			metrics.FrameRefused("queue")
+			mem.Release(length)
+			util.PutBuf(buf)
+			if !pc.reportError(errQueueFull) {
+				break
+			}
+			continue
+		}
+
+		if !st.begin() {
+			// the server is draining. Answer the RPC with the error, so that APISIX doesn't
+			// wait for it, and keep reading the extra info responses of the in-flight RPCs,
+			// and the last of them closes the conn
+			mem.Release(length)
+			util.PutBuf(buf)
+			if !pc.reportError(errDraining) {
+				break
+			}
+			st.closeIfIdle()
+			continue
+		}
+
+		if !isHTTPCall {
+			pc.serve(ctx, ty, buf, mem, st)
+			continue
+		}
+
+		pool.submit(rpcJob{ty: ty, buf: buf})
+	}
+}
@@ -0,0 +1,293 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	A6 "github.com/api7/ext-plugin-proto/go/A6"
+	A6Err "github.com/api7/ext-plugin-proto/go/A6/Err"
+	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
+	hrc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
+)
+
+var concurrentBlockRelease = make(chan struct{})
+
+func init() {
+	plugin.RegisterPlugin("concurrent-block",
+		func(in []byte) (interface{}, error) {
+			return nil, nil
+		},
+		func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+			<-concurrentBlockRelease
+			w.WriteHeader(http.StatusOK)
+		},
+		func(conf interface{}, w pkgHTTP.Response) {},
+	)
+	plugin.RegisterPlugin("concurrent-var",
+		func(in []byte) (interface{}, error) {
+			return nil, nil
+		},
+		func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+			v, err := r.Var(fmt.Sprintf("v%d", r.ID()))
+			if err != nil {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("X-Var", string(v))
+			w.WriteHeader(http.StatusOK)
+		},
+		func(conf interface{}, w pkgHTTP.Response) {},
+	)
+}
+
+// serveConcurrently sends the HTTPReqCalls like APISIX, and returns the X-Var header
+// of the response of each request id
+func serveConcurrently(t *testing.T, workers int, ids []uint32) map[uint32]string {
+	plugin.InitConfCache(time.Second)
+	assert.Nil(t, plugin.SetRuleConfInTest(1, plugin.RuleConf{{Name: "concurrent-var"}}))
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	done := make(chan struct{})
+	go func() {
+		handleConnConcurrently(context.Background(), sc, nil, workers)
+		close(done)
+	}()
+
+	var writeLock sync.Mutex
+	write := func(ty byte, out []byte) {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		writeFrame(t, cc, ty, out)
+	}
+
+	for _, id := range ids {
+		bd := flatbuffers.NewBuilder(1024)
+		hrc.ReqStart(bd)
+		hrc.ReqAddId(bd, id)
+		hrc.ReqAddConfToken(bd, 1)
+		bd.Finish(hrc.ReqEnd(bd))
+		go write(util.RPCHTTPReqCall, bd.FinishedBytes())
+	}
+
+	res := map[uint32]string{}
+	for len(res) < len(ids) {
+		ty, buf := readFrame(t, cc)
+		if ty == util.RPCExtraInfo {
+			tab := &flatbuffers.Table{}
+			req := ei.GetRootAsReq(buf, 0)
+			assert.True(t, req.Info(tab))
+			info := &ei.Var{}
+			info.Init(tab.Bytes, tab.Pos)
+
+			bd := flatbuffers.NewBuilder(1024)
+			v := bd.CreateByteVector([]byte("val-" + string(info.Name())))
+			ei.RespStart(bd)
+			ei.RespAddResult(bd, v)
+			bd.Finish(ei.RespEnd(bd))
+			// answer in order, as the responses are correlated in FIFO order
+			write(util.RPCExtraInfo, bd.FinishedBytes())
+			continue
+		}
+
+		assert.Equal(t, byte(util.RPCHTTPReqCall), ty)
+		resp := hrc.GetRootAsResp(buf, 0)
+		tab := &flatbuffers.Table{}
+		assert.True(t, resp.Action(tab))
+		stop := &hrc.Stop{}
+		stop.Init(tab.Bytes, tab.Pos)
+		assert.Equal(t, 1, stop.HeadersLength())
+		e := &A6.TextEntry{}
+		stop.Headers(e, 0)
+		res[resp.Id()] = string(e.Value())
+	}
+
+	cc.Close()
+	<-done
+	return res
+}
+
+func TestHandleConnConcurrently(t *testing.T) {
+	start := time.Now()
+	res := serveConcurrently(t, 4, []uint32{1, 2, 3, 4})
+	assert.True(t, time.Since(start) < 300*time.Millisecond)
+	assert.Equal(t, map[uint32]string{
+		1: "val-v1",
+		2: "val-v2",
+		3: "val-v3",
+		4: "val-v4",
+	}, res)
+}
+
+func TestHandleConnConcurrently_BoundedWorkers(t *testing.T) {
+	start := time.Now()
+	res := serveConcurrently(t, 2, []uint32{1, 2, 3, 4})
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	assert.Equal(t, 4, len(res))
+}
+
+func TestHandleConnConcurrently_QueueFull(t *testing.T) {
+	plugin.InitConfCache(time.Minute)
+	assert.Nil(t, plugin.SetRuleConfInTest(1, plugin.RuleConf{{Name: "concurrent-block"}}))
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	done := make(chan struct{})
+	go func() {
+		handleConnConcurrently(context.Background(), sc, nil, 1)
+		close(done)
+	}()
+
+	// one is running, and pendingPerWorker are queued
+	accepted := 1 + pendingPerWorker
+	refused := 5
+	go func() {
+		for id := 1; id <= accepted+refused; id++ {
+			bd := flatbuffers.NewBuilder(1024)
+			hrc.ReqStart(bd)
+			hrc.ReqAddId(bd, uint32(id))
+			hrc.ReqAddConfToken(bd, 1)
+			bd.Finish(hrc.ReqEnd(bd))
+			writeFrame(t, cc, util.RPCHTTPReqCall, bd.FinishedBytes())
+		}
+	}()
+
+	for i := 0; i < refused; i++ {
+		ty, buf := readFrame(t, cc)
+		assert.Equal(t, byte(util.RPCError), ty)
+		assert.Equal(t, A6Err.CodeSERVICE_UNAVAILABLE, A6Err.GetRootAsResp(buf, 0).Code())
+	}
+
+	close(concurrentBlockRelease)
+	for i := 0; i < accepted; i++ {
+		ty, _ := readFrame(t, cc)
+		assert.Equal(t, byte(util.RPCHTTPReqCall), ty)
+	}
+
+	cc.Close()
+	<-done
+}
+
+func TestPipelinedConn_FailWaiters(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	pc := &pipelinedConn{Conn: sc}
+
+	go func() {
+		// receive the extra info request and then close the conn
+		readFrame(t, cc)
+		pc.failWaiters()
+	}()
+	_, err := pc.RoundTripExtraInfo([]byte("req"), func(uint32) error { return nil })
+	assert.NotNil(t, err)
+
+	_, err = pc.RoundTripExtraInfo([]byte("req"), func(uint32) error { return nil })
+	assert.NotNil(t, err)
+}
+
+func TestWorkerPool_Bounded(t *testing.T) {
+	var running, maxRunning, finished int32
+	unblock := make(chan struct{})
+	p := &workerPool{
+		size: 2,
+		run: func(job rpcJob) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			<-unblock
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&finished, 1)
+		},
+	}
+
+	for i := 0; i < 5; i++ {
+		p.submit(rpcJob{ty: util.RPCHTTPReqCall})
+	}
+	// the jobs beyond the size are queued without blocking the caller
+	p.lock.Lock()
+	assert.Equal(t, 3, len(p.pending))
+	p.lock.Unlock()
+	assert.False(t, p.full())
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&running) == 2
+	}, time.Second, time.Millisecond)
+
+	close(unblock)
+	p.wait()
+	assert.Equal(t, int32(2), maxRunning)
+	assert.Equal(t, int32(5), finished)
+	assert.Equal(t, 0, p.running)
+}
+
+// panicConn panics when the response is written
+type panicConn struct {
+	net.Conn
+}
+
+func (panicConn) Write([]byte) (int, error) {
+	panic("write")
+}
+
+func TestPipelinedConn_ServePanic(t *testing.T) {
+	plugin.InitConfCache(time.Second)
+	assert.Nil(t, plugin.SetRuleConfInTest(1, plugin.RuleConf{}))
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	pc := &pipelinedConn{Conn: panicConn{sc}}
+	st := newConnTracker().track(sc)
+	mem := util.NewConnMemory()
+
+	bd := flatbuffers.NewBuilder(1024)
+	hrc.ReqStart(bd)
+	hrc.ReqAddId(bd, 1)
+	hrc.ReqAddConfToken(bd, 1)
+	bd.Finish(hrc.ReqEnd(bd))
+	out := bd.FinishedBytes()
+	assert.Nil(t, mem.Acquire(util.RPCHTTPReqCall, len(out)))
+	buf := util.GetBuf(len(out))
+	copy(*buf, out)
+	assert.True(t, st.begin())
+
+	func() {
+		defer func() {
+			assert.NotNil(t, recover())
+		}()
+		pc.serve(context.Background(), util.RPCHTTPReqCall, buf, mem, st)
+	}()
+	assert.Equal(t, 0, mem.Used())
+	assert.Equal(t, 0, st.busy)
+}
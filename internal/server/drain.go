@@ -0,0 +1,183 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// errDraining answers the RPC read when the server is draining
+var errDraining = errors.New("the runner is shutting down")
+
+// connTracker records the active connections, so that we can wait for the in-flight RPCs
+// when the server is exiting.
+type connTracker struct {
+	mu       sync.Mutex
+	conns    map[*connState]struct{}
+	draining bool
+
+	wg sync.WaitGroup
+	// finished is closed when all connections are finished after the drain
+	finished chan struct{}
+	waitOnce sync.Once
+}
+
+// connState is the state of a tracked connection. A connection is busy from the time a whole
+// RPC frame is read until its response is written. The RPCs may be handled concurrently, so the
+// in-flight ones are counted.
+type connState struct {
+	conn    net.Conn
+	tracker *connTracker
+	busy    int
+	closed  bool
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns:    map[*connState]struct{}{},
+		finished: make(chan struct{}),
+	}
+}
+
+// track registers the conn. It returns nil if the server is draining.
+func (ct *connTracker) track(c net.Conn) *connState {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if ct.draining {
+		return nil
+	}
+
+	st := &connState{conn: c, tracker: ct}
+	ct.conns[st] = struct{}{}
+	ct.wg.Add(1)
+	return st
+}
+
+func (ct *connTracker) untrack(st *connState) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if _, ok := ct.conns[st]; ok {
+		delete(ct.conns, st)
+		ct.wg.Done()
+	}
+}
+
+// drain closes the idle connections and marks the busy ones to be closed after
+// their current RPC.
+func (ct *connTracker) drain() {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.draining = true
+	for st := range ct.conns {
+		if st.busy == 0 {
+			st.close()
+		}
+	}
+}
+
+// wait waits for all tracked connections to be finished. It returns false on timeout,
+// and can be called again to wait longer. It should be called after drain, so that no
+// connection is tracked anymore.
+func (ct *connTracker) wait(timeout time.Duration) bool {
+	ct.waitOnce.Do(func() {
+		go func() {
+			ct.wg.Wait()
+			close(ct.finished)
+		}()
+	})
+
+	select {
+	case <-ct.finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// closeAll closes all connections and returns the number of the busy ones
+func (ct *connTracker) closeAll() int {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	n := 0
+	for st := range ct.conns {
+		if st.busy > 0 {
+			n++
+		}
+		st.close()
+	}
+	return n
+}
+
+// close should be called with the tracker's lock held
+func (st *connState) close() {
+	if !st.closed {
+		st.closed = true
+		st.conn.Close()
+	}
+}
+
+// begin marks the conn as busy. It returns false if the server is draining, so that a busy
+// conn doesn't take new RPCs, or the conn is closed by the draining.
+// A nil connState is always available, so that the conn can be handled without tracking.
+func (st *connState) begin() bool {
+	if st == nil {
+		return true
+	}
+
+	st.tracker.mu.Lock()
+	defer st.tracker.mu.Unlock()
+	if st.closed || st.tracker.draining {
+		return false
+	}
+	st.busy++
+	return true
+}
+
+// end marks the RPC as finished. It returns false if the server is draining, and the conn
+// should not be used anymore.
+func (st *connState) end() bool {
+	if st == nil {
+		return true
+	}
+
+	st.tracker.mu.Lock()
+	defer st.tracker.mu.Unlock()
+	st.busy--
+	return !st.tracker.draining
+}
+
+// closeIfIdle closes the conn if there is no in-flight RPC. It is used when the server is
+// draining and the RPCs are handled concurrently.
+func (st *connState) closeIfIdle() {
+	if st == nil {
+		return
+	}
+
+	st.tracker.mu.Lock()
+	defer st.tracker.mu.Unlock()
+	if st.busy == 0 {
+		st.close()
+	}
+}
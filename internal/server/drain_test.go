@@ -0,0 +1,246 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	A6 "github.com/api7/ext-plugin-proto/go/A6"
+	A6Err "github.com/api7/ext-plugin-proto/go/A6/Err"
+	hrc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	pc "github.com/api7/ext-plugin-proto/go/A6/PrepareConf"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
+)
+
+var slowFilterDone = make(chan error, 1)
+
+func init() {
+	plugin.RegisterPlugin("drain-slow",
+		func(in []byte) (interface{}, error) {
+			return time.ParseDuration(string(in))
+		},
+		func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+			select {
+			case <-time.After(conf.(time.Duration)):
+				w.WriteHeader(http.StatusAccepted)
+				slowFilterDone <- nil
+			case <-r.Context().Done():
+				slowFilterDone <- r.Context().Err()
+			}
+		},
+		func(conf interface{}, w pkgHTTP.Response) {},
+	)
+}
+
+func writeFrame(t *testing.T, conn net.Conn, ty byte, out []byte) {
+	header := make([]byte, util.HeaderLen)
+	binary.BigEndian.PutUint32(header, uint32(len(out)))
+	header[0] = ty
+	_, err := util.WriteBytes(conn, append(header, out...), len(out)+util.HeaderLen)
+	assert.Nil(t, err)
+}
+
+func readFrame(t *testing.T, conn net.Conn) (byte, []byte) {
+	header := make([]byte, util.HeaderLen)
+	_, err := util.ReadBytes(conn, header, util.HeaderLen)
+	assert.Nil(t, err)
+	ty := header[0]
+	header[0] = 0
+	length := binary.BigEndian.Uint32(header)
+	buf := make([]byte, length)
+	_, err = util.ReadBytes(conn, buf, int(length))
+	assert.Nil(t, err)
+	return ty, buf
+}
+
+func prepareSlowPluginConf(t *testing.T, conn net.Conn, delay time.Duration) uint32 {
+	bd := flatbuffers.NewBuilder(1024)
+	name := bd.CreateString("drain-slow")
+	value := bd.CreateString(delay.String())
+	A6.TextEntryStart(bd)
+	A6.TextEntryAddName(bd, name)
+	A6.TextEntryAddValue(bd, value)
+	te := A6.TextEntryEnd(bd)
+	pc.ReqStartConfVector(bd, 1)
+	bd.PrependUOffsetT(te)
+	v := bd.EndVector(1)
+	pc.ReqStart(bd)
+	pc.ReqAddConf(bd, v)
+	root := pc.ReqEnd(bd)
+	bd.Finish(root)
+	writeFrame(t, conn, util.RPCPrepareConf, bd.FinishedBytes())
+
+	ty, buf := readFrame(t, conn)
+	assert.Equal(t, byte(util.RPCPrepareConf), ty)
+	return pc.GetRootAsResp(buf, 0).ConfToken()
+}
+
+func sendReqCall(t *testing.T, conn net.Conn, id uint32, token uint32) {
+	bd := flatbuffers.NewBuilder(1024)
+	hrc.ReqStart(bd)
+	hrc.ReqAddId(bd, id)
+	hrc.ReqAddConfToken(bd, token)
+	r := hrc.ReqEnd(bd)
+	bd.Finish(r)
+	writeFrame(t, conn, util.RPCHTTPReqCall, bd.FinishedBytes())
+}
+
+func runWithSlowPlugin(t *testing.T, path string, delay time.Duration, opts Options) (net.Conn, chan struct{}) {
+	os.Setenv(SockAddrEnv, "unix:"+path)
+	os.Setenv(ConfCacheTTLEnv, "60")
+
+	exited := make(chan struct{})
+	go func() {
+		Run(opts)
+		close(exited)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("unix", path, 1*time.Second)
+	assert.Nil(t, err)
+	token := prepareSlowPluginConf(t, conn, delay)
+	sendReqCall(t, conn, 233, token)
+	return conn, exited
+}
+
+func TestRun_DrainInFlightRPC(t *testing.T) {
+	stop := make(chan struct{})
+	conn, exited := runWithSlowPlugin(t, "/tmp/drain.sock", 200*time.Millisecond,
+		Options{Stop: stop, DrainTimeout: 2 * time.Second})
+	defer conn.Close()
+
+	// an idle connection should be closed during the drain
+	idle, err := net.DialTimeout("unix", "/tmp/drain.sock", 1*time.Second)
+	assert.Nil(t, err)
+	defer idle.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+
+	ty, buf := readFrame(t, conn)
+	assert.Equal(t, byte(util.RPCHTTPReqCall), ty)
+	resp := hrc.GetRootAsResp(buf, 0)
+	assert.Equal(t, uint32(233), resp.Id())
+	assert.Equal(t, hrc.ActionStop, resp.ActionType())
+	assert.Nil(t, <-slowFilterDone)
+
+	// the connection is closed after the in-flight RPC is finished
+	header := make([]byte, util.HeaderLen)
+	_, err = util.ReadBytes(conn, header, util.HeaderLen)
+	assert.NotNil(t, err)
+	_, err = util.ReadBytes(idle, header, util.HeaderLen)
+	assert.NotNil(t, err)
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("server doesn't exit")
+	}
+	_, err = os.Stat("/tmp/drain.sock")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRun_DrainTimeout(t *testing.T) {
+	stop := make(chan struct{})
+	conn, exited := runWithSlowPlugin(t, "/tmp/drain-timeout.sock", time.Hour,
+		Options{Stop: stop, DrainTimeout: 100 * time.Millisecond})
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	close(stop)
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("server doesn't exit")
+	}
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	select {
+	case err := <-slowFilterDone:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("request context is not canceled")
+	}
+}
+
+func TestConnState_BeginWhenDraining(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	ct := newConnTracker()
+	st := ct.track(sc)
+
+	assert.True(t, st.begin())
+	ct.drain()
+	// the busy conn is kept for the in-flight RPC, but doesn't take new ones
+	assert.False(t, st.closed)
+	assert.False(t, st.begin())
+	assert.False(t, st.end())
+	st.closeIfIdle()
+	assert.True(t, st.closed)
+}
+
+func TestHandleConnConcurrently_RefuseWhenDraining(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	ct := newConnTracker()
+	st := ct.track(sc)
+	// an in-flight RPC keeps the conn open during the drain
+	assert.True(t, st.begin())
+	ct.drain()
+
+	done := make(chan struct{})
+	go func() {
+		handleConnConcurrently(context.Background(), sc, st, 2)
+		close(done)
+	}()
+
+	sendReqCall(t, cc, 233, 1)
+	ty, buf := readFrame(t, cc)
+	assert.Equal(t, byte(util.RPCError), ty)
+	assert.Equal(t, A6Err.CodeSERVICE_UNAVAILABLE, A6Err.GetRootAsResp(buf, 0).Code())
+
+	cc.Close()
+	<-done
+}
+
+func TestConnTracker_WaitAgain(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	ct := newConnTracker()
+	st := ct.track(sc)
+	assert.True(t, st.begin())
+	ct.drain()
+
+	assert.False(t, ct.wait(10*time.Millisecond))
+	ct.untrack(st)
+	// the later wait reuses the result of the first one
+	assert.True(t, ct.wait(time.Second))
+}
@@ -24,6 +24,7 @@ import (
 	A6Err "github.com/api7/ext-plugin-proto/go/A6/Err"
 	flatbuffers "github.com/google/flatbuffers/go"
 
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
 	"github.com/apache/apisix-go-plugin-runner/internal/util"
 )
 
@@ -44,9 +45,16 @@ func ReportError(err error) *flatbuffers.Builder {
 	case ttlcache.ErrNotFound:
 		code = A6Err.CodeCONF_TOKEN_NOT_FOUND
 	default:
-		switch err.(type) {
-		case UnknownType:
+		switch e := err.(type) {
+		case UnknownType, plugin.ErrInvalidConf, plugin.ErrMalformedRPC:
 			code = A6Err.CodeBAD_REQUEST
+		case *util.LimitError:
+			// the connection's memory may be available later
+			if e.Scope == util.FrameScope {
+				code = A6Err.CodeBAD_REQUEST
+			} else {
+				code = A6Err.CodeSERVICE_UNAVAILABLE
+			}
 		default:
 			code = A6Err.CodeSERVICE_UNAVAILABLE
 		}
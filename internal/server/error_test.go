@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
 	A6Err "github.com/api7/ext-plugin-proto/go/A6/Err"
 	"github.com/stretchr/testify/assert"
 )
@@ -50,3 +51,24 @@ func TestReportErrorUnknownErr(t *testing.T) {
 	resp := A6Err.GetRootAsResp(out, 0)
 	assert.Equal(t, A6Err.CodeSERVICE_UNAVAILABLE, resp.Code())
 }
+
+func TestReportErrorInvalidConf(t *testing.T) {
+	b := ReportError(plugin.ErrInvalidConf{Plugin: "foo", Err: io.EOF})
+	out := b.FinishedBytes()
+	resp := A6Err.GetRootAsResp(out, 0)
+	assert.Equal(t, A6Err.CodeBAD_REQUEST, resp.Code())
+}
+
+func TestReportErrorLimit(t *testing.T) {
+	b := ReportError(&util.LimitError{Scope: util.FrameScope})
+	resp := A6Err.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, A6Err.CodeBAD_REQUEST, resp.Code())
+
+	b = ReportError(&util.LimitError{Scope: util.ConnScope})
+	resp = A6Err.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, A6Err.CodeSERVICE_UNAVAILABLE, resp.Code())
+
+	b = ReportError(plugin.ErrMalformedRPC{RPC: "HTTPReqCall"})
+	resp = A6Err.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, A6Err.CodeBAD_REQUEST, resp.Code())
+}
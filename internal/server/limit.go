@@ -0,0 +1,101 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"net"
+	"sync/atomic"
+
+	inHTTP "github.com/apache/apisix-go-plugin-runner/internal/http"
+	"github.com/apache/apisix-go-plugin-runner/internal/metrics"
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+)
+
+// rpcConn is the conn passed to the RPC handler. The extra info responses got by the RPC
+// are counted in the conn's memory until the RPC is done.
+type rpcConn struct {
+	net.Conn
+
+	mem *util.ConnMemory
+	// header is used to read the extra info response when the conn is not shared
+	header []byte
+	used   int64
+}
+
+func newRPCConn(c net.Conn, mem *util.ConnMemory) *rpcConn {
+	return &rpcConn{
+		Conn:   c,
+		mem:    mem,
+		header: make([]byte, util.HeaderLen),
+	}
+}
+
+// RoundTripExtraInfo implements http.ExtraInfoConn
+func (rc *rpcConn) RoundTripExtraInfo(out []byte, check func(length uint32) error) ([]byte, error) {
+	buf, err := inHTTP.RoundTripExtraInfo(rc.Conn, rc.header, out, func(length uint32) error {
+		if err := rc.mem.Acquire(util.RPCExtraInfo, int(length)); err != nil {
+			log.Errorf("%s", err)
+			observeLimit(err)
+			return err
+		}
+		if err := check(length); err != nil {
+			rc.mem.Release(int(length))
+			return err
+		}
+		atomic.AddInt64(&rc.used, int64(length))
+		return nil
+	})
+	if _, ok := err.(*util.TimeoutError); ok {
+		observeLimit(err)
+	}
+	return buf, err
+}
+
+// done releases the memory of the extra info responses got by the RPC
+func (rc *rpcConn) done() {
+	rc.mem.Release(int(atomic.SwapInt64(&rc.used, 0)))
+}
+
+// observeLimit counts the frame which breaks the limits
+func observeLimit(err error) {
+	switch e := err.(type) {
+	case *util.LimitError:
+		metrics.FrameRefused(e.Scope)
+	case *util.TimeoutError:
+		metrics.FrameRefused("timeout")
+	}
+}
+
+// refuseFrame drops the data of the frame which exceeds the limit, and answers it with
+// the error. It returns false if the conn can't be used anymore.
+func refuseFrame(c net.Conn, ty byte, length int, err error) bool {
+	log.Errorf("%s", err)
+	observeLimit(err)
+	if util.DiscardFrameData(c, length) != nil {
+		return false
+	}
+	if ty == util.RPCExtraInfo {
+		// nobody waits for it
+		return true
+	}
+
+	bd := ReportError(err)
+	defer util.PutBuilder(bd)
+	return util.WriteFrame(c, util.RPCError, bd.FinishedBytes()) == nil
+}
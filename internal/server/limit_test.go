@@ -0,0 +1,151 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	A6Err "github.com/api7/ext-plugin-proto/go/A6/Err"
+	ei "github.com/api7/ext-plugin-proto/go/A6/ExtraInfo"
+	hrc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/metrics"
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+)
+
+// the conf token used by the tests here, which is not used by the other tests
+const limitConfToken = 2
+
+func startHandleConn(t *testing.T) (net.Conn, chan struct{}) {
+	plugin.InitConfCache(time.Second)
+	assert.Nil(t, plugin.SetRuleConfInTest(limitConfToken, plugin.RuleConf{{Name: "concurrent-var"}}))
+
+	cc, sc := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handleConn(context.Background(), sc, nil)
+		close(done)
+	}()
+	return cc, done
+}
+
+func buildReqCall(id uint32) []byte {
+	bd := flatbuffers.NewBuilder(1024)
+	hrc.ReqStart(bd)
+	hrc.ReqAddId(bd, id)
+	hrc.ReqAddConfToken(bd, limitConfToken)
+	bd.Finish(hrc.ReqEnd(bd))
+	return bd.FinishedBytes()
+}
+
+func readErrCode(t *testing.T, conn net.Conn) A6Err.Code {
+	ty, buf := readFrame(t, conn)
+	assert.Equal(t, byte(util.RPCError), ty)
+	return A6Err.GetRootAsResp(buf, 0).Code()
+}
+
+func metricsText() string {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestHandleConn_RefuseFrame(t *testing.T) {
+	util.SetFrameLimits(64, 0)
+	defer util.SetFrameLimits(0, 0)
+
+	cc, done := startHandleConn(t)
+	defer func() {
+		cc.Close()
+		<-done
+	}()
+
+	writeFrame(t, cc, util.RPCHTTPReqCall, make([]byte, 65))
+	assert.Equal(t, A6Err.CodeBAD_REQUEST, readErrCode(t, cc))
+	assert.Contains(t, metricsText(), `apisix_go_runner_frame_refused_total{reason="frame"} 1`)
+
+	// malformed
+	writeFrame(t, cc, util.RPCHTTPReqCall, []byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4})
+	assert.Equal(t, A6Err.CodeBAD_REQUEST, readErrCode(t, cc))
+
+	// the conn is still usable
+	writeFrame(t, cc, util.RPCHTTPReqCall, buildReqCall(1))
+	ty, _ := readFrame(t, cc)
+	assert.Equal(t, byte(util.RPCExtraInfo), ty)
+	bd := flatbuffers.NewBuilder(1024)
+	v := bd.CreateByteVector([]byte("val"))
+	ei.RespStart(bd)
+	ei.RespAddResult(bd, v)
+	bd.Finish(ei.RespEnd(bd))
+	writeFrame(t, cc, util.RPCExtraInfo, bd.FinishedBytes())
+	ty, _ = readFrame(t, cc)
+	assert.Equal(t, byte(util.RPCHTTPReqCall), ty)
+}
+
+func TestHandleConn_ConnMemory(t *testing.T) {
+	req := buildReqCall(1)
+	util.SetFrameLimits(0, len(req)+32)
+	defer util.SetFrameLimits(0, 0)
+
+	cc, done := startHandleConn(t)
+	defer func() {
+		cc.Close()
+		<-done
+	}()
+
+	writeFrame(t, cc, util.RPCHTTPReqCall, req)
+	ty, _ := readFrame(t, cc)
+	assert.Equal(t, byte(util.RPCExtraInfo), ty)
+	// the extra info response exceeds the conn's limit, so the plugin fails to get it
+	writeFrame(t, cc, util.RPCExtraInfo, make([]byte, 33))
+	ty, buf := readFrame(t, cc)
+	assert.Equal(t, byte(util.RPCHTTPReqCall), ty)
+	assert.Equal(t, hrc.ActionNONE, hrc.GetRootAsResp(buf, 0).ActionType())
+	assert.Contains(t, metricsText(), `apisix_go_runner_frame_refused_total{reason="connection"} 1`)
+}
+
+func TestHandleConn_ReadTimeout(t *testing.T) {
+	util.SetFrameTimeouts(50*time.Millisecond, 0)
+	defer util.SetFrameTimeouts(0, 0)
+
+	cc, done := startHandleConn(t)
+	defer cc.Close()
+
+	writeFrame(t, cc, util.RPCHTTPReqCall, buildReqCall(1))
+	ty, _ := readFrame(t, cc)
+	assert.Equal(t, byte(util.RPCExtraInfo), ty)
+
+	// the extra info response is not sent, so the conn is closed
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("conn is not closed")
+	}
+	_, err := cc.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Contains(t, metricsText(), `apisix_go_runner_frame_refused_total{reason="timeout"} 1`)
+}
@@ -0,0 +1,189 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+)
+
+const (
+	// TLSCertFileEnv and TLSKeyFileEnv enable TLS for the tcp:// listener
+	TLSCertFileEnv = "APISIX_LISTEN_TLS_CERT_FILE"
+	TLSKeyFileEnv  = "APISIX_LISTEN_TLS_KEY_FILE"
+	// TLSClientCAFileEnv enables mutual TLS. The client must present a certificate
+	// signed by one of the CAs in this file.
+	TLSClientCAFileEnv = "APISIX_LISTEN_TLS_CLIENT_CA_FILE"
+)
+
+// listenAddr is the parsed form of APISIX_LISTEN_ADDRESS. The supported formats are:
+//
+//	unix:/path/to/runner.sock
+//	unix:@name (Linux abstract socket)
+//	tcp://host:port
+type listenAddr struct {
+	network string
+	address string
+}
+
+func (a *listenAddr) String() string {
+	if a.network == "tcp" {
+		return "tcp://" + a.address
+	}
+	return a.network + ":" + a.address
+}
+
+// isSockFile reports whether the address is backed by a file in the filesystem,
+// which needs to be cleaned up and chmod-ed.
+func (a *listenAddr) isSockFile() bool {
+	return a.network == "unix" && !strings.HasPrefix(a.address, "@")
+}
+
+func parseListenAddr(addr string) (*listenAddr, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		path := addr[len("unix:"):]
+		if path == "" || path == "@" {
+			return nil, errors.New("empty socket path")
+		}
+		if strings.HasPrefix(path, "@") && runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("abstract socket is not supported on %s", runtime.GOOS)
+		}
+		return &listenAddr{network: "unix", address: path}, nil
+
+	case strings.HasPrefix(addr, "tcp://"):
+		hostPort := addr[len("tcp://"):]
+		if _, _, err := net.SplitHostPort(hostPort); err != nil {
+			return nil, err
+		}
+		return &listenAddr{network: "tcp", address: hostPort}, nil
+
+	default:
+		return nil, errors.New("unsupported scheme")
+	}
+}
+
+// TLSOptions contains the files to enable TLS. The empty one falls back to its environment
+// variable, like APISIX_LISTEN_TLS_CERT_FILE.
+type TLSOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+func orEnv(v, env string) string {
+	if v != "" {
+		return v
+	}
+	return os.Getenv(env)
+}
+
+// getListenAddr parses the configured address, or the one in the environment variable
+func getListenAddr(configured string) *listenAddr {
+	addr := orEnv(configured, SockAddrEnv)
+	la, err := parseListenAddr(addr)
+	if err != nil {
+		log.Errorf("invalid socket address %s: %s", addr, err)
+		return nil
+	}
+	return la
+}
+
+// getTLSConfig returns nil when TLS is not configured
+func getTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	certFile := orEnv(opts.CertFile, TLSCertFileEnv)
+	keyFile := orEnv(opts.KeyFile, TLSKeyFileEnv)
+	caFile := orEnv(opts.ClientCAFile, TLSClientCAFileEnv)
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both %s and %s should be set", TLSCertFileEnv, TLSKeyFileEnv)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load key pair: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+func listen(addr *listenAddr, tlsOpts TLSOptions) (net.Listener, error) {
+	if addr.network == "tcp" {
+		cfg, err := getTLSConfig(tlsOpts)
+		if err != nil {
+			return nil, err
+		}
+		if cfg != nil {
+			log.Warnf("TLS is enabled, client verification: %v", cfg.ClientAuth == tls.RequireAndVerifyClientCert)
+			return tls.Listen("tcp", addr.address, cfg)
+		}
+		return net.Listen("tcp", addr.address)
+	}
+
+	if !addr.isSockFile() {
+		return net.Listen("unix", addr.address)
+	}
+
+	sockAddr := addr.address
+	// clean up sock file created by others
+	if err := os.RemoveAll(sockAddr); err != nil {
+		return nil, fmt.Errorf("remove file %s: %w", sockAddr, err)
+	}
+
+	l, err := net.Listen("unix", sockAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the default socket permission is 0755, which prevents the 'nobody' worker process
+	// from writing to it if the APISIX is run under root.
+	err = os.Chmod(sockAddr, 0766)
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("can't change mod for file %s: %w", sockAddr, err)
+	}
+	return l, nil
+}
@@ -0,0 +1,202 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseListenAddr(t *testing.T) {
+	cases := []struct {
+		in      string
+		network string
+		address string
+		file    bool
+	}{
+		{"unix:/tmp/x.sock", "unix", "/tmp/x.sock", true},
+		{"unix:@runner", "unix", "@runner", false},
+		{"tcp://127.0.0.1:9999", "tcp", "127.0.0.1:9999", false},
+		{"tcp://[::1]:9999", "tcp", "[::1]:9999", false},
+	}
+	for _, c := range cases {
+		if c.address[0] == '@' && runtime.GOOS != "linux" {
+			continue
+		}
+		addr, err := parseListenAddr(c.in)
+		assert.Nil(t, err, c.in)
+		assert.Equal(t, c.network, addr.network)
+		assert.Equal(t, c.address, addr.address)
+		assert.Equal(t, c.file, addr.isSockFile())
+		assert.Equal(t, c.in, addr.String())
+	}
+
+	for _, in := range []string{"", "unix:", "unix:@", "tcp://", "tcp://127.0.0.1", "/tmp/x.sock"} {
+		_, err := parseListenAddr(in)
+		assert.NotNil(t, err, in)
+	}
+}
+
+func TestListenTCP(t *testing.T) {
+	addr, _ := parseListenAddr("tcp://127.0.0.1:0")
+	l, err := listen(addr, TLSOptions{})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	go func() {
+		conn, err := net.DialTimeout("tcp", l.Addr().String(), time.Second)
+		assert.Nil(t, err)
+		conn.Write([]byte("a"))
+		conn.Close()
+	}()
+
+	conn, err := l.Accept()
+	assert.Nil(t, err)
+	buf := make([]byte, 1)
+	_, err = conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(buf))
+}
+
+func TestListenAbstractSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract socket is only supported on Linux")
+	}
+
+	addr, _ := parseListenAddr("unix:@apisix-go-plugin-runner-test")
+	l, err := listen(addr, TLSOptions{})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	conn, err := net.DialTimeout("unix", "@apisix-go-plugin-runner-test", time.Second)
+	assert.Nil(t, err)
+	conn.Close()
+}
+
+func writeSelfSignedCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	assert.Nil(t, err)
+	return certFile, keyFile
+}
+
+func TestListenMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner-tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeSelfSignedCert(t, dir)
+	os.Setenv(TLSCertFileEnv, certFile)
+	os.Setenv(TLSKeyFileEnv, keyFile)
+	os.Setenv(TLSClientCAFileEnv, certFile)
+	defer func() {
+		os.Unsetenv(TLSCertFileEnv)
+		os.Unsetenv(TLSKeyFileEnv)
+		os.Unsetenv(TLSClientCAFileEnv)
+	}()
+
+	addr, _ := parseListenAddr("tcp://127.0.0.1:0")
+	l, err := listen(addr, TLSOptions{})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 1)
+			conn.Read(buf)
+			conn.Write(buf)
+			conn.Close()
+		}
+	}()
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	assert.Nil(t, err)
+	pemData, _ := ioutil.ReadFile(certFile)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pemData)
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{cert},
+	})
+	assert.Nil(t, err)
+	conn.Write([]byte("a"))
+	buf := make([]byte, 1)
+	_, err = conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(buf))
+	conn.Close()
+
+	// client without certificate is rejected
+	conn, err = tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: pool})
+	if err == nil {
+		conn.Write([]byte("a"))
+		_, err = conn.Read(buf)
+		conn.Close()
+	}
+	assert.NotNil(t, err)
+}
+
+func TestGetTLSConfig_MissingKey(t *testing.T) {
+	os.Setenv(TLSCertFileEnv, "/tmp/cert.pem")
+	defer os.Unsetenv(TLSCertFileEnv)
+
+	_, err := getTLSConfig(TLSOptions{})
+	assert.NotNil(t, err)
+}
@@ -18,20 +18,24 @@
 package server
 
 import (
-	"encoding/binary"
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ReneKroon/ttlcache/v2"
 	flatbuffers "github.com/google/flatbuffers/go"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/apache/apisix-go-plugin-runner/internal/capture"
+	inHTTP "github.com/apache/apisix-go-plugin-runner/internal/http"
+	"github.com/apache/apisix-go-plugin-runner/internal/metrics"
 	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	"github.com/apache/apisix-go-plugin-runner/internal/tracing"
 	"github.com/apache/apisix-go-plugin-runner/internal/util"
 	"github.com/apache/apisix-go-plugin-runner/pkg/log"
 )
@@ -39,20 +43,88 @@ import (
 const (
 	SockAddrEnv     = "APISIX_LISTEN_ADDRESS"
 	ConfCacheTTLEnv = "APISIX_CONF_EXPIRE_TIME"
+	// MetricsAddrEnv enables the Prometheus metrics endpoint, like ":9091"
+	MetricsAddrEnv = "GO_RUNNER_METRICS_ADDRESS"
 )
 
-type handler func(buf []byte, conn net.Conn) (*flatbuffers.Builder, error)
+const (
+	defaultDrainTimeout = 5 * time.Second
+	// closeTimeout is the max time to wait for the connections closed after the drain timeout
+	closeTimeout = time.Second
+)
+
+// Options controls the behavior of Run
+type Options struct {
+	// ListenAddress is the address to listen, like `unix:/tmp/runner.sock`. It takes precedence
+	// over the environment variable APISIX_LISTEN_ADDRESS.
+	ListenAddress string
+	// TLS enables TLS for the tcp:// listener
+	TLS TLSOptions
+	// ConfExpireTime is the time which APISIX keeps the conf token, and the runner keeps the conf
+	// a bit longer. It takes precedence over the environment variable APISIX_CONF_EXPIRE_TIME.
+	ConfExpireTime time.Duration
+
+	// Stop triggers the graceful shutdown when it is closed, like receiving SIGINT or SIGTERM
+	Stop <-chan struct{}
+	// DrainTimeout is the max time to wait for the in-flight RPCs during the shutdown
+	DrainTimeout time.Duration
+
+	// StrictConf makes PrepareConf fail when a plugin's conf is invalid
+	StrictConf bool
+	// PluginStrictConf overrides StrictConf for the given plugins
+	PluginStrictConf map[string]bool
+
+	// MetricsAddress is the TCP address to expose the Prometheus metrics at /metrics.
+	// It takes precedence over the environment variable GO_RUNNER_METRICS_ADDRESS.
+	// The metrics endpoint is disabled if both are empty.
+	MetricsAddress string
+
+	// TracerProvider creates the spans of the RPCs and plugins. The tracing is disabled if it is nil.
+	TracerProvider trace.TracerProvider
+
+	// BodyBudget is the max size of the body which a request can read from or write to APISIX.
+	// Zero means no limit.
+	BodyBudget int
+
+	// Concurrency is the max number of HTTPReqCall/HTTPRespCall handled concurrently per
+	// connection. The RPCs are handled one by one if it is not greater than 1.
+	Concurrency int
+
+	// ReadTimeout is the max time to read the frame expected from APISIX, like the rest of
+	// a RPC after its header or the extra info response. The wait for the next RPC on an
+	// idle connection is not limited. Zero means no limit.
+	ReadTimeout time.Duration
+	// WriteTimeout is the max time to write a frame to APISIX. Zero means no limit.
+	WriteTimeout time.Duration
+	// MaxFrameSize is the max length of the frame from APISIX. The larger frame is dropped
+	// without being read into the memory, and answered with an error. Zero means no limit
+	// except the one of the protocol.
+	MaxFrameSize int
+	// MaxConnMemory is the max bytes of the frames held by a connection at the same time,
+	// including the in-flight RPCs and their extra info responses. Zero means no limit.
+	MaxConnMemory int
+
+	// Capture records the frames of the connections from APISIX into the capture file.
+	// The recording is disabled if it is nil.
+	Capture *capture.Options
+
+	// PluginSettings is the global settings of the plugins in JSON, keyed by the plugin name,
+	// which are handed to the plugins before they are initialized
+	PluginSettings map[string][]byte
+}
+
+type handler func(ctx context.Context, buf []byte, conn net.Conn) (*flatbuffers.Builder, error)
 
 var (
 	typeHandlerMap = map[byte]handler{
-		util.RPCPrepareConf: func(buf []byte, conn net.Conn) (*flatbuffers.Builder, error) {
+		util.RPCPrepareConf: func(ctx context.Context, buf []byte, conn net.Conn) (*flatbuffers.Builder, error) {
 			return plugin.PrepareConf(buf)
 		},
-		util.RPCHTTPReqCall: func(buf []byte, conn net.Conn) (*flatbuffers.Builder, error) {
-			return plugin.HTTPReqCall(buf, conn)
+		util.RPCHTTPReqCall: func(ctx context.Context, buf []byte, conn net.Conn) (*flatbuffers.Builder, error) {
+			return plugin.HTTPReqCall(ctx, buf, conn)
 		},
-		util.RPCHTTPRespCall: func(buf []byte, conn net.Conn) (*flatbuffers.Builder, error) {
-			return plugin.HTTPRespCall(buf, conn)
+		util.RPCHTTPRespCall: func(ctx context.Context, buf []byte, conn net.Conn) (*flatbuffers.Builder, error) {
+			return plugin.HTTPRespCall(ctx, buf, conn)
 		},
 	}
 )
@@ -73,16 +145,20 @@ func recoverPanic() {
 	}
 }
 
-func dispatchRPC(ty byte, in []byte, conn net.Conn) (*flatbuffers.Builder, byte) {
+func dispatchRPC(ctx context.Context, ty byte, in []byte, conn net.Conn) (bd *flatbuffers.Builder, respTy byte) {
 	var err error
-	var bd *flatbuffers.Builder
+	start := time.Now()
+	defer func() {
+		metrics.ObserveRPC(ty, start, respTy == util.RPCError)
+	}()
+
 	hl, ok := typeHandlerMap[ty]
 	if !ok {
 		log.Warnf("unknown rpc type: %d", ty)
 		return generateErrorReport(UnknownType{ty}), util.RPCError
 	}
 
-	bd, err = hl(in, conn)
+	bd, err = hl(ctx, in, conn)
 	if err != nil {
 		return generateErrorReport(err), util.RPCError
 	}
@@ -98,10 +174,11 @@ func dispatchRPC(ty byte, in []byte, conn net.Conn) (*flatbuffers.Builder, byte)
 func checkIfDataTooLarge(bd *flatbuffers.Builder) (*flatbuffers.Builder, bool) {
 	out := bd.FinishedBytes()
 	size := len(out)
-	if size < util.MaxDataSize {
+	if size <= util.MaxDataSize {
 		return bd, true
 	}
 
+	metrics.DataTooLarge()
 	err := fmt.Errorf("the max length of data is %d but got %d", util.MaxDataSize, size)
 	util.PutBuilder(bd)
 	bd = generateErrorReport(err)
@@ -109,58 +186,91 @@ func checkIfDataTooLarge(bd *flatbuffers.Builder) (*flatbuffers.Builder, bool) {
 	return bd, false
 }
 
-func handleConn(c net.Conn) {
+// handleConn serves the RPCs from the conn. The st is used to track the conn during the
+// graceful shutdown, it can be nil.
+func handleConn(ctx context.Context, c net.Conn, st *connState) {
 	defer recoverPanic()
 
 	log.Infof("Client connected (%s)", c.RemoteAddr().Network())
 	defer c.Close()
 
+	metrics.ConnOpened()
+	defer metrics.ConnClosed()
+
+	mem := util.NewConnMemory()
+	rc := newRPCConn(c, mem)
 	header := make([]byte, util.HeaderLen)
 	for {
-		n, err := util.ReadBytes(c, header, util.HeaderLen)
-		if util.ReadErr(n, err, util.HeaderLen) {
+		ty, length, err := util.ReadFrameHeader(c, header)
+		if err != nil {
 			break
 		}
 
-		ty := header[0]
-		// we only use last 3 bytes to store the length, so the first byte is
-		// consider zero
-		header[0] = 0
-		length := binary.BigEndian.Uint32(header)
-
-		log.Infof("receive rpc type: %d data length: %d", ty, length)
+		if err := mem.Acquire(ty, length); err != nil {
+			if !refuseFrame(c, ty, length, err) {
+				break
+			}
+			continue
+		}
 
-		buf := make([]byte, length)
-		n, err = util.ReadBytes(c, buf, int(length))
-		if util.ReadErr(n, err, int(length)) {
+		buf, err := util.ReadFrameData(c, length)
+		if err != nil {
+			observeLimit(err)
 			break
 		}
 
-		bd, respTy := dispatchRPC(ty, buf, c)
-		out := bd.FinishedBytes()
-		size := len(out)
-		binary.BigEndian.PutUint32(header, uint32(size))
-		header[0] = respTy
+		if !st.begin() {
+			util.PutBuf(buf)
+			break
+		}
 
-		n, err = util.WriteBytes(c, header, len(header))
+		bd, respTy := dispatchRPC(ctx, ty, *buf, rc)
+		rc.done()
+		mem.Release(length)
+		releaseFrameData(ty, buf)
+		err = util.WriteFrame(c, respTy, bd.FinishedBytes())
+		util.PutBuilder(bd)
 		if err != nil {
-			util.WriteErr(n, err)
 			break
 		}
 
-		n, err = util.WriteBytes(c, out, size)
-		if err != nil {
-			util.WriteErr(n, err)
+		if !st.end() {
+			log.Infof("server is exiting, close the connection")
 			break
 		}
+	}
+}
 
-		util.PutBuilder(bd)
+// ServeConn serves the RPCs from the conn until it is closed, like the conn accepted by Run.
+// The RPCs are handled concurrently if concurrency is greater than 1. Unlike Run, the caches
+// and the plugins should be initialized by the caller. It is used to serve the conn in process,
+// like in the tests acting as APISIX.
+func ServeConn(ctx context.Context, c net.Conn, concurrency int) {
+	if concurrency > 1 {
+		handleConnConcurrently(ctx, c, nil, concurrency)
+	} else {
+		handleConn(ctx, c, nil)
 	}
 }
 
-func getConfCacheTTL() time.Duration {
+// releaseFrameData puts the RPC's data back to the pool once the RPC is handled.
+// Only the data of HTTPReqCall/HTTPRespCall is released, as the confs parsed from
+// PrepareConf may still refer to its data.
+func releaseFrameData(ty byte, buf *[]byte) {
+	if ty == util.RPCHTTPReqCall || ty == util.RPCHTTPRespCall {
+		util.PutBuf(buf)
+	}
+}
+
+// getConfCacheTTL returns the ttl of the conf cache, according to the configured expire time
+// or the environment variable
+func getConfCacheTTL(expire time.Duration) time.Duration {
 	// ensure the conf cached in the runner expires after the token in APISIX
 	amplificationFactor := 1.2
+	if expire > 0 {
+		return time.Duration(float64(expire) * amplificationFactor)
+	}
+
 	ttl := os.Getenv(ConfCacheTTLEnv)
 	if ttl == "" {
 		return time.Duration(3600*amplificationFactor) * time.Second
@@ -174,17 +284,8 @@ func getConfCacheTTL() time.Duration {
 	return time.Duration(float64(n)*amplificationFactor) * time.Second
 }
 
-func getSockAddr() string {
-	path := os.Getenv(SockAddrEnv)
-	if !strings.HasPrefix(path, "unix:") {
-		log.Errorf("invalid socket address: %s", path)
-		return ""
-	}
-	return path[len("unix:"):]
-}
-
-func Run() {
-	ttl := getConfCacheTTL()
+func Run(opts Options) {
+	ttl := getConfCacheTTL(opts.ConfExpireTime)
 	if ttl == 0 {
 		log.Fatalf("A valid conf cache ttl should be set via environment variable %s",
 			ConfCacheTTLEnv)
@@ -192,40 +293,74 @@ func Run() {
 	log.Warnf("conf cache ttl is %v", ttl)
 
 	plugin.InitConfCache(ttl)
+	plugin.SetStrictConf(opts.StrictConf, opts.PluginStrictConf)
+	plugin.SetPluginSettings(opts.PluginSettings)
+	if opts.TracerProvider != nil {
+		tracing.SetTracerProvider(opts.TracerProvider)
+	}
+	inHTTP.SetBodyBudget(opts.BodyBudget)
+	util.SetFrameTimeouts(opts.ReadTimeout, opts.WriteTimeout)
+	util.SetFrameLimits(opts.MaxFrameSize, opts.MaxConnMemory)
 
-	sockAddr := getSockAddr()
-	if sockAddr == "" {
-		log.Fatalf("A valid socket address should be set via environment variable %s", SockAddrEnv)
+	if err := plugin.InitPlugins(); err != nil {
+		log.Fatalf("%s", err)
 	}
-	log.Warnf("listening to %s", sockAddr)
 
-	// clean up sock file created by others
-	if err := os.RemoveAll(sockAddr); err != nil {
-		log.Fatalf("remove file %s: %s", sockAddr, err)
+	metricsAddr := opts.MetricsAddress
+	if metricsAddr == "" {
+		metricsAddr = os.Getenv(MetricsAddrEnv)
 	}
-	// clean up sock file created by me
-	defer func() {
-		if err := os.RemoveAll(sockAddr); err != nil {
-			log.Errorf("remove file %s: %s", sockAddr, err)
+	if metricsAddr != "" {
+		srv, err := metrics.Serve(metricsAddr)
+		if err != nil {
+			log.Fatalf("listen metrics address %s: %s", metricsAddr, err)
 		}
-	}()
+		log.Warnf("exposing metrics at %s/metrics", metricsAddr)
+		defer srv.Close()
+	}
 
-	l, err := net.Listen("unix", sockAddr)
-	if err != nil {
-		log.Fatalf("listen %s: %s", sockAddr, err)
+	var rec *capture.Recorder
+	if opts.Capture != nil {
+		var err error
+		rec, err = capture.NewRecorder(*opts.Capture)
+		if err != nil {
+			log.Fatalf("create capture file %s: %s", opts.Capture.Path, err)
+		}
+		log.Warnf("capturing frames to %s", opts.Capture.Path)
+		defer rec.Close()
 	}
-	defer l.Close()
 
-	// the default socket permission is 0755, which prevents the 'nobody' worker process
-	// from writing to it if the APISIX is run under root.
-	err = os.Chmod(sockAddr, 0766)
+	addr := getListenAddr(opts.ListenAddress)
+	if addr == nil {
+		log.Fatalf("A valid socket address should be set via environment variable %s", SockAddrEnv)
+	}
+	log.Warnf("listening to %s", addr)
+
+	l, err := listen(addr, opts.TLS)
 	if err != nil {
-		log.Fatalf("can't change mod for file %s: %s", sockAddr, err)
+		log.Fatalf("listen %s: %s", addr, err)
 	}
 
+	if addr.isSockFile() {
+		sockAddr := addr.address
+		// clean up sock file created by me
+		defer func() {
+			if err := os.RemoveAll(sockAddr); err != nil {
+				log.Errorf("remove file %s: %s", sockAddr, err)
+			}
+		}()
+	}
+	defer l.Close()
+
+	// the base context of all requests, it is canceled when the drain is timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conns := newConnTracker()
 	done := make(chan struct{})
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
 
 	go func() {
 		for {
@@ -235,6 +370,9 @@ func Run() {
 			case <-done:
 				// don't report the "use of closed network connection" error when the server
 				// is exiting.
+				if conn != nil {
+					conn.Close()
+				}
 				return
 			default:
 			}
@@ -244,11 +382,53 @@ func Run() {
 				continue
 			}
 
-			go handleConn(conn)
+			if rec != nil {
+				conn = rec.Wrap(conn)
+			}
+			st := conns.track(conn)
+			if st == nil {
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer conns.untrack(st)
+				if opts.Concurrency > 1 {
+					handleConnConcurrently(ctx, conn, st, opts.Concurrency)
+				} else {
+					handleConn(ctx, conn, st)
+				}
+			}()
 		}
 	}()
 
-	sig := <-quit
-	log.Warnf("server receive %s and exit", sig.String())
+	select {
+	case sig := <-quit:
+		log.Warnf("server receive %s and exit", sig.String())
+	case <-opts.Stop:
+		log.Warnf("server is stopped and exit")
+	}
 	close(done)
+	// stop accepting new connections
+	l.Close()
+
+	timeout := opts.DrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	conns.drain()
+	if !conns.wait(timeout) {
+		cancel()
+		n := conns.closeAll()
+		log.Warnf("failed to drain connections in %v, cancel %d in-flight RPC(s)", timeout, n)
+
+		// the filters ignoring the ctx may still use the confs and the plugins
+		if !conns.wait(closeTimeout) {
+			log.Warnf("connections are not finished in %v after being closed, "+
+				"skip releasing the confs and closing the plugins", closeTimeout)
+			return
+		}
+	}
+
+	plugin.CloseConfCache()
+	plugin.ClosePlugins()
 }
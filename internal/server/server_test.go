@@ -19,9 +19,14 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"regexp"
+	"strconv"
 	"syscall"
 	"testing"
 	"time"
@@ -29,31 +34,41 @@ import (
 	hrc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/apache/apisix-go-plugin-runner/internal/metrics"
 	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
 	"github.com/apache/apisix-go-plugin-runner/internal/util"
 )
 
 func TestGetSockAddr(t *testing.T) {
 	os.Unsetenv(SockAddrEnv)
-	assert.Equal(t, "", getSockAddr())
+	assert.Nil(t, getListenAddr(""))
 
 	os.Setenv(SockAddrEnv, "unix:/tmp/x.sock")
-	assert.Equal(t, "/tmp/x.sock", getSockAddr())
+	addr := getListenAddr("")
+	assert.Equal(t, "/tmp/x.sock", addr.address)
+	assert.True(t, addr.isSockFile())
+
+	// the configured address takes precedence
+	addr = getListenAddr("tcp://127.0.0.1:9000")
+	assert.Equal(t, "127.0.0.1:9000", addr.address)
+	os.Unsetenv(SockAddrEnv)
 }
 
 func TestGetConfCacheTTL(t *testing.T) {
 	os.Unsetenv(ConfCacheTTLEnv)
-	assert.Equal(t, 4320*time.Second, getConfCacheTTL())
+	assert.Equal(t, 4320*time.Second, getConfCacheTTL(0))
 
 	os.Setenv(ConfCacheTTLEnv, "12")
-	assert.Equal(t, 14*time.Second, getConfCacheTTL())
+	assert.Equal(t, 14*time.Second, getConfCacheTTL(0))
+
+	assert.Equal(t, 120*time.Second, getConfCacheTTL(100*time.Second))
 
 	os.Setenv(ConfCacheTTLEnv, "1a")
-	assert.Equal(t, time.Duration(0), getConfCacheTTL())
+	assert.Equal(t, time.Duration(0), getConfCacheTTL(0))
 }
 
 func TestDispatchRPC_UnknownType(t *testing.T) {
-	bd, ty := dispatchRPC(126, []byte(""), nil)
+	bd, ty := dispatchRPC(context.Background(), 126, []byte(""), nil)
 	err := UnknownType{126}
 	expectBd := ReportError(err)
 	assert.Equal(t, expectBd.FinishedBytes(), bd.FinishedBytes())
@@ -67,7 +82,7 @@ func TestDispatchRPC_KnownType(t *testing.T) {
 	r := hrc.ReqEnd(bd)
 	bd.Finish(r)
 
-	_, ty := dispatchRPC(util.RPCHTTPReqCall, bd.FinishedBytes(), nil)
+	_, ty := dispatchRPC(context.Background(), util.RPCHTTPReqCall, bd.FinishedBytes(), nil)
 	assert.Equal(t, ty, byte(util.RPCError))
 }
 
@@ -86,6 +101,38 @@ func TestDispatchRPC_OutTooLarge(t *testing.T) {
 	builder.Finish(res)
 }
 
+// scrapeCounter returns the value of the counter exposed at /metrics
+func scrapeCounter(t *testing.T, name string) float64 {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	m := regexp.MustCompile(`(?m)^` + name + ` (\S+)$`).FindStringSubmatch(w.Body.String())
+	if !assert.NotNil(t, m, "counter %s not found", name) {
+		return 0
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	assert.Nil(t, err)
+	return v
+}
+
+func TestCheckIfDataTooLarge_Metrics(t *testing.T) {
+	builder := util.GetBuilder()
+	bodyVec := builder.CreateByteVector(make([]byte, util.MaxDataSize+1))
+	hrc.StopStart(builder)
+	hrc.StopAddBody(builder, bodyVec)
+	stop := hrc.StopEnd(builder)
+
+	hrc.RespStart(builder)
+	hrc.RespAddId(builder, 1)
+	hrc.RespAddActionType(builder, hrc.ActionStop)
+	hrc.RespAddAction(builder, stop)
+	builder.Finish(hrc.RespEnd(builder))
+
+	before := scrapeCounter(t, "apisix_go_runner_data_too_large_total")
+	_, ok := checkIfDataTooLarge(builder)
+	assert.False(t, ok)
+	assert.Equal(t, before+1, scrapeCounter(t, "apisix_go_runner_data_too_large_total"))
+}
+
 func TestRun(t *testing.T) {
 	path := "/tmp/x.sock"
 	addr := "unix:" + path
@@ -93,7 +140,7 @@ func TestRun(t *testing.T) {
 	os.Setenv(ConfCacheTTLEnv, "60")
 
 	go func() {
-		Run()
+		Run(Options{})
 	}()
 
 	time.Sleep(100 * time.Millisecond)
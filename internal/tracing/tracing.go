@@ -0,0 +1,79 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tracing creates the OpenTelemetry spans of the runner. The spans are dropped
+// unless a TracerProvider is set.
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/apache/apisix-go-plugin-runner"
+
+// the attributes of the spans
+const (
+	PluginNameKey    = attribute.Key("apisix.plugin.name")
+	ShortCircuitKey  = attribute.Key("apisix.plugin.short_circuit")
+	RequestIDKey     = attribute.Key("apisix.request.id")
+	ConfTokenKey     = attribute.Key("apisix.conf.token")
+	ExtraInfoTypeKey = attribute.Key("apisix.extra_info.type")
+)
+
+var (
+	lock   sync.RWMutex
+	tracer = trace.NewNoopTracerProvider().Tracer(instrumentationName)
+
+	propagator = propagation.TraceContext{}
+)
+
+// SetTracerProvider sets the provider used to create the spans.
+// This method should be called before calling `server.Run`.
+func SetTracerProvider(tp trace.TracerProvider) {
+	lock.Lock()
+	defer lock.Unlock()
+	tracer = tp.Tracer(instrumentationName)
+}
+
+// Start creates a span and a context containing it
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	lock.RLock()
+	t := tracer
+	lock.RUnlock()
+	return t.Start(ctx, name, opts...)
+}
+
+// Extract returns a copy of the ctx with the trace context from the `traceparent`
+// and `tracestate` headers in the carrier
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return propagator.Extract(ctx, carrier)
+}
+
+// EndWithError marks the span as failed if err is not nil, and then ends it
+func EndWithError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,183 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+)
+
+// The frame is a header of HeaderLen bytes followed by the data. The first byte of the
+// header is the RPC type, and the last 3 bytes are the length of the data in big endian.
+// All the framing is done here, and the read/write errors are logged, so that the callers
+// only need to stop using the conn when an error is returned.
+
+// maxPooledBufSize is the max capacity of the buffer put back to the pool, so that an
+// occasional large frame doesn't pin its memory
+const maxPooledBufSize = 64 << 10
+
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
+}
+
+// GetBuf returns a buffer of n bytes from the pool
+func GetBuf(n int) *[]byte {
+	b := bufPool.Get().(*[]byte)
+	if cap(*b) < n {
+		*b = make([]byte, n)
+	}
+	*b = (*b)[:n]
+	return b
+}
+
+// PutBuf puts the buffer back to the pool. The buffer can't be used after it.
+func PutBuf(b *[]byte) {
+	if cap(*b) > maxPooledBufSize {
+		return
+	}
+	*b = (*b)[:0]
+	bufPool.Put(b)
+}
+
+// ReadFrameHeader reads the frame header from c into the header, and returns the type and
+// the length of the data. The length is checked against MaxDataSize, but the configured limits
+// should be checked via CheckFrameSize or ConnMemory before reading the data.
+// It waits for the frame without timeout, as it is used to wait for the next RPC.
+func ReadFrameHeader(c net.Conn, header []byte) (ty byte, length int, err error) {
+	n, err := ReadBytes(c, header[:HeaderLen], HeaderLen)
+	if ReadErr(n, err, HeaderLen) {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+
+	ty = header[0]
+	// we only use last 3 bytes to store the length, so the first byte is
+	// consider zero
+	header[0] = 0
+	length = int(binary.BigEndian.Uint32(header))
+
+	log.Infof("receive rpc type: %d data length: %d", ty, length)
+
+	if length > MaxDataSize {
+		err = fmt.Errorf("the max length of data is %d but got %d", MaxDataSize, length)
+		log.Errorf("read: %s", err)
+		return 0, 0, err
+	}
+	return ty, length, nil
+}
+
+// AwaitFrameHeader is like ReadFrameHeader, but the frame is expected from the peer,
+// like the extra info response, so it should arrive before the read timeout
+func AwaitFrameHeader(c net.Conn, header []byte) (ty byte, length int, err error) {
+	err = withDeadline(c, "read", ReadTimeout(), func() error {
+		var e error
+		ty, length, e = ReadFrameHeader(c, header)
+		return e
+	})
+	return ty, length, err
+}
+
+// ReadFrameData reads the data of the frame into a buffer from the pool. The buffer should
+// be released via PutBuf once the data is not referred anymore.
+func ReadFrameData(c net.Conn, length int) (*[]byte, error) {
+	buf := GetBuf(length)
+	if err := readFull(c, *buf); err != nil {
+		PutBuf(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// ReadFrameDataNoPool is like ReadFrameData, but the data is allocated, so that it can be
+// kept after the RPC, like the extra info response returned to the plugin.
+func ReadFrameDataNoPool(c net.Conn, length int) ([]byte, error) {
+	buf := make([]byte, length)
+	if err := readFull(c, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readFull reads the whole buf before the read timeout, as the data follows the header
+func readFull(c net.Conn, buf []byte) error {
+	return withDeadline(c, "read", ReadTimeout(), func() error {
+		n, err := ReadBytes(c, buf, len(buf))
+		if ReadErr(n, err, len(buf)) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// DiscardFrameData drops the data of the frame, so that the next frame can be read
+func DiscardFrameData(c net.Conn, length int) error {
+	err := withDeadline(c, "read", ReadTimeout(), func() error {
+		_, err := io.CopyN(io.Discard, c, int64(length))
+		return err
+	})
+	if err != nil {
+		log.Errorf("read: failed to drop %d bytes: %s", length, err)
+		return err
+	}
+	return nil
+}
+
+// WriteFrame writes the frame with a single write, as the header and data are
+// copied into one buffer from the pool. The write should be done before the write timeout.
+func WriteFrame(c net.Conn, ty byte, data []byte) error {
+	size := len(data)
+	if size > MaxDataSize {
+		err := fmt.Errorf("the max length of data is %d but got %d", MaxDataSize, size)
+		log.Errorf("write: %s", err)
+		return err
+	}
+
+	buf := GetBuf(HeaderLen + size)
+	b := *buf
+	binary.BigEndian.PutUint32(b, uint32(size))
+	b[0] = ty
+	copy(b[HeaderLen:], data)
+
+	var n int
+	err := withDeadline(c, "write", time.Duration(atomic.LoadInt64(&writeTimeout)), func() error {
+		var e error
+		n, e = WriteBytes(c, b, len(b))
+		return e
+	})
+	PutBuf(buf)
+	if err != nil {
+		WriteErr(n, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadAndWriteFrame(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	defer sc.Close()
+
+	data := bytes.Repeat([]byte("a"), 2000)
+	go func() {
+		assert.NoError(t, WriteFrame(cc, RPCHTTPReqCall, data))
+		assert.NoError(t, WriteFrame(cc, RPCExtraInfo, []byte("dropped")))
+		assert.NoError(t, WriteFrame(cc, RPCPrepareConf, nil))
+	}()
+
+	header := make([]byte, HeaderLen)
+	ty, length, err := ReadFrameHeader(sc, header)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(RPCHTTPReqCall), ty)
+	assert.Equal(t, len(data), length)
+	buf, err := ReadFrameData(sc, length)
+	assert.NoError(t, err)
+	assert.Equal(t, data, *buf)
+	PutBuf(buf)
+
+	ty, length, err = ReadFrameHeader(sc, header)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(RPCExtraInfo), ty)
+	assert.NoError(t, DiscardFrameData(sc, length))
+
+	ty, length, err = ReadFrameHeader(sc, header)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(RPCPrepareConf), ty)
+	assert.Equal(t, 0, length)
+	out, err := ReadFrameDataNoPool(sc, length)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(out))
+}
+
+func TestReadFrame_Truncated(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer sc.Close()
+
+	go func() {
+		cc.Write([]byte{RPCHTTPReqCall, 0, 0, 10})
+		cc.Write([]byte("abc"))
+		cc.Close()
+	}()
+
+	ty, length, err := ReadFrameHeader(sc, make([]byte, HeaderLen))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(RPCHTTPReqCall), ty)
+	assert.Equal(t, 10, length)
+	_, err = ReadFrameData(sc, length)
+	assert.Error(t, err)
+}
+
+func TestWriteFrame_TooLarge(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	defer sc.Close()
+
+	// nothing is written, otherwise the write blocks as the pipe isn't read
+	err := WriteFrame(cc, RPCHTTPReqCall, make([]byte, MaxDataSize+1))
+	assert.Error(t, err)
+}
+
+func TestGetBuf(t *testing.T) {
+	b := GetBuf(10)
+	assert.Equal(t, 10, len(*b))
+	PutBuf(b)
+
+	b = GetBuf(maxPooledBufSize + 1)
+	assert.Equal(t, maxPooledBufSize+1, len(*b))
+	PutBuf(b)
+	assert.Equal(t, maxPooledBufSize+1, len(*b), "the large buffer is not pooled")
+}
+
+func TestConnMemory(t *testing.T) {
+	SetFrameLimits(10, 15)
+	defer SetFrameLimits(0, 0)
+
+	m := NewConnMemory()
+	err := m.Acquire(RPCHTTPReqCall, 11)
+	assert.Equal(t, &LimitError{Scope: FrameScope, Type: RPCHTTPReqCall, Size: 11, Limit: 10}, err)
+
+	assert.NoError(t, m.Acquire(RPCHTTPReqCall, 10))
+	err = m.Acquire(RPCExtraInfo, 6)
+	assert.Equal(t, &LimitError{Scope: ConnScope, Type: RPCExtraInfo, Size: 6, Limit: 15}, err)
+	assert.Equal(t, 10, m.Used())
+
+	m.Release(10)
+	assert.NoError(t, m.Acquire(RPCExtraInfo, 6))
+	assert.Equal(t, 6, m.Used())
+}
+
+func TestReadFrame_Timeout(t *testing.T) {
+	SetFrameTimeouts(50*time.Millisecond, 50*time.Millisecond)
+	defer SetFrameTimeouts(0, 0)
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	defer sc.Close()
+
+	go cc.Write([]byte{RPCHTTPReqCall, 0, 0, 10, 'a'})
+
+	header := make([]byte, HeaderLen)
+	_, length, err := ReadFrameHeader(sc, header)
+	assert.NoError(t, err)
+	_, err = ReadFrameData(sc, length)
+	assert.Equal(t, &TimeoutError{Op: "read", Limit: 50 * time.Millisecond}, err)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// the peer doesn't read
+	err = WriteFrame(sc, RPCHTTPReqCall, []byte("abc"))
+	assert.Equal(t, &TimeoutError{Op: "write", Limit: 50 * time.Millisecond}, err)
+
+	_, _, err = AwaitFrameHeader(sc, header)
+	assert.Equal(t, &TimeoutError{Op: "read", Limit: 50 * time.Millisecond}, err)
+}
+
+// readFrames decodes the frames like the server, until the conn is broken
+func readFrames(c net.Conn) {
+	header := make([]byte, HeaderLen)
+	for {
+		ty, length, err := ReadFrameHeader(c, header)
+		if err != nil {
+			return
+		}
+		if CheckFrameSize(ty, length) != nil {
+			if DiscardFrameData(c, length) != nil {
+				return
+			}
+			continue
+		}
+		buf, err := ReadFrameData(c, length)
+		if err != nil {
+			return
+		}
+		PutBuf(buf)
+	}
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add([]byte{RPCHTTPReqCall, 0, 0, 3, 'a', 'b', 'c'})
+	f.Add([]byte{RPCHTTPReqCall, 0, 0, 3, 'a'})
+	f.Add([]byte{RPCHTTPReqCall, 0xff, 0xff, 0xff, 'a'})
+	f.Add([]byte{RPCPrepareConf, 0, 0, 0, RPCHTTPReqCall, 0, 2})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		SetFrameLimits(64, 0)
+		defer SetFrameLimits(0, 0)
+		SetFrameTimeouts(time.Second, time.Second)
+		defer SetFrameTimeouts(0, 0)
+
+		cc, sc := net.Pipe()
+		defer sc.Close()
+		go func() {
+			cc.Write(data)
+			cc.Close()
+		}()
+		readFrames(sc)
+	})
+}
@@ -0,0 +1,174 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// The scopes of LimitError
+const (
+	FrameScope = "frame"
+	ConnScope  = "connection"
+)
+
+var (
+	readTimeout   int64
+	writeTimeout  int64
+	maxFrameSize  int64
+	maxConnMemory int64
+)
+
+// SetFrameTimeouts sets the max time to read the frame expected from the peer, and to write
+// a frame. The wait for the next RPC is not limited, as the idle conn is kept by APISIX.
+// Zero means no limit.
+func SetFrameTimeouts(read, write time.Duration) {
+	atomic.StoreInt64(&readTimeout, int64(read))
+	atomic.StoreInt64(&writeTimeout, int64(write))
+}
+
+// ReadTimeout returns the max time to read the frame expected from the peer, zero means no limit
+func ReadTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&readTimeout))
+}
+
+// SetFrameLimits sets the max length of a frame's data, and the max bytes of the frames held
+// by a connection at the same time. Zero means no limit except the one of the protocol.
+func SetFrameLimits(frame, conn int) {
+	atomic.StoreInt64(&maxFrameSize, int64(frame))
+	atomic.StoreInt64(&maxConnMemory, int64(conn))
+}
+
+// LimitError is returned when a frame is refused as it exceeds the memory limit.
+// The frame's data is dropped without being read into the memory.
+type LimitError struct {
+	// Scope is FrameScope or ConnScope
+	Scope string
+	// Type is the RPC type of the frame
+	Type byte
+	// Size is the length of the frame's data
+	Size int
+	// Limit is the limit broken by the frame, in bytes
+	Limit int
+}
+
+func (err *LimitError) Error() string {
+	return fmt.Sprintf("the %s memory limit is %d bytes, but the frame of rpc type %d needs %d bytes",
+		err.Scope, err.Limit, err.Type, err.Size)
+}
+
+// TimeoutError is returned when a frame isn't read or written in time
+type TimeoutError struct {
+	// Op is "read" or "write"
+	Op string
+	// Limit is the timeout broken by the frame
+	Limit time.Duration
+}
+
+func (err *TimeoutError) Error() string {
+	return fmt.Sprintf("%s frame: timeout after %v", err.Op, err.Limit)
+}
+
+func (err *TimeoutError) Timeout() bool {
+	return true
+}
+
+func (err *TimeoutError) Unwrap() error {
+	return os.ErrDeadlineExceeded
+}
+
+// CheckFrameSize checks the length of the frame's data against the per-frame limit
+func CheckFrameSize(ty byte, length int) error {
+	limit := int(atomic.LoadInt64(&maxFrameSize))
+	if limit > 0 && length > limit {
+		return &LimitError{Scope: FrameScope, Type: ty, Size: length, Limit: limit}
+	}
+	return nil
+}
+
+// ConnMemory counts the bytes of the frames held by a connection, including the in-flight RPCs
+// and the extra info responses they get
+type ConnMemory struct {
+	used  int64
+	limit int64
+}
+
+// NewConnMemory returns a ConnMemory with the per-connection limit set by SetFrameLimits
+func NewConnMemory() *ConnMemory {
+	return &ConnMemory{limit: atomic.LoadInt64(&maxConnMemory)}
+}
+
+// Acquire reserves n bytes for the frame, or returns a LimitError if the frame exceeds
+// any limit. The reserved bytes should be released via Release.
+func (m *ConnMemory) Acquire(ty byte, n int) error {
+	if err := CheckFrameSize(ty, n); err != nil {
+		return err
+	}
+	if m == nil || m.limit <= 0 {
+		return nil
+	}
+
+	used := atomic.AddInt64(&m.used, int64(n))
+	if used > m.limit {
+		atomic.AddInt64(&m.used, -int64(n))
+		return &LimitError{Scope: ConnScope, Type: ty, Size: n, Limit: int(m.limit)}
+	}
+	return nil
+}
+
+// Release gives back the bytes reserved by Acquire
+func (m *ConnMemory) Release(n int) {
+	if m == nil || m.limit <= 0 {
+		return
+	}
+	atomic.AddInt64(&m.used, -int64(n))
+}
+
+// Used returns the bytes reserved
+func (m *ConnMemory) Used() int {
+	return int(atomic.LoadInt64(&m.used))
+}
+
+// withDeadline runs the op on c with the deadline set by the timeout, and converts the
+// timeout error to TimeoutError
+func withDeadline(c net.Conn, op string, timeout time.Duration, f func() error) error {
+	if timeout <= 0 {
+		return f()
+	}
+
+	setDeadline := c.SetReadDeadline
+	if op == "write" {
+		setDeadline = c.SetWriteDeadline
+	}
+	if err := setDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	err := f()
+	// the deadline should not affect the wait for the next RPC
+	setDeadline(time.Time{})
+
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return &TimeoutError{Op: op, Limit: timeout}
+	}
+	return err
+}
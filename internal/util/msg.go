@@ -28,8 +28,9 @@ import (
 )
 
 const (
-	HeaderLen   = 4
-	MaxDataSize = 2<<24 - 1
+	HeaderLen = 4
+	// MaxDataSize is the max length of data which can be stored in the last 3 bytes of the header
+	MaxDataSize = 1<<24 - 1
 )
 
 const (
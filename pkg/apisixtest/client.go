@@ -0,0 +1,236 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package apisixtest acts as APISIX in the tests of the plugins. It speaks the ext-plugin
+// protocol to the runner over a unix socket: the RPCs are sent like APISIX does, the extra info
+// requests are answered from the vars and bodies given by the test, and the responses are
+// decoded into the structs of pkg/httptest.
+package apisixtest
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	A6Err "github.com/api7/ext-plugin-proto/go/A6/Err"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/apisix"
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+	"github.com/apache/apisix-go-plugin-runner/pkg/httptest"
+)
+
+// Request is the request received by APISIX, which is sent via HTTPReqCall
+type Request struct {
+	// ID is the request id, which should be the same in the Response of the request
+	ID uint32
+	// ConfToken is the token returned by PrepareConf
+	ConfToken uint32
+	// SrcIP is the client's IP, default to 127.0.0.1
+	SrcIP net.IP
+	// Method is the HTTP method, default to GET
+	Method string
+	// Path is the path without the query string, default to `/`
+	Path   string
+	Header http.Header
+	Args   url.Values
+	// Vars answers the extra info requests of the Nginx variables. The variable not found
+	// is answered as empty, like APISIX does.
+	Vars map[string]string
+	// Body answers the extra info request of the request body
+	Body []byte
+}
+
+// Response is the response from the upstream, which is sent via HTTPRespCall
+type Response struct {
+	// ID is the id of the request
+	ID uint32
+	// ConfToken is the token returned by PrepareConf
+	ConfToken uint32
+	// Status is the status code, default to 200
+	Status int
+	Header http.Header
+	// Vars answers the extra info requests of the Nginx variables, like Request.Vars
+	Vars map[string]string
+	// Body answers the extra info request of the response body
+	Body []byte
+}
+
+// Error is the error reported by the runner instead of the response to the RPC
+type Error struct {
+	Code A6Err.Code
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("runner reported error: %s", e.Code)
+}
+
+// Client sends the RPCs to the runner like APISIX. It is safe for concurrent use, but the
+// RPCs are sent one by one.
+type Client struct {
+	// Timeout is the max time of a RPC, including the extra info requests answered during it.
+	// Zero means no limit.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	header []byte
+}
+
+// Dial connects to the runner listening to the unix socket at the path
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client sending the RPCs via the conn, like the one connected by Dial
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		conn:   conn,
+		header: make([]byte, util.HeaderLen),
+	}
+}
+
+// Close closes the connection to the runner
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// PrepareConf sends the confs of the plugins, and returns the conf token used by the other
+// RPCs. The key is used by the runner to return the same token for the same confs, and can
+// be empty.
+func (c *Client) PrepareConf(key string, confs ...httptest.Conf) (uint32, error) {
+	entries := make([]apisix.Conf, 0, len(confs))
+	for _, conf := range confs {
+		entries = append(entries, apisix.Conf{Name: conf.Name, Value: conf.Value})
+	}
+
+	buf, err := c.call(util.RPCPrepareConf, apisix.EncodePrepareConf(key, entries), nil)
+	if err != nil {
+		return 0, err
+	}
+	return apisix.DecodePrepareConf(buf), nil
+}
+
+// HTTPReqCall sends the request, and returns the action of the runner
+func (c *Client) HTTPReqCall(r *Request) (*httptest.Result, error) {
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := r.Path
+	if path == "" {
+		path = "/"
+	}
+	srcIP := r.SrcIP
+	if srcIP == nil {
+		srcIP = net.IPv4(127, 0, 0, 1)
+	}
+
+	out := apisix.EncodeReqCall(&apisix.ReqCall{
+		ID:        r.ID,
+		ConfToken: r.ConfToken,
+		SrcIP:     srcIP,
+		Method:    method,
+		Path:      path,
+		Header:    r.Header,
+		Args:      r.Args,
+	})
+	buf, err := c.call(util.RPCHTTPReqCall, out, &apisix.ExtraInfo{
+		Vars:    toBytes(r.Vars),
+		ReqBody: r.Body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return httptest.DecodeResult(buf), nil
+}
+
+// HTTPRespCall sends the response, and returns the changes made by the runner
+func (c *Client) HTTPRespCall(r *Response) (*httptest.RespResult, error) {
+	out := apisix.EncodeRespCall(&apisix.RespCall{
+		ID:        r.ID,
+		ConfToken: r.ConfToken,
+		Status:    r.Status,
+		Header:    r.Header,
+	})
+	buf, err := c.call(util.RPCHTTPRespCall, out, &apisix.ExtraInfo{
+		Vars:     toBytes(r.Vars),
+		RespBody: r.Body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return httptest.DecodeRespResult(buf), nil
+}
+
+// call sends the RPC and answers the extra info requests until the response arrives
+func (c *Client) call(ty byte, out []byte, info *apisix.ExtraInfo) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Timeout > 0 {
+		if err := c.conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+			return nil, err
+		}
+		defer c.conn.SetDeadline(time.Time{})
+	}
+
+	if err := util.WriteFrame(c.conn, ty, out); err != nil {
+		return nil, err
+	}
+
+	for {
+		respTy, length, err := util.ReadFrameHeader(c.conn, c.header)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := util.ReadFrameDataNoPool(c.conn, length)
+		if err != nil {
+			return nil, err
+		}
+
+		switch respTy {
+		case util.RPCExtraInfo:
+			if info == nil {
+				return nil, fmt.Errorf("unexpected extra info request during rpc type %d", ty)
+			}
+			if err := util.WriteFrame(c.conn, util.RPCExtraInfo, info.Answer(buf)); err != nil {
+				return nil, err
+			}
+		case util.RPCError:
+			return nil, Error{Code: A6Err.GetRootAsResp(buf, 0).Code()}
+		case ty:
+			return buf, nil
+		default:
+			return nil, fmt.Errorf("unexpected rpc type %d in response to %d", respTy, ty)
+		}
+	}
+}
+
+func toBytes(vars map[string]string) map[string][]byte {
+	res := make(map[string][]byte, len(vars))
+	for k, v := range vars {
+		res[k] = []byte(v)
+	}
+	return res
+}
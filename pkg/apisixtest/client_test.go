@@ -0,0 +1,128 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package apisixtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	A6Err "github.com/api7/ext-plugin-proto/go/A6/Err"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
+	"github.com/apache/apisix-go-plugin-runner/pkg/httptest"
+)
+
+type echoConf struct {
+	Stop bool `json:"stop"`
+}
+
+func init() {
+	parseConf := func(in []byte) (interface{}, error) {
+		conf := echoConf{}
+		err := json.Unmarshal(in, &conf)
+		return conf, err
+	}
+	reqFilter := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		v, _ := r.Var("remote_user")
+		if conf.(echoConf).Stop {
+			body, _ := r.Body()
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(body)
+			return
+		}
+		r.Header().Set("X-User", string(v))
+		r.Header().Del("X-Del")
+		r.Args().Set("a", "1")
+		r.SetPath([]byte("/echo"))
+	}
+	respFilter := func(conf interface{}, w pkgHTTP.Response) {
+		v, _ := w.Var("upstream_addr")
+		body, _ := w.ReadBody()
+		w.Header().Set("X-Upstream", string(v))
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(append(body, '!'))
+	}
+	plugin.RegisterPlugin("apisixtest-echo", parseConf, reqFilter, respFilter)
+}
+
+func TestClient(t *testing.T) {
+	s, err := NewServer(ServerOptions{})
+	assert.Nil(t, err)
+	defer s.Close()
+
+	c, err := s.Dial()
+	assert.Nil(t, err)
+	defer c.Close()
+
+	token, err := c.PrepareConf("",
+		httptest.Conf{Name: "apisixtest-echo", Value: `{}`})
+	assert.Nil(t, err)
+	assert.NotZero(t, token)
+
+	res, err := c.HTTPReqCall(&Request{
+		ID:        1,
+		ConfToken: token,
+		Header:    http.Header{"X-Del": {"1"}},
+		Vars:      map[string]string{"remote_user": "alice"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "rewrite\npath /echo\n+header X-User: alice\n-header X-Del\n+arg a: 1\n",
+		res.String())
+
+	resp, err := c.HTTPRespCall(&Response{
+		ID:        1,
+		ConfToken: token,
+		Vars:      map[string]string{"upstream_addr": "127.0.0.1:1980"},
+		Body:      []byte("hello"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.Status)
+	assert.Equal(t, "127.0.0.1:1980", resp.Header.Get("X-Upstream"))
+	assert.Equal(t, []byte("hello!"), resp.Body)
+
+	token, err = c.PrepareConf("",
+		httptest.Conf{Name: "apisixtest-echo", Value: `{"stop":true}`})
+	assert.Nil(t, err)
+	res, err = c.HTTPReqCall(&Request{ConfToken: token, Body: []byte("denied")})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, res.Stop.Status)
+	assert.Equal(t, []byte("denied"), res.Stop.Body)
+}
+
+func TestClientError(t *testing.T) {
+	s, err := NewServer(ServerOptions{})
+	assert.Nil(t, err)
+	defer s.Close()
+
+	c, err := s.Dial()
+	assert.Nil(t, err)
+	defer c.Close()
+
+	_, err = c.HTTPReqCall(&Request{ConfToken: 12345})
+	assert.Equal(t, Error{Code: A6Err.CodeCONF_TOKEN_NOT_FOUND}, err)
+
+	// the connection is still usable after the error
+	token, err := c.PrepareConf("", httptest.Conf{Name: "apisixtest-echo", Value: `{}`})
+	assert.Nil(t, err)
+	res, err := c.HTTPReqCall(&Request{ConfToken: token})
+	assert.Nil(t, err)
+	assert.NotNil(t, res.Rewrite)
+}
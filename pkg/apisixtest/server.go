@@ -0,0 +1,151 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package apisixtest
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	"github.com/apache/apisix-go-plugin-runner/internal/server"
+)
+
+// ServerOptions controls the behavior of the runner started by NewServer
+type ServerOptions struct {
+	// ConfCacheTTL is the time to keep the confs of PrepareConf, default to one hour
+	ConfCacheTTL time.Duration
+	// Concurrency is the max number of RPCs handled concurrently per connection, like
+	// RunnerConfig.Concurrency
+	Concurrency int
+	// StrictConf makes PrepareConf fail when a plugin is not found or its conf is invalid,
+	// like RunnerConfig.StrictConf
+	StrictConf bool
+}
+
+// Server is a runner serving the registered plugins at a unix socket in process, like the one
+// started by APISIX. As the caches of the runner are global, only one server should be running
+// at the same time.
+type Server struct {
+	// Path is the path of the unix socket
+	Path string
+
+	dir    string
+	l      net.Listener
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewServer initializes the registered plugins and starts serving at a unix socket in a
+// temporary directory. The server should be closed via Close.
+func NewServer(opts ServerOptions) (*Server, error) {
+	ttl := opts.ConfCacheTTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+
+	dir, err := os.MkdirTemp("", "apisixtest")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "runner.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	plugin.InitConfCache(ttl)
+	plugin.SetStrictConf(opts.StrictConf, nil)
+	if err := plugin.InitPlugins(); err != nil {
+		l.Close()
+		os.RemoveAll(dir)
+		plugin.CloseConfCache()
+		return nil, err
+	}
+
+	s := &Server{
+		Path:  path,
+		dir:   dir,
+		l:     l,
+		conns: map[net.Conn]struct{}{},
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go s.serve(opts.Concurrency)
+	return s, nil
+}
+
+func (s *Server) serve(concurrency int) {
+	defer s.wg.Done()
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			server.ServeConn(s.ctx, conn, concurrency)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Dial returns a client connected to the server
+func (s *Server) Dial() (*Client, error) {
+	return Dial(s.Path)
+}
+
+// Close stops the server, closes the connections and the plugins, and removes the socket.
+// The contexts of the in-flight RPCs are canceled, and the connections are closed once
+// the RPCs are done.
+func (s *Server) Close() {
+	s.cancel()
+	s.l.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		// the runner sees EOF like the connection closed by APISIX
+		if uc, ok := conn.(*net.UnixConn); ok {
+			uc.CloseRead()
+		} else {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+
+	plugin.CloseConfCache()
+	plugin.ClosePlugins()
+	os.RemoveAll(s.dir)
+}
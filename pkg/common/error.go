@@ -21,4 +21,8 @@ import "errors"
 
 var (
 	ErrConnClosed = errors.New("The connection is closed")
+	// ErrUnsupportedContentType is returned when the body can't be decoded with its Content-Type
+	ErrUnsupportedContentType = errors.New("The content type is not supported")
+	// ErrBodyTooLarge is returned when the body read from or written to APISIX exceeds the budget
+	ErrBodyTooLarge = errors.New("The body exceeds the budget")
 )
@@ -19,6 +19,8 @@ package http
 
 import (
 	"context"
+	"io"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
@@ -41,6 +43,7 @@ type Request interface {
 	// SrcIP returns the client's IP
 	SrcIP() net.IP
 	// Method returns the HTTP method (GET, POST, PUT, etc.)
+	// The method can't be rewritten, as the Rewrite action of the protocol doesn't carry it
 	Method() string
 	// Path returns the path part of the client's URI (without query string and the other parts)
 	// It won't be equal to the one in the Request-Line sent by the client if it has
@@ -48,11 +51,35 @@ type Request interface {
 	Path() []byte
 	// SetPath is the setter for Path
 	SetPath([]byte)
+	// Host returns the host of the request. It is the `Host` header set via SetHost,
+	// or the Nginx variable `host`
+	Host() (string, error)
+	// SetHost rewrites the `Host` header sent to the upstream
+	SetHost(host string)
+	// Scheme returns the scheme of the request, like `http` or `https`. It comes from the
+	// Nginx variable `scheme` and can't be rewritten, as the protocol doesn't carry it
+	Scheme() (string, error)
+	// RawURI returns the URI including the query string. It is the Nginx variable `request_uri`,
+	// or is composed of Path and Args if any of them is modified
+	RawURI() (string, error)
+	// SetRawURI rewrites the path and replaces the query string with the ones in the uri
+	SetRawURI(uri string) error
 	// Header returns the HTTP headers
 	Header() Header
 	// Args returns the query string
 	Args() url.Values
 
+	// Cookies parses and returns the cookies sent with the request
+	Cookies() []*http.Cookie
+	// Cookie returns the named cookie sent with the request or
+	// http.ErrNoCookie if not found
+	Cookie(name string) (*http.Cookie, error)
+	// AddCookie adds a cookie to the request. Only the Name and Value of c are used,
+	// and the `Cookie` headers are merged into one
+	AddCookie(c *http.Cookie)
+	// DelCookie removes the named cookie from the request. The other cookies are kept as they are
+	DelCookie(name string)
+
 	// Var returns the value of a Nginx variable, like `r.Var("request_time")`
 	//
 	// To fetch the value, the runner will look up the request's cache first. If not found,
@@ -70,13 +97,49 @@ type Request interface {
 	// SetBody rewrites the original request body
 	SetBody([]byte)
 
+	// BodyReader returns a reader of the request body.
+	//
+	// As the protocol doesn't support fetching the body in chunks, the whole body is still
+	// fetched by Body. The body beyond the budget set via `RunnerConfig.BodyBudget` is
+	// dropped without being read into the memory, and an error wrapping
+	// pkg/common.ErrBodyTooLarge is returned.
+	BodyReader() (io.Reader, error)
+	// BodyWriter returns a writer to rewrite the request body, which is replaced by
+	// what is written. Writing beyond the budget fails with pkg/common.ErrBodyTooLarge.
+	BodyWriter() io.Writer
+
+	// Form parses the body according to the Content-Type, which should be
+	// `application/x-www-form-urlencoded` or `multipart/form-data`.
+	// For the multipart form, only the values of the non-file parts are returned.
+	// If the Content-Type is not supported, an error wrapping
+	// pkg/common.ErrUnsupportedContentType is returned.
+	Form() (url.Values, error)
+	// MultipartReader returns a reader of the `multipart/*` body
+	MultipartReader() (*multipart.Reader, error)
+	// DecodeJSON decodes the `application/json` or `*/*+json` body into v
+	DecodeJSON(v interface{}) error
+	// SetForm rewrites the body with the encoded form. Like the other setters below,
+	// the Content-Type and Content-Length headers are rewritten to match the new body
+	SetForm(form url.Values)
+	// SetMultipartForm rewrites the body with the multipart form, which is usually from
+	// MultipartReader().ReadForm
+	SetMultipartForm(form *multipart.Form) error
+	// SetJSON rewrites the body with v encoded in JSON
+	SetJSON(v interface{}) error
+
 	// Context returns the request's context.
 	//
 	// The returned context is always non-nil; it defaults to the
 	// background context.
 	//
 	// For run plugin, the context controls cancellation.
+	// It also carries the span of the running plugin when the tracing is enabled,
+	// so that the plugin can create its own spans under it.
 	Context() context.Context
+
+	// Ctx returns the key/value store shared by the plugins handling the request.
+	Ctx() Store
+
 	// RespHeader returns an http.Header which allows you to add or set response headers before reaching the upstream.
 	// Some built-in headers would not take effect, like `connection`,`content-length`,`transfer-encoding`,`location,server`,`www-authenticate`,`content-encoding`,`content-type`,`content-location` and `content-language`
 	RespHeader() http.Header
@@ -115,7 +178,12 @@ type Response interface {
 	// because `Body` was already occupied in earlier interface implementations.
 	ReadBody() ([]byte, error)
 
+	// BodyReader returns a reader of the origin response body. Like Request.BodyReader,
+	// the whole body is fetched and is limited by the budget.
+	BodyReader() (io.Reader, error)
+
 	// Write rewrites the origin response data.
+	// Writing beyond the budget fails with pkg/common.ErrBodyTooLarge.
 	//
 	// Unlike `ResponseWriter.Write`, we don't need to WriteHeader(http.StatusOK)
 	// before writing the data
@@ -126,6 +194,21 @@ type Response interface {
 	//
 	// WriteHeader can't override written status.
 	WriteHeader(statusCode int)
+
+	// Ctx returns the key/value store shared by the plugins handling the response.
+	// It doesn't contain what is stored by the request filters, as APISIX doesn't send
+	// an id which identifies the request across its workers.
+	Ctx() Store
+
+	// Context returns the response's context. Like Request.Context, it is always non-nil,
+	// controls the cancellation, including the plugin's timeout, and carries the span of
+	// the running plugin.
+	Context() context.Context
+
+	// SetCookie adds a `Set-Cookie` header. The invalid cookie is silently dropped.
+	//
+	// To set cookies in RequestFilter, use http.SetCookie with the http.ResponseWriter.
+	SetCookie(c *http.Cookie)
 }
 
 // Header is like http.Header, but only implements the subset of its methods
@@ -138,15 +221,34 @@ type Header interface {
 	// Del deletes the values associated with key. The key is case insensitive
 	Del(key string)
 
+	// Add adds the value to key. It appends to any existing values associated with key,
+	// including the original ones. The key is case insensitive
+	Add(key, value string)
+
 	// Get gets the first value associated with the given key.
 	// If there are no values associated with the key, Get returns "".
 	// It is case insensitive
 	Get(key string) string
 
+	// Values returns a copy of all values associated with the given key.
+	// If there are no values associated with the key, Values returns nil.
+	// It is case insensitive
+	Values(key string) []string
+
+	// Keys returns the sorted canonical keys of the headers
+	Keys() []string
+
+	// Range calls f for each header in the order of Keys, with a copy of its values.
+	// If f returns false, Range stops the iteration.
+	Range(f func(key string, values []string) bool)
+
+	// Clone returns a copy of the effective headers, which are the original headers
+	// merged with the modifications. Modifying the copy won't change the headers
+	Clone() http.Header
+
 	// View returns the internal structure. It is expected for read operations. Any write operation
 	// won't be recorded
-	//Deprecated: refactoring
+	//Deprecated: use Clone, Keys/Values or Range instead. For the request, it only contains
+	// the modified headers
 	View() http.Header
-
-	// TODO: support Add
 }
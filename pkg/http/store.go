@@ -0,0 +1,58 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+// Store is a key/value store of a request. It is shared by the plugins in the chain, so that
+// a plugin can pass what it decides to the following ones, like the consumer resolved by an
+// authentication plugin.
+type Store interface {
+	// Get returns the value stored under the key
+	Get(key string) (value interface{}, ok bool)
+	// Set stores the value under the key, replacing the existing one
+	Set(key string, value interface{})
+	// Del deletes the value stored under the key
+	Del(key string)
+	// Range calls f for each key and value. If f returns false, Range stops the iteration.
+	Range(f func(key string, value interface{}) bool)
+}
+
+// Key is a typed key of the Store, so that the value can be read without casting.
+// For example:
+//
+//	const ConsumerKey = pkgHTTP.Key[*Consumer]("auth.consumer")
+//
+//	ConsumerKey.Set(r.Ctx(), consumer)
+//	consumer, ok := ConsumerKey.Get(r.Ctx())
+type Key[T any] string
+
+// Get returns the value stored under the key. If the value is not found or is not a T,
+// the zero value and false are returned.
+func (k Key[T]) Get(s Store) (T, bool) {
+	v, ok := s.Get(string(k))
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	t, ok := v.(T)
+	return t, ok
+}
+
+// Set stores the value under the key
+func (k Key[T]) Set(s Store, v T) {
+	s.Set(string(k), v)
+}
@@ -0,0 +1,63 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httptest
+
+import (
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	"github.com/apache/apisix-go-plugin-runner/internal/util"
+)
+
+// Conf is the conf of a plugin in the chain, like the one in the `ext-plugin-*` of APISIX
+type Conf struct {
+	// Name is the name of the registered plugin
+	Name string
+	// Value is the raw conf, usually in JSON
+	Value string
+}
+
+// Chain runs the registered plugins in the order of their confs, like the runner handles
+// the HTTPReqCall: the chain is stopped once a plugin generates the response.
+type Chain struct {
+	conf plugin.RuleConf
+}
+
+// NewChain parses the confs with the plugins' ParseConf, including the validation with the
+// schema. Unlike the runner, it fails if a plugin is not registered or its conf is invalid.
+func NewChain(confs ...Conf) (*Chain, error) {
+	c := &Chain{}
+	for _, conf := range confs {
+		v, err := plugin.ParsePluginConf(conf.Name, []byte(conf.Value))
+		if err != nil {
+			return nil, err
+		}
+		c.conf = append(c.conf, plugin.ConfEntry{Name: conf.Name, Value: v})
+	}
+	return c, nil
+}
+
+// Run runs the chain with the request, and returns the action sent back to APISIX.
+// The request keeps the changes made by the plugins, so it can also be inspected,
+// unless a plugin times out and the request is left to it.
+func (c *Chain) Run(r *Request) (*Result, error) {
+	builder, err := plugin.RunRequestPhase(c.conf, r.req)
+	if err != nil {
+		return nil, err
+	}
+	defer util.PutBuilder(builder)
+	return DecodeResult(builder.FinishedBytes()), nil
+}
@@ -0,0 +1,98 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httptest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
+)
+
+type chainConf struct {
+	Header string `json:"header"`
+	Status int    `json:"status"`
+}
+
+func init() {
+	parseConf := func(in []byte) (interface{}, error) {
+		conf := chainConf{}
+		err := json.Unmarshal(in, &conf)
+		return conf, err
+	}
+	setHeader := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		r.Header().Set(conf.(chainConf).Header, "1")
+	}
+	stop := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		w.Header().Set("X-Stop", "1")
+		w.WriteHeader(conf.(chainConf).Status)
+		w.Write([]byte("stopped"))
+	}
+	noop := func(conf interface{}, w pkgHTTP.Response) {}
+	plugin.RegisterPlugin("chain-set-header", parseConf, setHeader, noop)
+	plugin.RegisterPlugin("chain-stop", parseConf, stop, noop)
+}
+
+func TestChain(t *testing.T) {
+	_, err := NewChain(Conf{Name: "not-found"})
+	assert.NotNil(t, err)
+	_, err = NewChain(Conf{Name: "chain-stop", Value: "{"})
+	assert.NotNil(t, err)
+
+	c, err := NewChain(
+		Conf{Name: "chain-set-header", Value: `{"header":"X-A"}`},
+		Conf{Name: "chain-set-header", Value: `{"header":"X-B"}`},
+	)
+	assert.Nil(t, err)
+	r := NewRequest()
+	res, err := c.Run(r)
+	assert.Nil(t, err)
+	assert.Nil(t, res.Stop)
+	assert.Equal(t, http.Header{"X-A": {"1"}, "X-B": {"1"}}, res.Rewrite.Header)
+	assert.Equal(t, "1", r.Header().Get("X-B"))
+	assert.Equal(t, "rewrite\n+header X-A: 1\n+header X-B: 1\n", res.String())
+
+	c, err = NewChain(
+		Conf{Name: "chain-set-header", Value: `{"header":"X-A"}`},
+		Conf{Name: "chain-stop", Value: `{"status":403}`},
+		Conf{Name: "chain-set-header", Value: `{"header":"X-B"}`},
+	)
+	assert.Nil(t, err)
+	r = NewRequest()
+	res, err = c.Run(r)
+	assert.Nil(t, err)
+	assert.Nil(t, res.Rewrite)
+	assert.Equal(t, &Stop{
+		Status: 403,
+		Header: http.Header{"X-Stop": {"1"}},
+		Body:   []byte("stopped"),
+	}, res.Stop)
+	// the chain is stopped
+	assert.Equal(t, "", r.Header().Get("X-B"))
+	assert.Equal(t, "stop 403\n+header X-Stop: 1\nbody \"stopped\"\n", res.String())
+
+	c, err = NewChain()
+	assert.Nil(t, err)
+	res, err = c.Run(NewRequest())
+	assert.Nil(t, err)
+	assert.Equal(t, "pass\n", res.String())
+}
@@ -19,8 +19,12 @@ package httptest
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"net/http"
+	"sort"
 
+	inHTTP "github.com/apache/apisix-go-plugin-runner/internal/http"
 	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
 )
 
@@ -43,8 +47,12 @@ type ResponseRecorder struct {
 
 	Vars map[string][]byte
 
+	// Store is the key/value store returned by Ctx
+	Store pkgHTTP.Store
+
 	statusCode int
 	id         uint32
+	ctx        context.Context
 }
 
 // NewRecorder returns an initialized ResponseRecorder.
@@ -76,11 +84,10 @@ func (rw *ResponseRecorder) StatusCode() int {
 // Header implements pkgHTTP.Response. It returns the response
 // headers to mutate within a handler.
 func (rw *ResponseRecorder) Header() pkgHTTP.Header {
-	m := rw.HeaderMap
-	if m == nil {
+	if rw.HeaderMap == nil {
 		rw.HeaderMap = newHeader()
 	}
-	return m
+	return rw.HeaderMap
 }
 
 // Write implements pkgHTTP.Response.
@@ -108,6 +115,37 @@ func (rw *ResponseRecorder) ReadBody() ([]byte, error) {
 	return rw.OriginBody, nil
 }
 
+// Ctx implements pkgHTTP.Response.
+func (rw *ResponseRecorder) Ctx() pkgHTTP.Store {
+	if rw.Store == nil {
+		rw.Store = inHTTP.NewStore()
+	}
+	return rw.Store
+}
+
+// Context implements pkgHTTP.Response. It defaults to the background context.
+func (rw *ResponseRecorder) Context() context.Context {
+	if rw.ctx == nil {
+		return context.Background()
+	}
+	return rw.ctx
+}
+
+// SetContext sets the context returned by Context, for example, to test the plugin's
+// behavior when the context is canceled.
+func (rw *ResponseRecorder) SetContext(ctx context.Context) {
+	rw.ctx = ctx
+}
+
+// BodyReader implements pkgHTTP.Response.
+func (rw *ResponseRecorder) BodyReader() (io.Reader, error) {
+	body, err := rw.ReadBody()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(body), nil
+}
+
 // WriteHeader implements pkgHTTP.Response.
 // The statusCode is only allowed to be written once.
 func (rw *ResponseRecorder) WriteHeader(code int) {
@@ -118,6 +156,13 @@ func (rw *ResponseRecorder) WriteHeader(code int) {
 	rw.statusCode = code
 }
 
+// SetCookie implements pkgHTTP.Response.
+func (rw *ResponseRecorder) SetCookie(c *http.Cookie) {
+	if v := c.String(); v != "" {
+		rw.Header().Add("Set-Cookie", v)
+	}
+}
+
 type Header struct {
 	http.Header
 }
@@ -126,6 +171,32 @@ func (h *Header) View() http.Header {
 	return h.Header
 }
 
+// Values returns a copy of the values associated with key
+func (h *Header) Values(key string) []string {
+	vals := h.Header.Values(key)
+	if len(vals) == 0 {
+		return nil
+	}
+	return append([]string(nil), vals...)
+}
+
+func (h *Header) Keys() []string {
+	keys := make([]string, 0, len(h.Header))
+	for k := range h.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (h *Header) Range(f func(key string, values []string) bool) {
+	for _, k := range h.Keys() {
+		if !f(k, h.Values(k)) {
+			return
+		}
+	}
+}
+
 func newHeader() *Header {
 	return &Header{
 		Header: http.Header{},
@@ -0,0 +1,189 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httptest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/apisix"
+	inHTTP "github.com/apache/apisix-go-plugin-runner/internal/http"
+	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
+)
+
+// Request is an implementation of pkgHTTP.Request for tests. It is the request used by the
+// runner, built from the HTTPReqCall sent by APISIX, so it behaves the same. The vars and the
+// body are answered in process instead of being asked from APISIX.
+//
+// The With* builders should be called before passing the request to the plugin, as they
+// rebuild the request and drop the changes made by the plugin.
+type Request struct {
+	pkgHTTP.Request
+
+	req *inHTTP.Request
+
+	id      uint32
+	method  string
+	path    string
+	header  http.Header
+	args    url.Values
+	srcIP   net.IP
+	vars    map[string][]byte
+	body    []byte
+	ctx     context.Context
+	buf     []byte
+	extInfo *extraInfoConn
+}
+
+// NewRequest returns a GET request of the path `/`, from 127.0.0.1.
+func NewRequest() *Request {
+	r := &Request{
+		method: http.MethodGet,
+		path:   "/",
+		header: http.Header{},
+		args:   url.Values{},
+		srcIP:  net.IPv4(127, 0, 0, 1),
+		vars:   map[string][]byte{},
+		ctx:    context.Background(),
+	}
+	r.build()
+	return r
+}
+
+// WithID sets the request id.
+func (r *Request) WithID(id uint32) *Request {
+	r.id = id
+	r.build()
+	return r
+}
+
+// WithMethod sets the HTTP method, like `POST`.
+func (r *Request) WithMethod(method string) *Request {
+	r.method = strings.ToUpper(method)
+	r.build()
+	return r
+}
+
+// WithPath sets the path. The query string in it is parsed as the args.
+func (r *Request) WithPath(path string) *Request {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		if q, err := url.ParseQuery(path[i+1:]); err == nil {
+			for k, vs := range q {
+				r.args[k] = append(r.args[k], vs...)
+			}
+		}
+		path = path[:i]
+	}
+	r.path = path
+	r.build()
+	return r
+}
+
+// WithHeader adds the value to the header.
+func (r *Request) WithHeader(key, value string) *Request {
+	r.header.Add(key, value)
+	r.build()
+	return r
+}
+
+// WithArg adds the value to the query string.
+func (r *Request) WithArg(key, value string) *Request {
+	r.args.Add(key, value)
+	r.build()
+	return r
+}
+
+// WithVar sets the value of the Nginx variable returned by Var. The variable not set
+// is returned as empty, like APISIX does.
+func (r *Request) WithVar(name, value string) *Request {
+	r.vars[name] = []byte(value)
+	r.build()
+	return r
+}
+
+// WithBody sets the body returned by Body.
+func (r *Request) WithBody(body []byte) *Request {
+	r.body = body
+	r.build()
+	return r
+}
+
+// WithSrcIP sets the client's IP.
+func (r *Request) WithSrcIP(ip net.IP) *Request {
+	r.srcIP = ip
+	r.build()
+	return r
+}
+
+// WithContext sets the context which the request's context is derived from.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r.ctx = ctx
+	r.build()
+	return r
+}
+
+// Rewrite returns the changes made to the request, or nil if there is none.
+func (r *Request) Rewrite() *Rewrite {
+	builder := flatbuffers.NewBuilder(1024)
+	if !r.req.FetchChanges(r.id, builder) {
+		return nil
+	}
+	return DecodeResult(builder.FinishedBytes()).Rewrite
+}
+
+// build creates the request from the HTTPReqCall encoded with the fields
+func (r *Request) build() {
+	if r.req != nil {
+		inHTTP.ReuseRequest(r.req)
+	}
+
+	r.buf = apisix.EncodeReqCall(&apisix.ReqCall{
+		ID:     r.id,
+		SrcIP:  r.srcIP,
+		Method: r.method,
+		Path:   r.path,
+		Header: r.header,
+		Args:   r.args,
+	})
+	r.extInfo = &extraInfoConn{info: &apisix.ExtraInfo{Vars: r.vars, ReqBody: r.body}}
+	r.req = inHTTP.CreateRequestWithContext(r.ctx, r.buf)
+	r.req.BindConn(r.extInfo)
+	r.Request = r.req
+}
+
+// extraInfoConn answers the extra info requests in process. Only RoundTripExtraInfo is called
+// by the request, so the embedded conn is nil.
+type extraInfoConn struct {
+	net.Conn
+
+	info *apisix.ExtraInfo
+}
+
+// RoundTripExtraInfo implements the interface used by the request to ask for the extra info
+func (c *extraInfoConn) RoundTripExtraInfo(out []byte, check func(length uint32) error) ([]byte, error) {
+	buf := c.info.Answer(out)
+	if err := check(uint32(len(buf))); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
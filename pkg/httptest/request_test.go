@@ -0,0 +1,88 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httptest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type ctxKey struct{}
+
+func TestNewRequest(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ctxKey{}, "v")
+	r := NewRequest().
+		WithID(1).
+		WithMethod("post").
+		WithPath("/hello?k=v").
+		WithArg("k", "w").
+		WithHeader("Content-Type", "application/json").
+		WithVar("remote_addr", "10.0.0.1").
+		WithBody([]byte(`{"name":"foo"}`)).
+		WithSrcIP(net.ParseIP("10.0.0.1")).
+		WithContext(ctx)
+
+	assert.Equal(t, uint32(1), r.ID())
+	assert.Equal(t, "POST", r.Method())
+	assert.Equal(t, "/hello", string(r.Path()))
+	assert.Equal(t, []string{"v", "w"}, r.Args()["k"])
+	assert.Equal(t, "application/json", r.Header().Get("Content-Type"))
+	assert.Equal(t, "10.0.0.1", r.SrcIP().String())
+	assert.Equal(t, "v", r.Context().Value(ctxKey{}))
+
+	v, err := r.Var("remote_addr")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1", string(v))
+	v, err = r.Var("not_set")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(v))
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	assert.Nil(t, r.DecodeJSON(&body))
+	assert.Equal(t, "foo", body.Name)
+}
+
+func TestRequest_Rewrite(t *testing.T) {
+	assert.Nil(t, NewRequest().Rewrite())
+
+	r := NewRequest().WithHeader("X-Del", "1").WithArg("del", "1")
+	r.SetPath([]byte("/new"))
+	r.Header().Set("X-Set", "a")
+	r.Header().Del("X-Del")
+	r.Args().Set("set", "b")
+	r.Args().Del("del")
+	r.RespHeader().Set("X-Resp", "c")
+	r.SetBody([]byte("body"))
+
+	assert.Equal(t, &Rewrite{
+		Path:       "/new",
+		Header:     http.Header{"X-Set": {"a"}},
+		DelHeader:  []string{"X-Del"},
+		Args:       url.Values{"set": {"b"}},
+		DelArgs:    []string{"del"},
+		RespHeader: http.Header{"X-Resp": {"c"}},
+		Body:       []byte("body"),
+	}, r.Rewrite())
+}
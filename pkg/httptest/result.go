@@ -0,0 +1,217 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httptest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	A6 "github.com/api7/ext-plugin-proto/go/A6"
+	hrc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	hrespc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// Result is the action of the response to HTTPReqCall. At most one of Stop and Rewrite is
+// set, and both are nil if the request is passed to the upstream unchanged.
+type Result struct {
+	Stop    *Stop
+	Rewrite *Rewrite
+}
+
+// Stop is the response generated by a plugin, which is sent to the client without
+// reaching the upstream
+type Stop struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Rewrite is the changes to the request sent to the upstream
+type Rewrite struct {
+	// Path is the new path, or empty if the path is not changed
+	Path string
+	// Header contains the headers set, and DelHeader the names of the deleted ones
+	Header    http.Header
+	DelHeader []string
+	// Args contains the args set, and DelArgs the names of the deleted ones
+	Args    url.Values
+	DelArgs []string
+	// RespHeader contains the headers set to the response from the upstream
+	RespHeader http.Header
+	// Body is the new body, or nil if the body is not changed
+	Body []byte
+}
+
+// DecodeResult decodes the response to HTTPReqCall
+func DecodeResult(buf []byte) *Result {
+	resp := hrc.GetRootAsResp(buf, 0)
+	tab := &flatbuffers.Table{}
+	if !resp.Action(tab) {
+		return &Result{}
+	}
+
+	switch resp.ActionType() {
+	case hrc.ActionStop:
+		stop := &hrc.Stop{}
+		stop.Init(tab.Bytes, tab.Pos)
+		header, _ := decodeTextEntries(stop.HeadersLength(), stop.Headers)
+		return &Result{Stop: &Stop{
+			Status: int(stop.Status()),
+			Header: http.Header(header),
+			Body:   stop.BodyBytes(),
+		}}
+	case hrc.ActionRewrite:
+		rewrite := &hrc.Rewrite{}
+		rewrite.Init(tab.Bytes, tab.Pos)
+		header, delHeader := decodeTextEntries(rewrite.HeadersLength(), rewrite.Headers)
+		args, delArgs := decodeTextEntries(rewrite.ArgsLength(), rewrite.Args)
+		respHeader, _ := decodeTextEntries(rewrite.RespHeadersLength(), rewrite.RespHeaders)
+		return &Result{Rewrite: &Rewrite{
+			Path:       string(rewrite.Path()),
+			Header:     http.Header(header),
+			DelHeader:  delHeader,
+			Args:       url.Values(args),
+			DelArgs:    delArgs,
+			RespHeader: http.Header(respHeader),
+			Body:       rewrite.BodyBytes(),
+		}}
+	}
+	return &Result{}
+}
+
+// decodeTextEntries returns the entries set, and the names of the ones without value, which
+// means deleted
+func decodeTextEntries(n int, get func(obj *A6.TextEntry, j int) bool) (map[string][]string, []string) {
+	var set map[string][]string
+	var deleted []string
+	te := &A6.TextEntry{}
+	for i := 0; i < n; i++ {
+		if !get(te, i) {
+			continue
+		}
+		name := string(te.Name())
+		v := te.Value()
+		if v == nil {
+			deleted = append(deleted, name)
+			continue
+		}
+		if set == nil {
+			set = map[string][]string{}
+		}
+		set[name] = append(set[name], string(v))
+	}
+	sort.Strings(deleted)
+	return set, deleted
+}
+
+// String renders the result as a diff against the original request, sorted by the names,
+// so that it can be compared with the golden file.
+func (res *Result) String() string {
+	var sb strings.Builder
+	switch {
+	case res.Stop != nil:
+		fmt.Fprintf(&sb, "stop %d\n", res.Stop.Status)
+		writeEntries(&sb, "+header", res.Stop.Header)
+		writeBody(&sb, res.Stop.Body)
+	case res.Rewrite != nil:
+		rw := res.Rewrite
+		sb.WriteString("rewrite\n")
+		if rw.Path != "" {
+			fmt.Fprintf(&sb, "path %s\n", rw.Path)
+		}
+		writeEntries(&sb, "+header", rw.Header)
+		for _, k := range rw.DelHeader {
+			fmt.Fprintf(&sb, "-header %s\n", k)
+		}
+		writeEntries(&sb, "+arg", rw.Args)
+		for _, k := range rw.DelArgs {
+			fmt.Fprintf(&sb, "-arg %s\n", k)
+		}
+		writeEntries(&sb, "+resp-header", rw.RespHeader)
+		writeBody(&sb, rw.Body)
+	default:
+		sb.WriteString("pass\n")
+	}
+	return sb.String()
+}
+
+func writeEntries(sb *strings.Builder, prefix string, kvs map[string][]string) {
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range kvs[k] {
+			fmt.Fprintf(sb, "%s %s: %s\n", prefix, k, v)
+		}
+	}
+}
+
+func writeBody(sb *strings.Builder, body []byte) {
+	if body != nil {
+		fmt.Fprintf(sb, "body %q\n", body)
+	}
+}
+
+// RespResult is the changes of the response to HTTPRespCall. All the fields are empty if the
+// response from the upstream is sent to the client unchanged.
+type RespResult struct {
+	// Status is the new status code, or zero if it is not changed
+	Status int
+	// Header contains the headers set, and DelHeader the names of the deleted ones
+	Header    http.Header
+	DelHeader []string
+	// Body is the new body, or nil if the body is not changed
+	Body []byte
+}
+
+// DecodeRespResult decodes the response to HTTPRespCall
+func DecodeRespResult(buf []byte) *RespResult {
+	resp := hrespc.GetRootAsResp(buf, 0)
+	header, delHeader := decodeTextEntries(resp.HeadersLength(), resp.Headers)
+	return &RespResult{
+		Status:    int(resp.Status()),
+		Header:    http.Header(header),
+		DelHeader: delHeader,
+		Body:      resp.BodyBytes(),
+	}
+}
+
+// String renders the result like Result.String
+func (res *RespResult) String() string {
+	if res.Status == 0 && res.Header == nil && res.DelHeader == nil && res.Body == nil {
+		return "pass\n"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("rewrite-response\n")
+	if res.Status != 0 {
+		fmt.Fprintf(&sb, "status %d\n", res.Status)
+	}
+	writeEntries(&sb, "+header", res.Header)
+	for _, k := range res.DelHeader {
+		fmt.Fprintf(&sb, "-header %s\n", k)
+	}
+	writeBody(&sb, res.Body)
+	return sb.String()
+}
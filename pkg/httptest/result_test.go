@@ -0,0 +1,47 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httptest
+
+import (
+	"net/http"
+	"testing"
+
+	hrespc "github.com/api7/ext-plugin-proto/go/A6/HTTPRespCall"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeRespResult(t *testing.T) {
+	builder := flatbuffers.NewBuilder(1024)
+	hrespc.RespStart(builder)
+	hrespc.RespAddId(builder, 1)
+	builder.Finish(hrespc.RespEnd(builder))
+	res := DecodeRespResult(builder.FinishedBytes())
+	assert.Equal(t, "pass\n", res.String())
+
+	builder = flatbuffers.NewBuilder(1024)
+	body := builder.CreateByteVector([]byte("hi"))
+	hrespc.RespStart(builder)
+	hrespc.RespAddId(builder, 1)
+	hrespc.RespAddStatus(builder, http.StatusTeapot)
+	hrespc.RespAddBody(builder, body)
+	builder.Finish(hrespc.RespEnd(builder))
+	res = DecodeRespResult(builder.FinishedBytes())
+	assert.Equal(t, http.StatusTeapot, res.Status)
+	assert.Equal(t, "rewrite-response\nstatus 418\nbody \"hi\"\n", res.String())
+}
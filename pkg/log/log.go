@@ -36,13 +36,19 @@ func SetLogger(l *zap.SugaredLogger) {
 }
 
 func NewLogger(level zapcore.Level, out zapcore.WriteSyncer) {
+	newLogger(level, out, zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()))
+}
+
+// NewJSONLogger is like NewLogger, but the logs are encoded in JSON, one object per line
+func NewJSONLogger(level zapcore.Level, out zapcore.WriteSyncer) {
+	newLogger(level, out, zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()))
+}
+
+func newLogger(level zapcore.Level, out zapcore.WriteSyncer, enc zapcore.Encoder) {
 	var atomicLevel = zap.NewAtomicLevel()
 	atomicLevel.SetLevel(level)
 
-	core := zapcore.NewCore(
-		zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()),
-		out,
-		atomicLevel)
+	core := zapcore.NewCore(enc, out, atomicLevel)
 	lg := zap.New(core, zap.AddStacktrace(zap.ErrorLevel), zap.AddCaller(), zap.AddCallerSkip(1))
 	logger = lg.Sugar()
 }
@@ -50,8 +56,9 @@ func NewLogger(level zapcore.Level, out zapcore.WriteSyncer) {
 func GetLogger() *zap.SugaredLogger {
 	loggerInit.Do(func() {
 		if logger == nil {
-			// logger is not initialized, for example, running `go test`
-			NewLogger(zapcore.InfoLevel, os.Stdout)
+			// logger is not initialized, for example, running `go test` or registering the
+			// plugins in init. Log to stderr, as the stdout may be the output of a command
+			NewLogger(zapcore.InfoLevel, os.Stderr)
 		}
 	})
 	return logger
@@ -19,6 +19,7 @@ package plugin
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
 	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
@@ -47,10 +48,108 @@ type Plugin interface {
 	ResponseFilter(conf interface{}, w pkgHTTP.Response)
 }
 
+// Initializer is an optional interface implemented by the Plugin.
+type Initializer interface {
+	// Init is called before the runner starts listening, for example, to open a DB pool.
+	// The runner exits if it returns an error.
+	Init() error
+}
+
+// SettingsInitializer is an optional interface implemented by the Plugin.
+type SettingsInitializer interface {
+	// InitSettings is called with the plugin's global settings in JSON before Init, like the
+	// `plugins` section of the runner's config file or `RunnerConfig.PluginSettings`.
+	// The settings are nil if they are not configured. The runner exits if it returns an error.
+	InitSettings(settings []byte) error
+}
+
+// Closer is an optional interface implemented by the Plugin.
+type Closer interface {
+	// Close is called when the runner is shutting down, after the in-flight requests are drained
+	// and the confs are released. It is not called if the requests are still running after the
+	// drain timeout and their contexts are canceled, as they may still use the plugin.
+	Close() error
+}
+
+// ConfReleaser is an optional interface implemented by the Plugin.
+type ConfReleaser interface {
+	// ReleaseConf is called with the conf created by ParseConf when the conf is expired or evicted
+	// from the cache, or the runner is shutting down. It can be used to release the per-route resources.
+	//
+	// The conf may still be used by the requests in flight when it is expired.
+	ReleaseConf(conf interface{})
+}
+
+// FailurePolicy decides how the plugin chain goes on when the filter of the plugin fails.
+type FailurePolicy struct {
+	// FailOpen skips the failed plugin and runs the next one (fail-open). The changes to the
+	// response made by the panicked plugin are dropped, but the ones to the request are kept.
+	// All the changes made by the timed out plugin are dropped.
+	// Otherwise, the request is stopped with Status (fail-closed).
+	FailOpen bool
+	// Status is the status code to stop the request with when the policy is fail-closed.
+	// Default to 500 for the panic, and 504 for the timeout.
+	Status int
+}
+
+// PanicPolicyProvider is an optional interface implemented by the Plugin or TypedPlugin.
+type PanicPolicyProvider interface {
+	// PanicPolicy returns the policy applied when the filters of the plugin panic.
+	// The panic is always recovered, logged with the stack and counted, so it doesn't
+	// break the connection shared by other requests. By default, the request is stopped with 500.
+	PanicPolicy() FailurePolicy
+}
+
+// TimeoutProvider is an optional interface implemented by the Plugin or TypedPlugin.
+type TimeoutProvider interface {
+	// Timeout returns the max execution time of the filters, and the policy applied when it
+	// is exceeded. The filter's context is done at the timeout. As a filter ignoring the
+	// context can't be stopped, the request is left to it and its changes are dropped. With
+	// FailOpen, the following plugins run with a copy of the request taken before the filter,
+	// which costs a copy per filter. By default, the request is stopped with 504.
+	Timeout() (time.Duration, FailurePolicy)
+}
+
+// ConfTimeout is an optional interface implemented by the conf created by ParseConf, so that
+// the timeout can be set per route. The positive FilterTimeout overrides the one of
+// TimeoutProvider, and the policy of TimeoutProvider still applies.
+type ConfTimeout interface {
+	FilterTimeout() time.Duration
+}
+
 // RegisterPlugin register a plugin. Plugin which has the same name can't be registered twice.
 // This method should be called before calling `runner.Run`.
 func RegisterPlugin(p Plugin) error {
-	return plugin.RegisterPlugin(p.Name(), p.ParseConf, p.RequestFilter, p.ResponseFilter)
+	opts := pluginOptions(p)
+	if r, ok := p.(ConfReleaser); ok {
+		opts = append(opts, plugin.WithReleaseConf(r.ReleaseConf))
+	}
+	return plugin.RegisterPlugin(p.Name(), p.ParseConf, p.RequestFilter, p.ResponseFilter, opts...)
+}
+
+// pluginOptions returns the options of the optional interfaces shared by Plugin and TypedPlugin
+func pluginOptions(p interface{}) []plugin.Option {
+	var opts []plugin.Option
+	if i, ok := p.(Initializer); ok {
+		opts = append(opts, plugin.WithInit(i.Init))
+	}
+	if i, ok := p.(SettingsInitializer); ok {
+		opts = append(opts, plugin.WithInitSettings(i.InitSettings))
+	}
+	if c, ok := p.(Closer); ok {
+		opts = append(opts, plugin.WithClose(c.Close))
+	}
+	if s, ok := p.(SchemaProvider); ok {
+		opts = append(opts, plugin.WithSchema(s.Schema()))
+	}
+	if pp, ok := p.(PanicPolicyProvider); ok {
+		opts = append(opts, plugin.WithPanicPolicy(plugin.FailurePolicy(pp.PanicPolicy())))
+	}
+	if tp, ok := p.(TimeoutProvider); ok {
+		timeout, policy := tp.Timeout()
+		opts = append(opts, plugin.WithTimeout(timeout, plugin.FailurePolicy(policy)))
+	}
+	return opts
 }
 
 // DefaultPlugin provides the no-op implementation of the Plugin interface.
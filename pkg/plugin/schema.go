@@ -0,0 +1,261 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+)
+
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// SchemaProvider is an optional interface implemented by the Plugin or TypedPlugin.
+type SchemaProvider interface {
+	// Schema returns the JSON Schema of the configuration. The raw configuration is
+	// validated with it before calling ParseConf, and the configuration which doesn't
+	// match is handled like the one ParseConf fails to parse.
+	Schema() []byte
+}
+
+// Schemas returns the JSON Schema of the registered plugins which have one, keyed by the plugin name
+func Schemas() map[string][]byte {
+	return plugin.PluginSchemas()
+}
+
+// SchemaOf derives the JSON Schema from the type of v, a struct or a pointer to it.
+// The properties are named after the `json` tags, and the `validate` tags are
+// translated to the keywords:
+//
+//	required    required
+//	min=N       minimum, minLength, minItems or minProperties, according to the field type
+//	max=N       maximum, maxLength, maxItems or maxProperties, according to the field type
+//	oneof=a b   enum
+//
+// The schema accepts the same configuration as Validate: the field whose zero value is refused
+// is required, and the pointer, slice and map fields accept null unless they are required.
+func SchemaOf(v interface{}) ([]byte, error) {
+	t := indirectType(reflect.TypeOf(v))
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can't derive schema from %s", t)
+	}
+
+	s, err := typeSchema(t, map[reflect.Type]bool{})
+	if err != nil {
+		return nil, err
+	}
+	s["$schema"] = schemaDraft
+	return json.Marshal(s)
+}
+
+type jsonSchema map[string]interface{}
+
+// nullable reports whether the type is decoded from JSON null, which is skipped by Validate
+// unless the field is required
+func nullable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+func allowNull(s jsonSchema) {
+	if ty, ok := s["type"].(string); ok {
+		s["type"] = []string{ty, "null"}
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		s["enum"] = append(enum, nil)
+	}
+}
+
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) (jsonSchema, error) {
+	s, err := valueSchema(indirectType(t), visiting)
+	if err != nil {
+		return nil, err
+	}
+	if nullable(t) {
+		allowNull(s)
+	}
+	return s, nil
+}
+
+func valueSchema(t reflect.Type, visiting map[reflect.Type]bool) (jsonSchema, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}, nil
+	case reflect.String:
+		return jsonSchema{"type": "string"}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded as base64 string
+			return jsonSchema{"type": "string"}, nil
+		}
+		items, err := typeSchema(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return jsonSchema{"type": "array", "items": items}, nil
+	case reflect.Map:
+		values, err := typeSchema(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return jsonSchema{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		if visiting[t] {
+			// recursive type
+			return jsonSchema{"type": "object"}, nil
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		s := jsonSchema{"type": "object"}
+		props := jsonSchema{}
+		required := []string{}
+		if err := structProperties(t, props, &required, visiting); err != nil {
+			return nil, err
+		}
+		s["properties"] = props
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s, nil
+	default:
+		// interface{} accepts any value
+		return jsonSchema{}, nil
+	}
+}
+
+func structProperties(t reflect.Type, props jsonSchema, required *[]string, visiting map[reflect.Type]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		name, inline := jsonFieldName(f)
+		if name == "-" {
+			continue
+		}
+		if inline {
+			if err := structProperties(indirectType(f.Type), props, required, visiting); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, err := valueSchema(indirectType(f.Type), visiting)
+		if err != nil {
+			return err
+		}
+		rules := &fieldRules{}
+		if tag, ok := f.Tag.Lookup(ValidateTag); ok {
+			rules, err = parseFieldRules(tag)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", f.Name, err)
+			}
+			if err := rules.apply(s, f.Type); err != nil {
+				return fmt.Errorf("field %s: %w", f.Name, err)
+			}
+		}
+		// the missing field is decoded as the zero value, so it is required when
+		// the zero value is refused by Validate
+		if rules.check(reflect.Zero(f.Type)) != "" {
+			*required = append(*required, name)
+		}
+		if nullable(f.Type) && !rules.required {
+			allowNull(s)
+		}
+		props[name] = s
+	}
+	return nil
+}
+
+// apply translates the rules of the field typed t into the keywords of its schema s,
+// so that the schema accepts the same values as Validate
+func (rules *fieldRules) apply(s jsonSchema, t reflect.Type) error {
+	var minKey, maxKey string
+	switch s["type"] {
+	case "integer", "number":
+		minKey, maxKey = "minimum", "maximum"
+	case "string":
+		minKey, maxKey = "minLength", "maxLength"
+	case "array":
+		minKey, maxKey = "minItems", "maxItems"
+	case "object":
+		minKey, maxKey = "minProperties", "maxProperties"
+	}
+	if minKey != "" && rules.min != nil {
+		s[minKey] = *rules.min
+	}
+	if maxKey != "" && rules.max != nil {
+		s[maxKey] = *rules.max
+	}
+
+	if len(rules.oneOf) > 0 {
+		enum := make([]interface{}, 0, len(rules.oneOf))
+		for _, o := range rules.oneOf {
+			if s["type"] == "integer" || s["type"] == "number" {
+				if n, err := strconv.ParseFloat(o, 64); err == nil {
+					enum = append(enum, n)
+					continue
+				}
+			}
+			enum = append(enum, o)
+		}
+		if !nullable(t) && !rules.required {
+			// oneof is skipped for the zero value
+			enum = append(enum, reflect.Zero(t).Interface())
+		}
+		s["enum"] = enum
+	}
+
+	if !rules.required {
+		return nil
+	}
+	// required refuses the zero value, which is valid JSON for the types not nullable
+	switch t.Kind() {
+	case reflect.Interface:
+		s["not"] = jsonSchema{"type": "null"}
+	case reflect.String:
+		if n, ok := s["minLength"].(float64); !ok || n < 1 {
+			s["minLength"] = float64(1)
+		}
+	case reflect.Bool:
+		s["const"] = true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		inRange := &fieldRules{min: rules.min, max: rules.max}
+		if inRange.check(reflect.Zero(t)) == "" {
+			s["not"] = jsonSchema{"const": 0}
+		}
+	case reflect.Struct, reflect.Array:
+		return errRequiredNotSupported
+	}
+	return nil
+}
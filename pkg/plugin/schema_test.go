@@ -0,0 +1,199 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type schemaNode struct {
+	Name     string        `json:"name" validate:"required,min=1"`
+	Children []*schemaNode `json:"children"`
+}
+
+type schemaConf struct {
+	validateBase
+	Status  int               `json:"status" validate:"min=200,max=599"`
+	Level   int               `json:"level" validate:"oneof=1 2"`
+	Scope   string            `json:"scope" validate:"oneof=once global"`
+	Filters []validateFilter  `json:"filters" validate:"max=2"`
+	Headers map[string]string `json:"headers" validate:"min=1"`
+	Raw     []byte            `json:"raw"`
+	Any     interface{}       `json:"any"`
+	Tree    schemaNode        `json:"tree"`
+	Ignored string            `json:"-"`
+}
+
+func TestSchemaOf(t *testing.T) {
+	b, err := SchemaOf(&schemaConf{})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "maxLength": 5},
+			"status": {"type": "integer", "minimum": 200, "maximum": 599},
+			"level": {"type": "integer", "enum": [1, 2, 0]},
+			"scope": {"type": "string", "enum": ["once", "global", ""]},
+			"filters": {
+				"type": ["array", "null"],
+				"maxItems": 2,
+				"items": {
+					"type": "object",
+					"properties": {
+						"regex": {"type": "string", "minLength": 1},
+						"scope": {"type": "string", "enum": ["once", "global", ""]}
+					},
+					"required": ["regex"]
+				}
+			},
+			"headers": {"type": ["object", "null"], "minProperties": 1, "additionalProperties": {"type": "string"}},
+			"raw": {"type": ["string", "null"]},
+			"any": {},
+			"tree": {
+				"type": "object",
+				"properties": {
+					"name": {"type": "string", "minLength": 1},
+					"children": {"type": ["array", "null"], "items": {"type": ["object", "null"]}}
+				},
+				"required": ["name"]
+			}
+		},
+		"required": ["name", "status"]
+	}`, string(b))
+
+	_, err = SchemaOf(1)
+	assert.NotNil(t, err)
+}
+
+type schemaPlugin struct {
+	DefaultTypedPlugin[schemaConf]
+}
+
+func (p *schemaPlugin) Name() string {
+	return "typed-schema"
+}
+
+func TestRegisterTyped_DerivedSchema(t *testing.T) {
+	err := RegisterTyped[schemaConf](&schemaPlugin{})
+	assert.Nil(t, err)
+
+	expected, _ := SchemaOf(schemaConf{})
+	assert.Equal(t, expected, Schemas()["typed-schema"])
+}
+
+type agreeConf struct {
+	Name   string            `json:"name" validate:"required,max=5"`
+	Status int               `json:"status" validate:"min=200,max=599"`
+	Port   *int              `json:"port" validate:"min=1"`
+	Level  int               `json:"level" validate:"oneof=1 2"`
+	Scope  *string           `json:"scope" validate:"oneof=once global"`
+	Count  int               `json:"count" validate:"required"`
+	Flag   bool              `json:"flag" validate:"required"`
+	Tags   []string          `json:"tags" validate:"min=1"`
+	Sub    *validateFilter   `json:"sub"`
+	Must   *validateFilter   `json:"must" validate:"required"`
+	Meta   map[string]string `json:"meta"`
+	Any    interface{}       `json:"any" validate:"required"`
+}
+
+// TestSchemaOf_AgreesWithValidate feeds the same confs to the derived schema and DecodeConf
+func TestSchemaOf_AgreesWithValidate(t *testing.T) {
+	b, err := SchemaOf(agreeConf{})
+	assert.Nil(t, err)
+	c := jsonschema.NewCompiler()
+	assert.Nil(t, c.AddResource("agree.json", bytes.NewReader(b)))
+	schema, err := c.Compile("agree.json")
+	assert.Nil(t, err)
+
+	valid := `{"name":"foo","status":200,"count":1,"flag":true,"must":{"regex":"a"},"any":1}`
+	// each case overrides the fields of the valid conf, and "-" removes the field
+	cases := []string{
+		`{}`,
+		`{"name":"-"}`,
+		`{"name":null}`,
+		`{"name":""}`,
+		`{"name":"foobar"}`,
+		`{"status":"-"}`,
+		`{"status":null}`,
+		`{"status":0}`,
+		`{"status":600}`,
+		`{"port":null}`,
+		`{"port":0}`,
+		`{"port":80}`,
+		`{"level":0}`,
+		`{"level":2}`,
+		`{"level":3}`,
+		`{"scope":null}`,
+		`{"scope":""}`,
+		`{"scope":"once"}`,
+		`{"count":"-"}`,
+		`{"count":0}`,
+		`{"flag":false}`,
+		`{"tags":null}`,
+		`{"tags":[]}`,
+		`{"tags":["a"]}`,
+		`{"sub":null}`,
+		`{"sub":{}}`,
+		`{"sub":{"regex":"a","scope":""}}`,
+		`{"sub":{"regex":"a","scope":"all"}}`,
+		`{"must":"-"}`,
+		`{"must":null}`,
+		`{"must":{}}`,
+		`{"meta":null}`,
+		`{"meta":{}}`,
+		`{"any":"-"}`,
+		`{"any":null}`,
+		`{"any":0}`,
+	}
+	for _, override := range cases {
+		conf := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal([]byte(valid), &conf))
+		var fields map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(override), &fields))
+		for k, v := range fields {
+			if v == "-" {
+				delete(conf, k)
+				continue
+			}
+			conf[k] = v
+		}
+		in, _ := json.Marshal(conf)
+
+		var v interface{}
+		assert.Nil(t, json.Unmarshal(in, &v))
+		schemaErr := schema.Validate(v)
+		_, decodeErr := DecodeConf[agreeConf](in)
+		assert.Equal(t, schemaErr == nil, decodeErr == nil,
+			"%s: schema: %v, validate: %v", in, schemaErr, decodeErr)
+	}
+}
+
+func TestSchemaOf_RequiredStruct(t *testing.T) {
+	type conf struct {
+		Sub validateFilter `json:"sub" validate:"required"`
+	}
+	_, err := SchemaOf(conf{})
+	assert.NotNil(t, err)
+	assert.NotNil(t, Validate(conf{Sub: validateFilter{Regex: "a"}}))
+}
@@ -0,0 +1,113 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
+)
+
+// TypedPlugin is like Plugin, but the configuration is passed as C instead of interface{},
+// so the filters don't need to cast it. By default, the configuration is decoded from JSON
+// and checked by DecodeConf. The plugin can implement TypedConfParser to parse it by itself.
+type TypedPlugin[C any] interface {
+	// Name returns the plugin name
+	Name() string
+
+	// RequestFilter is the method to handle request. See Plugin.RequestFilter.
+	RequestFilter(conf C, w http.ResponseWriter, r pkgHTTP.Request)
+
+	// ResponseFilter is the method to handle response. See Plugin.ResponseFilter.
+	ResponseFilter(conf C, w pkgHTTP.Response)
+}
+
+// TypedConfParser is an optional interface implemented by the TypedPlugin.
+type TypedConfParser[C any] interface {
+	// ParseConf is the method to parse given plugin configuration, instead of DecodeConf.
+	// When the configuration can't be parsed, it will be skipped.
+	ParseConf(in []byte) (C, error)
+}
+
+// TypedConfReleaser is the ConfReleaser of the TypedPlugin.
+type TypedConfReleaser[C any] interface {
+	ReleaseConf(conf C)
+}
+
+// RegisterTyped registers a TypedPlugin. Like RegisterPlugin, the plugin can also implement
+// Initializer, SettingsInitializer, Closer, SchemaProvider, PanicPolicyProvider, TimeoutProvider
+// and TypedConfReleaser.
+// If the plugin implements neither TypedConfParser nor SchemaProvider, the schema is derived
+// from C via SchemaOf, as the configuration is decoded from JSON by DecodeConf.
+// This method should be called before calling `runner.Run`.
+func RegisterTyped[C any](p TypedPlugin[C]) error {
+	opts := pluginOptions(p)
+	parse := DecodeConf[C]
+	if cp, ok := p.(TypedConfParser[C]); ok {
+		parse = cp.ParseConf
+	} else if _, ok := p.(SchemaProvider); !ok {
+		var conf C
+		if indirectType(reflect.TypeOf(&conf).Elem()).Kind() == reflect.Struct {
+			schema, err := SchemaOf(&conf)
+			if err != nil {
+				return fmt.Errorf("failed to derive schema for plugin %s: %w", p.Name(), err)
+			}
+			opts = append(opts, plugin.WithSchema(schema))
+		}
+	}
+	if r, ok := p.(TypedConfReleaser[C]); ok {
+		opts = append(opts, plugin.WithReleaseConf(func(conf interface{}) {
+			r.ReleaseConf(conf.(C))
+		}))
+	}
+
+	// the conf passed to the filters always comes from ParseConf, so the cast is safe
+	pc := func(in []byte) (interface{}, error) {
+		return parse(in)
+	}
+	sv := func(conf interface{}, w http.ResponseWriter, r pkgHTTP.Request) {
+		p.RequestFilter(conf.(C), w, r)
+	}
+	rsv := func(conf interface{}, w pkgHTTP.Response) {
+		p.ResponseFilter(conf.(C), w)
+	}
+	return plugin.RegisterPlugin(p.Name(), pc, sv, rsv, opts...)
+}
+
+// DecodeConf decodes the JSON configuration into C and checks it with Validate.
+// It is the default ParseConf of the TypedPlugin.
+func DecodeConf[C any](in []byte) (C, error) {
+	var conf C
+	if err := json.Unmarshal(in, &conf); err != nil {
+		return conf, err
+	}
+	if err := Validate(conf); err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+// DefaultTypedPlugin provides the no-op implementation of the filters of the TypedPlugin.
+type DefaultTypedPlugin[C any] struct{}
+
+func (*DefaultTypedPlugin[C]) RequestFilter(C, http.ResponseWriter, pkgHTTP.Request) {}
+func (*DefaultTypedPlugin[C]) ResponseFilter(C, pkgHTTP.Response)                    {}
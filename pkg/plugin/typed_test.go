@@ -0,0 +1,128 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	A6 "github.com/api7/ext-plugin-proto/go/A6"
+	hreqc "github.com/api7/ext-plugin-proto/go/A6/HTTPReqCall"
+	pc "github.com/api7/ext-plugin-proto/go/A6/PrepareConf"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/plugin"
+	pkgHTTP "github.com/apache/apisix-go-plugin-runner/pkg/http"
+)
+
+type typedConf struct {
+	Status int `json:"status" validate:"required,min=200"`
+}
+
+type typedPlugin struct {
+	DefaultTypedPlugin[typedConf]
+}
+
+func (p *typedPlugin) Name() string {
+	return "typed"
+}
+
+func (p *typedPlugin) RequestFilter(conf typedConf, w http.ResponseWriter, r pkgHTTP.Request) {
+	w.WriteHeader(conf.Status)
+}
+
+func TestDecodeConf(t *testing.T) {
+	conf, err := DecodeConf[typedConf]([]byte(`{"status":403}`))
+	assert.Nil(t, err)
+	assert.Equal(t, typedConf{Status: 403}, conf)
+
+	_, err = DecodeConf[typedConf]([]byte(`{"status":"403"}`))
+	assert.NotNil(t, err)
+
+	_, err = DecodeConf[typedConf]([]byte(`{}`))
+	assert.Equal(t, ValidationError{Path: "status", Msg: "is required"}, err)
+}
+
+// textPlugin parses the conf in its own format, so it gets no derived schema
+type textPlugin struct {
+	DefaultTypedPlugin[typedConf]
+}
+
+func (p *textPlugin) Name() string {
+	return "typed-text"
+}
+
+func (p *textPlugin) ParseConf(in []byte) (typedConf, error) {
+	n, err := strconv.Atoi(string(in))
+	return typedConf{Status: n}, err
+}
+
+func TestRegisterTyped_ConfParser(t *testing.T) {
+	err := RegisterTyped[typedConf](&textPlugin{})
+	assert.Nil(t, err)
+	_, ok := Schemas()["typed-text"]
+	assert.False(t, ok)
+
+	plugin.InitConfCache(time.Second)
+	bd := flatbuffers.NewBuilder(1024)
+	name := bd.CreateString("typed-text")
+	value := bd.CreateString("403")
+	A6.TextEntryStart(bd)
+	A6.TextEntryAddName(bd, name)
+	A6.TextEntryAddValue(bd, value)
+	te := A6.TextEntryEnd(bd)
+	pc.ReqStartConfVector(bd, 1)
+	bd.PrependUOffsetT(te)
+	confs := bd.EndVector(1)
+	pc.ReqStart(bd)
+	pc.ReqAddConf(bd, confs)
+	bd.Finish(pc.ReqEnd(bd))
+
+	b, err := plugin.PrepareConf(bd.FinishedBytes())
+	assert.Nil(t, err)
+	token := pc.GetRootAsResp(b.FinishedBytes(), 0).ConfToken()
+	rc, err := plugin.GetRuleConf(token)
+	assert.Nil(t, err)
+	assert.Equal(t, typedConf{Status: 403}, rc[0].Value)
+}
+
+func TestRegisterTyped(t *testing.T) {
+	err := RegisterTyped[typedConf](&typedPlugin{})
+	assert.Nil(t, err)
+	err = RegisterTyped[typedConf](&typedPlugin{})
+	assert.Equal(t, "plugin typed registered", err.Error())
+
+	plugin.InitConfCache(10 * time.Millisecond)
+	plugin.SetRuleConfInTest(1, plugin.RuleConf{{Name: "typed", Value: typedConf{Status: 403}}})
+
+	builder := flatbuffers.NewBuilder(1024)
+	hreqc.ReqStart(builder)
+	hreqc.ReqAddId(builder, 233)
+	hreqc.ReqAddConfToken(builder, 1)
+	r := hreqc.ReqEnd(builder)
+	builder.Finish(r)
+
+	b, err := plugin.HTTPReqCall(context.Background(), builder.FinishedBytes(), nil)
+	assert.Nil(t, err)
+	resp := hreqc.GetRootAsResp(b.FinishedBytes(), 0)
+	assert.Equal(t, hreqc.ActionStop, resp.ActionType())
+}
@@ -0,0 +1,269 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ValidateTag is the struct tag checked by Validate. The rules are separated by comma:
+//
+//	required    the field can't be the zero value, or nil. It is not supported for struct and array.
+//	min=N       the number can't be less than N, or the length of string/slice/map can't be less than N
+//	max=N       the number can't be greater than N, or the length of string/slice/map can't be greater than N
+//	oneof=a b   the string or number must be one of the space-separated values
+//
+// The range rules min and max apply to any value present, including the zero one, and are
+// skipped only for the nil pointer, slice or map. So an optional number with the range rules
+// should be a pointer. The oneof rule is skipped when the field is the zero value.
+//
+// For example:
+//
+//	type Conf struct {
+//		Status *int   `json:"status" validate:"min=200,max=599"`
+//		Scope  string `json:"scope" validate:"oneof=once global"`
+//	}
+const ValidateTag = "validate"
+
+// ValidationError reports the first field which breaks its rule.
+// The Path is composed of the JSON names, like `filters[0].regex`.
+type ValidationError struct {
+	Path string
+	Msg  string
+}
+
+func (err ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Path, err.Msg)
+}
+
+type fieldRules struct {
+	required bool
+	min      *float64
+	max      *float64
+	oneOf    []string
+}
+
+func parseFieldRules(tag string) (*fieldRules, error) {
+	rules := &fieldRules{}
+	for _, r := range strings.Split(tag, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+
+		name, arg := r, ""
+		if i := strings.IndexByte(r, '='); i >= 0 {
+			name, arg = r[:i], r[i+1:]
+		}
+
+		switch name {
+		case "required":
+			rules.required = true
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad rule %s: %w", r, err)
+			}
+			if name == "min" {
+				rules.min = &n
+			} else {
+				rules.max = &n
+			}
+		case "oneof":
+			rules.oneOf = strings.Fields(arg)
+		default:
+			return nil, fmt.Errorf("unknown rule %s", r)
+		}
+	}
+	return rules, nil
+}
+
+// jsonFieldName returns the name used in JSON, or "-" if the field is ignored by JSON.
+// The inline reports whether the field is an embedded struct whose fields are promoted.
+func jsonFieldName(f reflect.StructField) (name string, inline bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "-", false
+	}
+	name = strings.Split(tag, ",")[0]
+	if name == "" {
+		if f.Anonymous && indirectType(f.Type).Kind() == reflect.Struct {
+			return "", true
+		}
+		name = f.Name
+	}
+	return name, false
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// Validate checks the fields of v according to their `validate` tags. It goes into the
+// nested structs, and the elements of slices and maps.
+func Validate(v interface{}) error {
+	return validateValue(reflect.ValueOf(v), "")
+}
+
+func validateValue(v reflect.Value, path string) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return validateStruct(v, path)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			p := fmt.Sprintf("%s[%v]", path, iter.Key().Interface())
+			if err := validateValue(iter.Value(), p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func validateStruct(v reflect.Value, path string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			// unexported
+			continue
+		}
+
+		name, inline := jsonFieldName(f)
+		if name == "-" {
+			continue
+		}
+		fieldPath := path
+		if !inline {
+			fieldPath = joinPath(path, name)
+		}
+
+		fv := v.Field(i)
+		if tag, ok := f.Tag.Lookup(ValidateTag); ok {
+			rules, err := parseFieldRules(tag)
+			if err != nil {
+				return ValidationError{Path: fieldPath, Msg: err.Error()}
+			}
+			if msg := rules.check(fv); msg != "" {
+				return ValidationError{Path: fieldPath, Msg: msg}
+			}
+		}
+
+		if err := validateValue(fv, fieldPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errRequiredNotSupported = errors.New("rule required is not supported for struct or array, use a pointer")
+
+func (rules *fieldRules) check(v reflect.Value) string {
+	if rules.required && (v.Kind() == reflect.Struct || v.Kind() == reflect.Array) {
+		return errRequiredNotSupported.Error()
+	}
+
+	zero := v.IsZero()
+	if zero && rules.required {
+		return "is required"
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			// the optional field is not set
+			return ""
+		}
+		v = v.Elem()
+	}
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.IsNil() {
+		return ""
+	}
+
+	var n float64
+	what := "value"
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		n = float64(v.Len())
+		what = "length"
+	default:
+		if rules.min != nil || rules.max != nil || len(rules.oneOf) > 0 {
+			return fmt.Sprintf("rules are not supported for %s", v.Kind())
+		}
+		return ""
+	}
+
+	if rules.min != nil && n < *rules.min {
+		return fmt.Sprintf("%s should be >= %v, got %v", what, *rules.min, n)
+	}
+	if rules.max != nil && n > *rules.max {
+		return fmt.Sprintf("%s should be <= %v, got %v", what, *rules.max, n)
+	}
+
+	if len(rules.oneOf) > 0 && !zero {
+		var s string
+		switch {
+		case v.Kind() == reflect.String:
+			s = v.String()
+		case what == "value":
+			s = strconv.FormatFloat(n, 'f', -1, 64)
+		default:
+			return fmt.Sprintf("rule oneof is not supported for %s", v.Kind())
+		}
+		for _, o := range rules.oneOf {
+			if s == o {
+				return ""
+			}
+		}
+		return fmt.Sprintf("should be one of [%s], got %s", strings.Join(rules.oneOf, " "), s)
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validateFilter struct {
+	Regex string `json:"regex" validate:"required"`
+	Scope string `json:"scope" validate:"oneof=once global"`
+}
+
+type validateBase struct {
+	Name string `json:"name" validate:"required,max=5"`
+}
+
+type validateConf struct {
+	validateBase
+	Status  int               `json:"status" validate:"min=200,max=599"`
+	Ratio   float64           `json:"ratio" validate:"max=1"`
+	Level   int               `json:"level" validate:"oneof=1 2"`
+	Port    *int              `json:"port" validate:"min=1"`
+	Tags    []string          `json:"tags" validate:"min=1"`
+	Filters []validateFilter  `json:"filters" validate:"max=2"`
+	Headers map[string]string `json:"headers"`
+	Sub     *validateFilter   `json:"sub"`
+	Ignored string            `json:"-" validate:"required"`
+	private string
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() validateConf {
+		port := 80
+		return validateConf{
+			validateBase: validateBase{Name: "foo"},
+			Status:       200,
+			Level:        2,
+			Port:         &port,
+			Tags:         []string{"a"},
+			Filters:      []validateFilter{{Regex: "a", Scope: "once"}},
+		}
+	}
+
+	cases := []struct {
+		name   string
+		modify func(c *validateConf)
+		err    string
+	}{
+		{"valid", func(c *validateConf) {}, ""},
+		{"optional field", func(c *validateConf) { c.Level = 0 }, ""},
+		{"optional pointer", func(c *validateConf) { c.Port = nil }, ""},
+		{"zero in range", func(c *validateConf) { c.Status = 0 }, "status: value should be >= 200, got 0"},
+		{"pointer in range", func(c *validateConf) { c.Port = new(int) }, "port: value should be >= 1, got 0"},
+		{"empty slice", func(c *validateConf) { c.Tags = []string{} }, "tags: length should be >= 1, got 0"},
+		{"nil slice", func(c *validateConf) { c.Tags = nil }, ""},
+		{"embedded", func(c *validateConf) { c.Name = "" }, "name: is required"},
+		{"string length", func(c *validateConf) { c.Name = "foobar" }, "name: length should be <= 5, got 6"},
+		{"min", func(c *validateConf) { c.Status = 100 }, "status: value should be >= 200, got 100"},
+		{"float", func(c *validateConf) { c.Ratio = 1.5 }, "ratio: value should be <= 1, got 1.5"},
+		{"oneof number", func(c *validateConf) { c.Level = 3 }, "level: should be one of [1 2], got 3"},
+		{"slice length", func(c *validateConf) {
+			c.Filters = append(c.Filters, c.Filters[0], c.Filters[0])
+		}, "filters: length should be <= 2, got 3"},
+		{"slice elem", func(c *validateConf) {
+			c.Filters[0].Scope = "all"
+		}, "filters[0].scope: should be one of [once global], got all"},
+		{"pointer", func(c *validateConf) {
+			c.Sub = &validateFilter{}
+		}, "sub.regex: is required"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := valid()
+			tc.modify(&c)
+			err := Validate(c)
+			if tc.err == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.Equal(t, tc.err, err.Error())
+				// pointer is accepted too
+				assert.Equal(t, err, Validate(&c))
+			}
+		})
+	}
+}
+
+func TestValidate_BadRule(t *testing.T) {
+	type conf struct {
+		A int `json:"a" validate:"min=x"`
+	}
+	err := Validate(conf{A: 1})
+	assert.Equal(t, "a", err.(ValidationError).Path)
+
+	type conf2 struct {
+		A int `validate:"unknown"`
+	}
+	err = Validate(conf2{})
+	assert.Equal(t, "A: unknown rule unknown", err.Error())
+}
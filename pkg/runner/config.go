@@ -0,0 +1,200 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/server"
+	"github.com/apache/apisix-go-plugin-runner/pkg/log"
+)
+
+const (
+	LogFormatConsole = "console"
+	LogFormatJSON    = "json"
+)
+
+// PluginSettings is the global settings of the plugins in JSON, keyed by the plugin name.
+// The settings written in YAML are converted to JSON.
+type PluginSettings map[string][]byte
+
+func (ps *PluginSettings) UnmarshalYAML(value *yaml.Node) error {
+	var sections map[string]interface{}
+	if err := value.Decode(&sections); err != nil {
+		return err
+	}
+
+	*ps = make(PluginSettings, len(sections))
+	for name, section := range sections {
+		b, err := json.Marshal(normalizeYAML(section))
+		if err != nil {
+			return fmt.Errorf("invalid settings of plugin %s: %w", name, err)
+		}
+		(*ps)[name] = b
+	}
+	return nil
+}
+
+// normalizeYAML converts the map with non-string keys decoded from YAML, so that
+// it can be encoded in JSON
+func normalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = normalizeYAML(e)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalizeYAML(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = normalizeYAML(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// LoadConfig reads the runner's configuration from the YAML or JSON file. The unknown keys
+// are refused. The environment variables, like "APISIX_LISTEN_ADDRESS", take precedence over
+// the file, so that the ones set by APISIX are respected. The fields set after loading take
+// precedence over both, like the flags of `go-runner run`.
+func LoadConfig(path string) (RunnerConfig, error) {
+	var cfg RunnerConfig
+	err := cfg.Load(path)
+	return cfg, err
+}
+
+// Load is like LoadConfig, but the file is loaded over cfg, so that the values which are not
+// set by the file are kept as the defaults.
+func (cfg *RunnerConfig) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// an empty file is a valid config
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return err
+	}
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return nil
+}
+
+func applyEnv(cfg *RunnerConfig) error {
+	for env, v := range map[string]*string{
+		server.SockAddrEnv:        &cfg.ListenAddress,
+		server.MetricsAddrEnv:     &cfg.MetricsAddress,
+		server.TLSCertFileEnv:     &cfg.TLSCertFile,
+		server.TLSKeyFileEnv:      &cfg.TLSKeyFile,
+		server.TLSClientCAFileEnv: &cfg.TLSClientCAFile,
+	} {
+		if s := os.Getenv(env); s != "" {
+			*v = s
+		}
+	}
+
+	if s := os.Getenv(server.ConfCacheTTLEnv); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid %s: %s", server.ConfCacheTTLEnv, s)
+		}
+		cfg.ConfExpireTime = time.Duration(n) * time.Second
+	}
+	return nil
+}
+
+func (cfg *RunnerConfig) validate() error {
+	switch cfg.LogFormat {
+	case "", LogFormatConsole, LogFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.LogFormat)
+	}
+	return nil
+}
+
+// startProfile starts the CPU profiling. The returned function stops it and writes the
+// memory profile. The profiles are written to the files prefixed with prefix.
+func startProfile(prefix string) func() {
+	cpu, err := os.Create(prefix + "cpu")
+	if err != nil {
+		log.Fatalf("could not create CPU profile: %s", err)
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		log.Fatalf("could not start CPU profile: %s", err)
+	}
+
+	return func() {
+		pprof.StopCPUProfile()
+		cpu.Close()
+
+		mem, err := os.Create(prefix + "mem")
+		if err != nil {
+			log.Fatalf("could not create memory profile: %s", err)
+		}
+		defer mem.Close()
+
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(mem); err != nil {
+			log.Fatalf("could not write memory profile: %s", err)
+		}
+	}
+}
+
+// openLogOutput opens the output of log: "stdout", "stderr" or a file
+func openLogOutput(path string) (zapcore.WriteSyncer, error) {
+	switch path {
+	case "", "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
@@ -0,0 +1,149 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/apache/apisix-go-plugin-runner/internal/server"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	path := writeConfig(t, "runner.yaml", `
+listen_address: unix:/tmp/runner.sock
+conf_expire_time: 10m
+log_level: warn
+log_format: json
+log_output: stderr
+metrics_address: ":9091"
+drain_timeout: 3s
+strict_conf: true
+concurrency: 8
+plugins:
+  say:
+    greeting: hi
+    tags: [a, b]
+    1: one
+`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "unix:/tmp/runner.sock", cfg.ListenAddress)
+	assert.Equal(t, 10*time.Minute, cfg.ConfExpireTime)
+	assert.Equal(t, zapcore.WarnLevel, cfg.LogLevel)
+	assert.Equal(t, LogFormatJSON, cfg.LogFormat)
+	assert.Equal(t, "stderr", cfg.LogOutputPath)
+	assert.Equal(t, ":9091", cfg.MetricsAddress)
+	assert.Equal(t, 3*time.Second, cfg.DrainTimeout)
+	assert.True(t, cfg.StrictConf)
+	assert.Equal(t, 8, cfg.Concurrency)
+	assert.JSONEq(t, `{"greeting":"hi","tags":["a","b"],"1":"one"}`, string(cfg.PluginSettings["say"]))
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	path := writeConfig(t, "runner.json", `{
+		"listen_address": "tcp://127.0.0.1:9000",
+		"plugins": {"say": {"greeting": "hi"}}
+	}`)
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp://127.0.0.1:9000", cfg.ListenAddress)
+	assert.JSONEq(t, `{"greeting":"hi"}`, string(cfg.PluginSettings["say"]))
+}
+
+func TestLoadConfigEmpty(t *testing.T) {
+	path := writeConfig(t, "runner.yaml", "# nothing\n")
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "", cfg.ListenAddress)
+}
+
+func TestConfigLoadKeepsDefaults(t *testing.T) {
+	defaults := RunnerConfig{
+		LogLevel:      zapcore.WarnLevel,
+		LogOutputPath: "./logs/runner.log",
+		ProfilePath:   "./logs/profile.",
+	}
+
+	cfg := defaults
+	assert.Nil(t, cfg.Load(writeConfig(t, "runner.yaml", "log_output: stderr\n")))
+	assert.Equal(t, zapcore.WarnLevel, cfg.LogLevel)
+	assert.Equal(t, "stderr", cfg.LogOutputPath)
+	assert.Equal(t, "./logs/profile.", cfg.ProfilePath)
+
+	cfg = defaults
+	assert.Nil(t, cfg.Load(writeConfig(t, "runner.yaml", "log_level: info\nprofile_path: /tmp/runner.\n")))
+	assert.Equal(t, zapcore.InfoLevel, cfg.LogLevel)
+	assert.Equal(t, "./logs/runner.log", cfg.LogOutputPath)
+	assert.Equal(t, "/tmp/runner.", cfg.ProfilePath)
+}
+
+func TestStartProfile(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "profile.")
+	startProfile(prefix)()
+	for _, name := range []string{"cpu", "mem"} {
+		_, err := os.Stat(prefix + name)
+		assert.Nil(t, err, name)
+	}
+}
+
+func TestLoadConfigEnvOverridesFile(t *testing.T) {
+	path := writeConfig(t, "runner.yaml", `
+listen_address: unix:/tmp/runner.sock
+conf_expire_time: 10m
+metrics_address: ":9091"
+`)
+	t.Setenv(server.SockAddrEnv, "unix:/tmp/apisix.sock")
+	t.Setenv(server.ConfCacheTTLEnv, "60")
+
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "unix:/tmp/apisix.sock", cfg.ListenAddress)
+	assert.Equal(t, 60*time.Second, cfg.ConfExpireTime)
+	assert.Equal(t, ":9091", cfg.MetricsAddress)
+
+	t.Setenv(server.ConfCacheTTLEnv, "1a")
+	_, err = LoadConfig(path)
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for _, content := range []string{
+		"listen_addr: unix:/tmp/runner.sock\n",
+		"log_format: xml\n",
+		"drain_timeout: soon\n",
+		"plugins: [say]\n",
+	} {
+		_, err := LoadConfig(writeConfig(t, "runner.yaml", content))
+		assert.NotNil(t, err, content)
+	}
+
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.NotNil(t, err)
+}
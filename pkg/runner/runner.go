@@ -18,36 +18,223 @@
 package runner
 
 import (
-	"os"
+	"context"
+	"time"
 
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/apache/apisix-go-plugin-runner/internal/capture"
 	"github.com/apache/apisix-go-plugin-runner/internal/server"
 	"github.com/apache/apisix-go-plugin-runner/pkg/log"
 )
 
-// RunnerConfig is the configuration of the runner
+// RunnerConfig is the configuration of the runner. It can be loaded from a YAML or JSON file
+// via LoadConfig or RunnerConfig.Load, with the keys in the `yaml` tags.
 type RunnerConfig struct {
+	// ListenAddress is the address to listen, like `unix:/tmp/runner.sock` or `tcp://127.0.0.1:9000`.
+	// Default to the environment variable "APISIX_LISTEN_ADDRESS", which is set by APISIX.
+	ListenAddress string `yaml:"listen_address"`
+	// TLSCertFile, TLSKeyFile and TLSClientCAFile enable TLS for the tcp:// listener, like the
+	// environment variables "APISIX_LISTEN_TLS_*_FILE".
+	TLSCertFile     string `yaml:"tls_cert_file"`
+	TLSKeyFile      string `yaml:"tls_key_file"`
+	TLSClientCAFile string `yaml:"tls_client_ca_file"`
+	// ConfExpireTime is the time which APISIX keeps the conf token, so that the runner caches the
+	// conf a bit longer. Default to the environment variable "APISIX_CONF_EXPIRE_TIME" in seconds.
+	ConfExpireTime time.Duration `yaml:"conf_expire_time"`
+
 	// LogLevel is the level of log, default to `zapcore.InfoLevel`
-	LogLevel zapcore.Level
+	LogLevel zapcore.Level `yaml:"log_level"`
+	// LogFormat is the encoding of log, can be "console" or "json", default to "console"
+	LogFormat string `yaml:"log_format"`
+	// LogOutputPath is the output of log when LogOutput is nil, can be "stdout", "stderr" or
+	// the path of a file which the logs are appended to. Default to "stdout".
+	LogOutputPath string `yaml:"log_output"`
 	// LogOutput is the output of log, default to `os.Stdout`
-	LogOutput zapcore.WriteSyncer
+	LogOutput zapcore.WriteSyncer `yaml:"-"`
 	// Logger will be reused by the framework when it is not nil.
-	Logger *zap.SugaredLogger
+	Logger *zap.SugaredLogger `yaml:"-"`
+
+	// ProfilePath enables the profiling. The CPU profile is written to ProfilePath + "cpu", and
+	// the memory profile is written to ProfilePath + "mem" when Run returns, like
+	// `./logs/profile.cpu` for `./logs/profile.`. The profiling is disabled by default.
+	ProfilePath string `yaml:"profile_path"`
+
+	// Stop triggers the graceful shutdown of the runner when it is closed, like
+	// receiving SIGINT or SIGTERM. Run returns after the shutdown is finished.
+	Stop <-chan struct{} `yaml:"-"`
+	// DrainTimeout is the max time to wait for the in-flight RPCs during the shutdown,
+	// default to 5 seconds. After that, the context of the requests left will be canceled.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+
+	// StrictConf makes the runner refuse the whole conf (APISIX will get an error) when
+	// any plugin's conf can't be parsed or the plugin is not found.
+	// By default, such plugin is skipped with an error log.
+	StrictConf bool `yaml:"strict_conf"`
+	// PluginStrictConf overrides StrictConf for the given plugins, so that we can
+	// only refuse the invalid conf of some plugins, like the authentication ones.
+	PluginStrictConf map[string]bool `yaml:"plugin_strict_conf"`
+
+	// MetricsAddress is the TCP address, like ":9091", to expose the Prometheus metrics
+	// at /metrics. It can also be set via environment variable "GO_RUNNER_METRICS_ADDRESS".
+	// The metrics endpoint is disabled by default.
+	MetricsAddress string `yaml:"metrics_address"`
+
+	// TraceExporter enables the OpenTelemetry tracing. The runner creates a span for each
+	// HTTPReqCall/HTTPRespCall, and a child span for each plugin and extra info request.
+	// The exporter is shut down when Run returns. The tracing is disabled if it is nil.
+	TraceExporter sdktrace.SpanExporter `yaml:"-"`
+
+	// BodyBudget is the max size in bytes of the request or response body which a request
+	// can read from or write to APISIX. The body beyond it is dropped without being read
+	// into the memory, and the plugin gets pkg/common.ErrBodyTooLarge.
+	// Zero means no limit except the one of the protocol, about 16 MiB.
+	BodyBudget int `yaml:"body_budget"`
+
+	// Concurrency is the max number of HTTPReqCall/HTTPRespCall handled concurrently on
+	// each connection from APISIX, so that a slow plugin doesn't block the other requests
+	// sharing the connection. By default, the RPCs on a connection are handled one by one.
+	Concurrency int `yaml:"concurrency"`
+
+	// ReadTimeout is the max time to read a frame expected from APISIX, like the rest of an
+	// RPC after its header or the extra info response. The connection is closed when it is
+	// exceeded. The idle connection waiting for the next RPC is not affected.
+	// Zero means no limit.
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+	// WriteTimeout is the max time to write a frame to APISIX. Zero means no limit.
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// MaxFrameSize is the max size in bytes of a frame from APISIX. The larger RPC is answered
+	// with an error, and the larger extra info response fails the plugin's request. Both are
+	// dropped without being read into the memory.
+	// Zero means no limit except the one of the protocol.
+	MaxFrameSize int `yaml:"max_frame_size"`
+	// MaxConnMemory is the max size in bytes of the frames held by a connection at the
+	// same time, including the in-flight RPCs and their extra info responses. The frame
+	// exceeding it is refused like the one exceeding MaxFrameSize. Zero means no limit.
+	MaxConnMemory int `yaml:"max_conn_memory"`
+
+	// CaptureFile enables recording every frame from and to APISIX into the file, with its
+	// type, length, payload, time and connection id, so that the traffic can be replayed via
+	// `go-runner replay`. The file is rotated when it exceeds CaptureMaxSize. As the frames
+	// contain the requests, the recording should only be enabled during the investigation.
+	CaptureFile string `yaml:"capture_file"`
+	// CaptureMaxSize is the max size in bytes of a capture file, default to 100 MiB
+	CaptureMaxSize int `yaml:"capture_max_size"`
+	// CaptureMaxFiles is the number of the capture files kept, including the one being
+	// written, default to 5
+	CaptureMaxFiles int `yaml:"capture_max_files"`
+	// CaptureRedactHeader masks the value of the request or response header before the frame
+	// is recorded, like MaskHeaders. The value should be modified in place, so that the frame
+	// keeps its length and can still be replayed. The Nginx variables `http_<name>` and
+	// `cookie_<name>` are masked like the header and the `Cookie` header. The query args,
+	// the `arg_<name>` variables and the plugins' confs are never redacted.
+	CaptureRedactHeader func(name string, value []byte) `yaml:"-"`
+	// CaptureRedactBody masks the request or response body in place, like MaskBody
+	CaptureRedactBody func(body []byte) `yaml:"-"`
+
+	// PluginSettings is the global settings of the plugins keyed by the plugin name, which
+	// are handed to the plugins implementing plugin.SettingsInitializer before they are initialized
+	PluginSettings PluginSettings `yaml:"plugins"`
+}
+
+// MaskHeaders returns a CaptureRedactHeader hook which replaces the values of the headers
+// with `*`. The names are case insensitive.
+func MaskHeaders(names ...string) func(name string, value []byte) {
+	return capture.MaskHeaders(names...)
 }
 
-// Run starts the runner and listen the socket configured by environment variable "APISIX_LISTEN_ADDRESS"
+// MaskBody is a CaptureRedactBody hook which replaces the body with `*`
+func MaskBody(body []byte) {
+	capture.MaskBody(body)
+}
+
+// Run starts the runner and listen the socket configured by ListenAddress or environment variable
+// "APISIX_LISTEN_ADDRESS"
 func Run(cfg RunnerConfig) {
 	if cfg.LogOutput == nil {
-		cfg.LogOutput = os.Stdout
+		out, err := openLogOutput(cfg.LogOutputPath)
+		if err != nil {
+			log.Fatalf("failed to open log: %s", err)
+		}
+		cfg.LogOutput = out
 	}
 
-	if cfg.Logger == nil {
-		log.NewLogger(cfg.LogLevel, cfg.LogOutput)
-	} else {
+	if cfg.Logger != nil {
 		log.SetLogger(cfg.Logger)
+	} else if cfg.LogFormat == LogFormatJSON {
+		log.NewJSONLogger(cfg.LogLevel, cfg.LogOutput)
+	} else {
+		log.NewLogger(cfg.LogLevel, cfg.LogOutput)
+	}
+
+	if cfg.ProfilePath != "" {
+		defer startProfile(cfg.ProfilePath)()
+	}
+
+	var tp trace.TracerProvider
+	if cfg.TraceExporter != nil {
+		sdkTP := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(cfg.TraceExporter),
+			sdktrace.WithResource(resource.NewWithAttributes(
+				semconv.SchemaURL,
+				semconv.ServiceNameKey.String("apisix-go-plugin-runner"),
+			)),
+		)
+		defer func() {
+			if err := sdkTP.Shutdown(context.Background()); err != nil {
+				log.Errorf("failed to shutdown tracer provider: %s", err)
+			}
+		}()
+		tp = sdkTP
+	}
+
+	var capt *capture.Options
+	if cfg.CaptureFile != "" {
+		capt = &capture.Options{
+			Path:     cfg.CaptureFile,
+			MaxSize:  cfg.CaptureMaxSize,
+			MaxFiles: cfg.CaptureMaxFiles,
+			Redactor: capture.Redactor{
+				Header: cfg.CaptureRedactHeader,
+				Body:   cfg.CaptureRedactBody,
+			},
+		}
 	}
 
-	server.Run()
+	server.Run(server.Options{
+		ListenAddress: cfg.ListenAddress,
+		TLS: server.TLSOptions{
+			CertFile:     cfg.TLSCertFile,
+			KeyFile:      cfg.TLSKeyFile,
+			ClientCAFile: cfg.TLSClientCAFile,
+		},
+		ConfExpireTime: cfg.ConfExpireTime,
+
+		Stop:         cfg.Stop,
+		DrainTimeout: cfg.DrainTimeout,
+
+		StrictConf:       cfg.StrictConf,
+		PluginStrictConf: cfg.PluginStrictConf,
+
+		MetricsAddress: cfg.MetricsAddress,
+		TracerProvider: tp,
+
+		BodyBudget: cfg.BodyBudget,
+
+		Concurrency: cfg.Concurrency,
+
+		ReadTimeout:   cfg.ReadTimeout,
+		WriteTimeout:  cfg.WriteTimeout,
+		MaxFrameSize:  cfg.MaxFrameSize,
+		MaxConnMemory: cfg.MaxConnMemory,
+
+		Capture: capt,
+
+		PluginSettings: cfg.PluginSettings,
+	})
 }